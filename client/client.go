@@ -0,0 +1,148 @@
+// Package client is a minimal Go client for the bucket server, which signs
+// its requests with the same SigV4 code the server verifies them with.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+type Client struct {
+	endpoint   string
+	signer     *domain.Signer
+	httpClient *http.Client
+}
+
+// New returns a client for the server at the endpoint (e.g.
+// "http://localhost:8000").
+func New(endpoint, accessKey, secretKey, region string) *Client {
+	return &Client{
+		endpoint:   strings.TrimSuffix(endpoint, "/"),
+		signer:     domain.NewSigner(accessKey, secretKey, region),
+		httpClient: &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+type errorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do signs and sends the request. Responses with an error status are turned
+// into a *domain.Error carrying the S3 error code.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body []byte) ([]byte, error) {
+	u := c.endpoint + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	c.signer.Sign(req, body)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		errResp := &errorResponse{}
+		if err := xml.Unmarshal(data, errResp); err != nil || len(errResp.Code) < 1 {
+			return nil, fmt.Errorf("unexpected response status: %d", resp.StatusCode)
+		}
+		return nil, domain.NewError(resp.StatusCode, errResp.Code, errResp.Message)
+	}
+	return data, nil
+}
+
+func objectPath(bucket, key string) string {
+	return "/" + url.PathEscape(bucket) + "/" + url.PathEscape(key)
+}
+
+func (c *Client) CreateBucket(ctx context.Context, bucket string) error {
+	_, err := c.do(ctx, http.MethodPut, "/"+url.PathEscape(bucket), nil, nil)
+	return err
+}
+
+func (c *Client) PutObject(ctx context.Context, bucket, key string, body []byte) error {
+	_, err := c.do(ctx, http.MethodPut, objectPath(bucket, key), nil, body)
+	return err
+}
+
+func (c *Client) GetObject(ctx context.Context, bucket, key string) ([]byte, error) {
+	return c.do(ctx, http.MethodGet, objectPath(bucket, key), nil, nil)
+}
+
+func (c *Client) DeleteObject(ctx context.Context, bucket, key string) error {
+	_, err := c.do(ctx, http.MethodDelete, objectPath(bucket, key), nil, nil)
+	return err
+}
+
+// PresignGetObject returns a URL which allows anyone to download the object
+// until it expires.
+func (c *Client) PresignGetObject(bucket, key string, expires time.Duration) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.endpoint+objectPath(bucket, key), nil)
+	if err != nil {
+		return "", err
+	}
+	c.signer.Presign(req, expires)
+	return req.URL.String(), nil
+}
+
+// Object is an entry of a bucket listing.
+type Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+}
+
+type listBucketResult struct {
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+	Contents              []Object `xml:"Contents"`
+}
+
+// ListObjects returns all objects of the bucket whose key starts with the
+// prefix. It follows the continuation tokens until the listing is complete.
+func (c *Client) ListObjects(ctx context.Context, bucket, prefix string) ([]Object, error) {
+	objects := []Object{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if len(prefix) > 0 {
+			query.Set("prefix", prefix)
+		}
+		if len(token) > 0 {
+			query.Set("continuation-token", token)
+		}
+		data, err := c.do(ctx, http.MethodGet, "/"+url.PathEscape(bucket), query, nil)
+		if err != nil {
+			return nil, err
+		}
+		result := &listBucketResult{}
+		if err := xml.Unmarshal(data, result); err != nil {
+			return nil, fmt.Errorf("could not parse listing: %w", err)
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kfc-manager/bucket/domain"
+	"github.com/kfc-manager/bucket/server"
+)
+
+func TestClientRoundTrip(t *testing.T) {
+	storage, err := domain.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.New("8000", domain.NewAuth("test-access-key", "test-secret-key"), storage)
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := New(ts.URL, "test-access-key", "test-secret-key", "us-east-1")
+	if err := c.CreateBucket(ctx, "bucket"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"b.txt", "a.txt", "dir/c.txt"} {
+		if err := c.PutObject(ctx, "bucket", key, []byte("hello "+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	body, err := c.GetObject(ctx, "bucket", "dir/c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "hello dir/c.txt" {
+		t.Errorf("got body: '%s', want body: 'hello dir/c.txt'", body)
+	}
+
+	if err := c.DeleteObject(ctx, "bucket", "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := c.ListObjects(ctx, "bucket", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, o := range objects {
+		keys = append(keys, o.Key)
+	}
+	if want := []string{"a.txt", "dir/c.txt"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("got keys: '%v', want keys: '%v'", keys, want)
+	}
+
+	_, err = c.GetObject(ctx, "bucket", "b.txt")
+	if domErr, ok := err.(*domain.Error); !ok || domErr.Code != "NoSuchKey" {
+		t.Errorf("got error: '%v', want code: 'NoSuchKey'", err)
+	}
+
+	presigned, err := c.PresignGetObject("bucket", "a.txt", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(presigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status: '%d', want status: '%d'", resp.StatusCode, http.StatusOK)
+	}
+
+	wrong := New(ts.URL, "test-access-key", "wrong-secret-key", "us-east-1")
+	if _, err := wrong.GetObject(ctx, "bucket", "a.txt"); err == nil {
+		t.Error("got error: '<nil>', want error for wrong secret key")
+	}
+}
@@ -0,0 +1,53 @@
+package domain
+
+import "net/http"
+
+const (
+	// ACLPrivate only allows signed requests, it is the default of every bucket.
+	ACLPrivate = "private"
+	// ACLPublicRead additionally allows anonymous GET and HEAD requests.
+	ACLPublicRead = "public-read"
+)
+
+func validACL(acl string) error {
+	if acl != ACLPrivate && acl != ACLPublicRead {
+		return &Error{
+			msg:    "ACL must be either private or public-read",
+			Code:   "InvalidArgument",
+			Status: http.StatusBadRequest,
+		}
+	}
+	return nil
+}
+
+// SetBucketACL sets the canned ACL of a bucket.
+func (s *Storage) SetBucketACL(name, acl string) error {
+	if err := validACL(acl); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, err := s.readBucketConfig(name)
+	if err != nil {
+		return err
+	}
+	config.ACL = acl
+	return s.writeBucketConfig(name, config)
+}
+
+// BucketACL returns the canned ACL of a bucket. It is asked for on every
+// anonymous request, so bucket.json is read without s.mu, which is safe as it
+// is replaced atomically.
+func (s *Storage) BucketACL(name string) (string, error) {
+	config := &bucketConfig{}
+	ok, err := s.readBucketFile(name, "bucket.json", config)
+	if err != nil {
+		return "", err
+	}
+	if !ok || len(config.ACL) < 1 {
+		return ACLPrivate, nil
+	}
+	return config.ACL, nil
+}
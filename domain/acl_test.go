@@ -0,0 +1,49 @@
+package domain
+
+import "testing"
+
+func TestBucketACL(t *testing.T) {
+	var tests = []struct {
+		name  string
+		acl   string
+		valid bool
+	}{
+		{"public read", ACLPublicRead, true},
+		{"private", ACLPrivate, true},
+		{"unsupported", "public-read-write", false},
+	}
+
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	acl, err := storage.BucketACL("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acl != ACLPrivate {
+		t.Errorf("got acl: '%s', want acl: '%s'", acl, ACLPrivate)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := storage.SetBucketACL("bucket", test.acl)
+			if got := err == nil; got != test.valid {
+				t.Fatalf("got valid: '%t', want valid: '%t' (%v)", got, test.valid, err)
+			}
+			if !test.valid {
+				return
+			}
+			acl, err := storage.BucketACL("bucket")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if acl != test.acl {
+				t.Errorf("got acl: '%s', want acl: '%s'", acl, test.acl)
+			}
+		})
+	}
+}
@@ -6,33 +6,181 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	signAlgorithm = "AWS4-HMAC-SHA256"
+	// unsignedPayload replaces the payload hash in presigned requests
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// maxPresignExpires is the longest validity of a presigned URL (7 days)
+	maxPresignExpires = 7 * 24 * 60 * 60
+	// maxClockSkew is how far the date of a presigned URL may lie in the
+	// future, S3 allows the same
+	maxClockSkew = 15 * time.Minute
 )
 
+// Permission is a bitmask of the operations an access key may perform.
+type Permission uint8
+
+const (
+	// PermRead allows GET and HEAD requests, which includes listings.
+	PermRead Permission = 1 << iota
+	// PermWrite allows every method that modifies state (PUT, POST, DELETE).
+	PermWrite
+
+	PermFull = PermRead | PermWrite
+)
+
+type credential struct {
+	secretKey   string
+	permissions Permission
+	// stored keys are persisted in the key file
+	stored bool
+}
+
 type Auth struct {
-	accessKey string
-	secretKey string
+	mu   sync.RWMutex
+	keys map[string]*credential
+	// file is the key file of LoadKeys, empty if keys are not persisted
+	file string
+	// validateToken checks the session tokens of temporary credentials
+	validateToken TokenValidator
 }
 
+// TokenValidator checks the session token a request of temporary credentials
+// carries in x-amz-security-token, e.g. against the service which issued
+// them. It is only called for requests with a token and after their
+// signature is verified. A domain error is returned as is, every other error
+// rejects the request with InvalidToken.
+type TokenValidator func(accessKey, token string) error
+
+// SetTokenValidator sets the validator of session tokens. Without one,
+// tokens are only covered by the signature and otherwise passed through.
+func (a *Auth) SetTokenValidator(validate TokenValidator) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.validateToken = validate
+}
+
+// checkToken runs the session token of a request through the validator.
+func (a *Auth) checkToken(accessKey, token string) error {
+	a.mu.RLock()
+	validate := a.validateToken
+	a.mu.RUnlock()
+	if len(token) < 1 || validate == nil {
+		return nil
+	}
+	if err := validate(accessKey, token); err != nil {
+		if domErr, ok := err.(*Error); ok {
+			return domErr
+		}
+		return &Error{
+			msg:    "the provided token is malformed or otherwise invalid",
+			Code:   "InvalidToken",
+			Status: http.StatusBadRequest,
+		}
+	}
+	return nil
+}
+
+// NewAuth returns an Auth seeded with a single key pair with full access.
+// More pairs can be added with AddKey.
 func NewAuth(accessKey, secretKey string) *Auth {
-	return &Auth{accessKey: accessKey, secretKey: secretKey}
+	a := &Auth{keys: make(map[string]*credential)}
+	a.AddKey(accessKey, secretKey)
+	return a
+}
+
+// AddKey adds a key pair with full access or replaces an existing access key.
+// It is safe to call while requests are validated.
+func (a *Auth) AddKey(accessKey, secretKey string) {
+	a.AddKeyWithPermissions(accessKey, secretKey, PermFull)
+}
+
+// AddKeyWithPermissions adds a key pair which is restricted to the given
+// permissions, e.g. PermRead for a read-only key.
+func (a *Auth) AddKeyWithPermissions(accessKey, secretKey string, perm Permission) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys[accessKey] = &credential{secretKey: secretKey, permissions: perm}
+}
+
+// RemoveKey revokes an access key. Requests signed with it are rejected from
+// then on.
+func (a *Auth) RemoveKey(accessKey string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.keys, accessKey)
+}
+
+func (a *Auth) secretKey(accessKey string) (string, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	cred, ok := a.keys[accessKey]
+	if !ok {
+		return "", false
+	}
+	return cred.secretKey, true
+}
+
+// Permitted reports whether the access key may perform requests with the
+// given method.
+func (a *Auth) Permitted(accessKey, method string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	cred, ok := a.keys[accessKey]
+	if !ok {
+		return false
+	}
+
+	required := PermWrite
+	if method == http.MethodGet || method == http.MethodHead {
+		required = PermRead
+	}
+	return cred.permissions&required == required
 }
 
 type authHeader struct {
+	accessKey     string
 	credential    string
 	signedHeaders string
 	signature     string
 }
 
-func (a *Auth) parseAuthHeader(header string) (*authHeader, error) {
+// errMalformedAuth returns the error of an Authorization header which can not
+// be parsed, as opposed to one whose signature does not match.
+func errMalformedAuth(msg string) *Error {
+	return &Error{
+		msg:    msg,
+		Code:   "AuthorizationHeaderMalformed",
+		Status: http.StatusBadRequest,
+	}
+}
+
+// errSignatureMismatch returns the error of a request whose signature does
+// not match the one computed from the canonical request.
+func errSignatureMismatch(canonical, toSign string) *Error {
+	return &Error{
+		msg:              "the request signature does not match the signature calculated by the server",
+		Code:             "SignatureDoesNotMatch",
+		Status:           http.StatusForbidden,
+		CanonicalRequest: canonical,
+		StringToSign:     toSign,
+	}
+}
+
+func parseAuthHeader(header string) (*authHeader, error) {
 	prefix := signAlgorithm + " "
 	if !strings.HasPrefix(header, prefix) {
-		return nil, errors.New("signing algorithm not supported")
+		return nil, errMalformedAuth("signing algorithm not supported, use " + signAlgorithm)
 	}
 
 	// remove the signing method prefix from the string
@@ -48,29 +196,59 @@ func (a *Auth) parseAuthHeader(header string) (*authHeader, error) {
 		value := parts[1]
 		kv[key] = value
 	}
+	for _, field := range []string{"Credential", "SignedHeaders", "Signature"} {
+		if len(kv[field]) < 1 {
+			return nil, errMalformedAuth("authorization header is missing the " + field + " field")
+		}
+	}
 
-	cred := kv["Credential"]
-	prefix = a.accessKey + "/"
-	if !strings.HasPrefix(cred, prefix) {
-		return nil, errors.New("invalid access key")
+	// the credential is the access key followed by the scope
+	parts := strings.SplitN(kv["Credential"], "/", 2)
+	if len(parts) != 2 || len(parts[0]) < 1 {
+		return nil, errMalformedAuth("credential must be the access key followed by the scope")
+	}
+	if err := checkScope(parts[1]); err != nil {
+		return nil, err
 	}
-	cred = cred[len(prefix):]
 
 	return &authHeader{
-		credential:    cred,
+		accessKey:     parts[0],
+		credential:    parts[1],
 		signedHeaders: kv["SignedHeaders"],
 		signature:     kv["Signature"],
 	}, nil
 }
 
+// checkScope verifies that the credential scope has the form
+// "<yyyymmdd>/<region>/s3/aws4_request".
+func checkScope(scope string) error {
+	parts := strings.Split(scope, "/")
+	if len(parts) != 4 {
+		return errMalformedAuth("credential scope must have the form '<date>/<region>/s3/aws4_request'")
+	}
+	if _, err := time.Parse("20060102", parts[0]); err != nil {
+		return errMalformedAuth("credential scope has an invalid date: '" + parts[0] + "'")
+	}
+	if len(parts[1]) < 1 {
+		return errMalformedAuth("credential scope is missing the region")
+	}
+	if parts[2] != "s3" {
+		return errMalformedAuth("credential scope has an invalid service: '" + parts[2] + "'")
+	}
+	if parts[3] != "aws4_request" {
+		return errMalformedAuth("credential scope must end with 'aws4_request'")
+	}
+	return nil
+}
+
 func hmacHash(key []byte, data string) []byte {
 	mac := hmac.New(sha256.New, key)
 	mac.Write([]byte(data))
 	return mac.Sum(nil)
 }
 
-func (a *Auth) signingKey(cred string) []byte {
-	key := []byte("AWS4" + a.secretKey)
+func signingKey(secret, cred string) []byte {
+	key := []byte("AWS4" + secret)
 	values := strings.Split(cred, "/")
 	for _, v := range values {
 		key = hmacHash(key, v)
@@ -85,41 +263,82 @@ func canonicalUri(uri string) string {
 	return strings.Split(uri, "?")[0]
 }
 
-func canonicalQuery(uri string) string {
-	// get the query from the uri
-	parts := strings.Split(uri, "?")
-	query := ""
-	if len(parts) > 1 {
-		query = parts[1]
+// uriEncode percent-encodes everything but the unreserved characters as
+// defined for SigV4.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
 	}
+	return b.String()
+}
+
+// canonicalQuery builds the canonical query string: every parameter is
+// decoded and re-encoded, then sorted by name and, for repeated names, by
+// value. Parameters without value get an empty one ("versioning=").
+func canonicalQuery(uri string) string {
+	_, query, _ := strings.Cut(uri, "?")
 
-	parts = strings.Split(query, "&")
-	sort.Strings(parts)
+	type param struct{ name, value string }
+	params := []param{}
+	for _, part := range strings.Split(query, "&") {
+		if len(part) < 1 {
+			continue
+		}
+		name, value, _ := strings.Cut(part, "=")
+		params = append(params, param{uriEncode(unescape(name)), uriEncode(unescape(value))})
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].name != params[j].name {
+			return params[i].name < params[j].name
+		}
+		return params[i].value < params[j].value
+	})
 
-	// rebuild query parameters sorted
-	str := ""
-	for _, v := range parts {
-		str += v + "&"
+	parts := make([]string, 0, len(params))
+	for _, p := range params {
+		parts = append(parts, p.name+"="+p.value)
 	}
-	if len(str) > 0 {
-		str = str[:len(str)-1]
+	return strings.Join(parts, "&")
+}
+
+// unescape decodes percent-encoding, malformed input is kept as it is.
+func unescape(s string) string {
+	if decoded, err := url.PathUnescape(s); err == nil {
+		return decoded
 	}
+	return s
+}
 
-	return str
+// canonicalValue normalizes the values of a header: each value is trimmed,
+// sequential spaces are folded into one and repeated values are joined with
+// commas.
+func canonicalValue(values []string) string {
+	folded := make([]string, 0, len(values))
+	for _, v := range values {
+		folded = append(folded, strings.Join(strings.Fields(v), " "))
+	}
+	return strings.Join(folded, ",")
 }
 
-func canonicalHeaders(headers map[string]string, signed []string) string {
+func canonicalHeaders(headers http.Header, signed []string) string {
 	result := ""
 	// signed is assumed to be alphabetically sorted
 	for _, key := range signed {
-		result += fmt.Sprintf("%s:%s\n", key, strings.TrimSpace(headers[key]))
+		result += fmt.Sprintf("%s:%s\n", key, canonicalValue(headers.Values(key)))
 	}
 	return result
 }
 
 func canonicalRequest(
 	method, uri string,
-	headers map[string]string,
+	headers http.Header,
 	signed string,
 	body string) string {
 	return method + "\n" +
@@ -133,23 +352,204 @@ func strToSign(algo, date, cred, req string) string {
 	return algo + "\n" + date + "\n" + cred + "\n" + Sha256Hash([]byte(req))
 }
 
-func (a *Auth) Validate(method, uri string, headers map[string]string, body string) error {
-	if len(headers["authorization"]) < 1 {
-		return errors.New("authorization header missing")
+// signature computes the SigV4 signature of a request. Signing and
+// verification both go through it, so they always canonicalize the same way.
+func signature(secret, method, uri string, headers http.Header, signed, payloadHash, date, cred string) string {
+	sig, _, _ := signatureParts(secret, method, uri, headers, signed, payloadHash, date, cred)
+	return sig
+}
+
+// signatureParts is like signature but also returns the canonical request and
+// the string to sign the signature was computed from.
+func signatureParts(secret, method, uri string, headers http.Header, signed, payloadHash, date, cred string) (string, string, string) {
+	req := canonicalRequest(method, uri, headers, signed, payloadHash)
+	str := strToSign(signAlgorithm, date, cred, req)
+	return hex.EncodeToString(hmacHash(signingKey(secret, cred), str)), req, str
+}
+
+// checkSignedHeaders verifies that the signature covers the required headers
+// and that every signed header is part of the request.
+func checkSignedHeaders(signed string, headers http.Header, required ...string) error {
+	list := strings.Split(signed, ";")
+	for _, h := range required {
+		if !slices.Contains(list, h) {
+			return &Error{
+				msg:    "header '" + h + "' must be signed",
+				Code:   "AccessDenied",
+				Status: http.StatusForbidden,
+			}
+		}
 	}
-	authHeader, err := a.parseAuthHeader(headers["authorization"])
+	for _, h := range list {
+		if len(headers.Values(h)) < 1 {
+			return &Error{
+				msg:    "signed header '" + h + "' is missing from the request",
+				Code:   "AccessDenied",
+				Status: http.StatusForbidden,
+			}
+		}
+	}
+	return nil
+}
+
+// requestDate returns the date of the request in the format of the string to
+// sign. It is taken from the x-amz-date header or, as SigV4 allows, from the
+// Date header if the former is missing.
+func requestDate(headers http.Header) (string, error) {
+	if date := headers.Get("x-amz-date"); len(date) > 0 {
+		if _, err := time.Parse(dateFormat, date); err != nil {
+			return "", &Error{
+				msg:    "header x-amz-date must have the format '" + dateFormat + "', got '" + date + "'",
+				Code:   "AuthorizationHeaderMalformed",
+				Status: http.StatusBadRequest,
+			}
+		}
+		return date, nil
+	}
+	if date := headers.Get("date"); len(date) > 0 {
+		t, err := http.ParseTime(date)
+		if err != nil {
+			return "", &Error{
+				msg:    "header Date must be an HTTP date, got '" + date + "'",
+				Code:   "AuthorizationHeaderMalformed",
+				Status: http.StatusBadRequest,
+			}
+		}
+		return t.UTC().Format(dateFormat), nil
+	}
+	return "", &Error{
+		msg:    "authentication requires a valid Date or x-amz-date header",
+		Code:   "AccessDenied",
+		Status: http.StatusForbidden,
+	}
+}
+
+// Validate verifies the SigV4 signature of a request and returns the access
+// key the request was signed with.
+func (a *Auth) Validate(method, uri string, headers http.Header, body string) (string, error) {
+	if len(headers.Get("authorization")) < 1 {
+		return "", errors.New("authorization header missing")
+	}
+	authHeader, err := parseAuthHeader(headers.Get("authorization"))
 	if err != nil {
-		return err
+		return "", err
+	}
+	required := []string{"host"}
+	if len(headers.Values("x-amz-content-sha256")) > 0 {
+		required = append(required, "x-amz-content-sha256")
+	}
+	// an unsigned token could be swapped for the one of other credentials
+	if len(headers.Values("x-amz-security-token")) > 0 {
+		required = append(required, "x-amz-security-token")
+	}
+	if err := checkSignedHeaders(authHeader.signedHeaders, headers, required...); err != nil {
+		return "", err
+	}
+	secret, ok := a.secretKey(authHeader.accessKey)
+	if !ok {
+		return "", &Error{
+			msg:    "the access key id does not exist",
+			Code:   "InvalidAccessKeyId",
+			Status: http.StatusForbidden,
+		}
 	}
 
-	req := canonicalRequest(method, uri, headers, authHeader.signedHeaders, body)
-	str := strToSign(signAlgorithm, headers["x-amz-date"], authHeader.credential, req)
-	key := a.signingKey(authHeader.credential)
+	date, err := requestDate(headers)
+	if err != nil {
+		return "", err
+	}
 
-	signature := hex.EncodeToString(hmacHash(key, str))
-	if signature != authHeader.signature {
-		return errors.New("invalid signature")
+	sig, req, str := signatureParts(
+		secret,
+		method, uri,
+		headers, authHeader.signedHeaders,
+		body,
+		date, authHeader.credential,
+	)
+	if sig != authHeader.signature {
+		return "", errSignatureMismatch(req, str)
+	}
+	if err := a.checkToken(authHeader.accessKey, headers.Get("x-amz-security-token")); err != nil {
+		return "", err
 	}
 
-	return nil
+	return authHeader.accessKey, nil
+}
+
+// ValidatePresigned verifies a request whose SigV4 signature is carried in
+// the query string (a presigned URL) and returns the access key it was signed
+// with. The payload of presigned requests is never signed.
+func (a *Auth) ValidatePresigned(method, uri string, headers http.Header, now time.Time) (string, error) {
+	path, rawQuery, _ := strings.Cut(uri, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", err
+	}
+	if query.Get("X-Amz-Algorithm") != signAlgorithm {
+		return "", errors.New("signing algorithm not supported")
+	}
+	accessKey, cred, ok := strings.Cut(query.Get("X-Amz-Credential"), "/")
+	if !ok {
+		return "", errors.New("invalid credential")
+	}
+	date, err := time.Parse(dateFormat, query.Get("X-Amz-Date"))
+	if err != nil {
+		return "", errors.New("invalid date")
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 1 || expires > maxPresignExpires {
+		return "", errors.New("invalid expiration")
+	}
+	// a URL dated in the future would stay valid for longer than expires
+	if date.After(now.Add(maxClockSkew)) {
+		return "", &Error{
+			msg:    "request date is too far in the future",
+			Code:   "RequestTimeTooSkewed",
+			Status: http.StatusForbidden,
+		}
+	}
+	if now.After(date.Add(time.Duration(expires) * time.Second)) {
+		return "", &Error{
+			msg:    "request has expired",
+			Code:   "AccessDenied",
+			Status: http.StatusForbidden,
+		}
+	}
+
+	if err := checkSignedHeaders(query.Get("X-Amz-SignedHeaders"), headers, "host"); err != nil {
+		return "", err
+	}
+
+	secret, ok := a.secretKey(accessKey)
+	if !ok {
+		return "", &Error{
+			msg:    "the access key id does not exist",
+			Code:   "InvalidAccessKeyId",
+			Status: http.StatusForbidden,
+		}
+	}
+
+	// the signature itself is not part of the canonical query
+	parts := []string{}
+	for _, part := range strings.Split(rawQuery, "&") {
+		if !strings.HasPrefix(part, "X-Amz-Signature=") {
+			parts = append(parts, part)
+		}
+	}
+	sig, req, str := signatureParts(
+		secret,
+		method, path+"?"+strings.Join(parts, "&"),
+		headers, query.Get("X-Amz-SignedHeaders"),
+		unsignedPayload,
+		query.Get("X-Amz-Date"), cred,
+	)
+	if sig != query.Get("X-Amz-Signature") {
+		return "", errSignatureMismatch(req, str)
+	}
+	// the token of a presigned URL is part of the signed query
+	if err := a.checkToken(accessKey, query.Get("X-Amz-Security-Token")); err != nil {
+		return "", err
+	}
+
+	return accessKey, nil
 }
@@ -0,0 +1,429 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type BucketInfo struct {
+	Name           string
+	CreatedAt      time.Time
+	OwnerAccessKey string
+	Region         string
+}
+
+// Bucket returns the information persisted about a bucket.
+func (s *Storage) Bucket(name string) (*BucketInfo, error) {
+	dir, err := s.bucketDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the fields written on creation are needed, so a legacy bucket
+	// without bucket.json does not get its usage computed
+	config := &bucketConfig{}
+	if _, err := s.readBucketFile(name, "bucket.json", config); err != nil {
+		return nil, err
+	}
+
+	info := &BucketInfo{
+		Name:           name,
+		CreatedAt:      config.CreatedAt,
+		OwnerAccessKey: config.OwnerAccessKey,
+		Region:         config.Region,
+	}
+	// buckets created before bucket.json existed only have their directory
+	if info.CreatedAt.IsZero() {
+		stat, err := os.Stat(dir)
+		if err != nil {
+			return nil, fmt.Errorf("could not stat bucket directory: %w", err)
+		}
+		info.CreatedAt = stat.ModTime()
+	}
+	if len(info.Region) < 1 {
+		info.Region = s.region
+	}
+	return info, nil
+}
+
+// ListBuckets returns all buckets sorted by name. A bucket which can not be
+// read is logged and left out, so it does not hide all the others.
+func (s *Storage) ListBuckets() ([]*BucketInfo, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read storage directory: %w", err)
+	}
+
+	buckets := []*BucketInfo{}
+	for _, entry := range entries {
+		// buckets created under the relaxed naming policy are listed even
+		// once it is turned off, hidden directories never are
+		if !entry.IsDir() || relaxedName(entry.Name()) != nil {
+			continue
+		}
+		info, err := s.Bucket(entry.Name())
+		if err != nil {
+			log.Printf("[ERROR] - could not read bucket '%s': %s", entry.Name(), err)
+			continue
+		}
+		buckets = append(buckets, info)
+	}
+	return buckets, nil
+}
+
+var errQuotaExceeded = &Error{
+	msg:    "object would exceed the quota of the bucket",
+	Code:   "QuotaExceeded",
+	Status: http.StatusConflict,
+}
+
+var errTooManyObjects = &Error{
+	msg:    "bucket holds the maximum number of objects",
+	Code:   "TooManyObjects",
+	Status: http.StatusConflict,
+}
+
+var errEntityTooLarge = &Error{
+	msg:    "object exceeds the maximum object size of the bucket",
+	Code:   "EntityTooLarge",
+	Status: http.StatusBadRequest,
+}
+
+// bucketConfig is persisted as bucket.json in the root of every bucket
+// directory, next to the object directories.
+type bucketConfig struct {
+	CreatedAt      time.Time `json:"created_at"`
+	OwnerAccessKey string    `json:"owner_access_key"`
+	Region         string    `json:"region"`
+	Quota          int64     `json:"quota"`
+	MaxObjectSize  int64     `json:"max_object_size,omitempty"`
+	MaxObjects     int64     `json:"max_objects,omitempty"`
+	UsedBytes      int64     `json:"used_bytes"`
+	ObjectCount    int64     `json:"object_count"`
+	Versioning     string    `json:"versioning,omitempty"`
+	ACL            string    `json:"acl,omitempty"`
+}
+
+// readBucketConfig must be called while holding s.mu. Buckets created before
+// bucket.json existed get their running total computed once from the metadata,
+// it is written back so the next call does not walk the bucket again.
+func (s *Storage) readBucketConfig(name string) (*bucketConfig, error) {
+	dir, err := s.bucketDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(dir + "/bucket.json")
+	if errors.Is(err, os.ErrNotExist) {
+		return s.migrateBucketConfig(name, dir)
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read bucket.json: %w", err)
+	}
+
+	config := &bucketConfig{}
+	if err := json.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("could not unmarshal bucket.json content: %w", err)
+	}
+	return config, nil
+}
+
+// migrateBucketConfig computes the config of a bucket created before
+// bucket.json existed and writes it. The creation date is taken from the
+// directory before writing the file changes its modification time. A failed
+// write is only logged, the config is computed again next time.
+func (s *Storage) migrateBucketConfig(name, dir string) (*bucketConfig, error) {
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not stat bucket directory: %w", err)
+	}
+	count, size, err := s.BucketStats(name)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &bucketConfig{CreatedAt: stat.ModTime().UTC(), UsedBytes: size, ObjectCount: int64(count)}
+	if err := s.writeBucketConfig(name, config); err != nil {
+		log.Printf("[ERROR] - could not write bucket.json of bucket '%s': %s", name, err)
+	}
+	return config, nil
+}
+
+// writeBucketConfig must be called while holding s.mu.
+func (s *Storage) writeBucketConfig(name string, config *bucketConfig) error {
+	dir, err := s.bucketDir(name)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("could not marshal bucket config struct: %w", err)
+	}
+	if err := writeFileAtomic(dir+"/bucket.json", b, s.fileMode); err != nil {
+		return fmt.Errorf("could not write bucket.json: %w", err)
+	}
+	return nil
+}
+
+// readBucketFile unmarshals a JSON file of the bucket directory into v. A
+// missing file is reported with ok set to false. The files are replaced
+// atomically, so they are read without s.mu, which keeps the CORS and ACL
+// lookups of unauthenticated requests off the global lock.
+func (s *Storage) readBucketFile(name, file string, v any) (bool, error) {
+	dir, err := s.bucketDir(name)
+	if err != nil {
+		return false, err
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, file))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("could not read %s: %w", file, err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return false, fmt.Errorf("could not unmarshal %s content: %w", file, err)
+	}
+	return true, nil
+}
+
+// writeBucketFile stores v as JSON file in the bucket directory.
+func (s *Storage) writeBucketFile(name, file string, v any) error {
+	dir, err := s.bucketDir(name)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("could not marshal %s content: %w", file, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := writeFileAtomic(filepath.Join(dir, file), b, s.fileMode); err != nil {
+		return fmt.Errorf("could not write %s: %w", file, err)
+	}
+	return nil
+}
+
+// removeBucketFile deletes a file of the bucket directory, a missing file is
+// not an error.
+func (s *Storage) removeBucketFile(name, file string) error {
+	dir, err := s.bucketDir(name)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(filepath.Join(dir, file)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not remove %s: %w", file, err)
+	}
+	return nil
+}
+
+// validLimit reports whether n is a valid limit of a bucket, 0 disables it.
+// Both storages share it, so they reject the same limits with the same error.
+func validLimit(n int64, what string) error {
+	if n < 0 {
+		return &Error{
+			msg:    what + " can not be negative",
+			Code:   "InvalidArgument",
+			Status: http.StatusBadRequest,
+		}
+	}
+	return nil
+}
+
+// SetBucketQuota limits the total size of all objects in a bucket to the
+// given amount of bytes. A quota of 0 removes the limit.
+func (s *Storage) SetBucketQuota(name string, bytes int64) error {
+	if err := validLimit(bytes, "bucket quota"); err != nil {
+		return err
+	}
+	if _, err := s.bucketDir(name); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, err := s.readBucketConfig(name)
+	if err != nil {
+		return err
+	}
+	config.Quota = bytes
+	return s.writeBucketConfig(name, config)
+}
+
+// SetBucketMaxObjectSize limits the size of every single object of a bucket to
+// the given amount of bytes. A size of 0 removes the limit.
+func (s *Storage) SetBucketMaxObjectSize(name string, bytes int64) error {
+	if err := validLimit(bytes, "maximum object size"); err != nil {
+		return err
+	}
+	if _, err := s.bucketDir(name); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, err := s.readBucketConfig(name)
+	if err != nil {
+		return err
+	}
+	config.MaxObjectSize = bytes
+	return s.writeBucketConfig(name, config)
+}
+
+// SetBucketMaxObjects limits the number of objects of a bucket, e.g. to
+// protect filesystems with a limited amount of inodes. A limit of 0 removes
+// it. The objects are counted again, so the limit also holds for buckets
+// which did not keep track of their count yet.
+func (s *Storage) SetBucketMaxObjects(name string, count int64) error {
+	if err := validLimit(count, "maximum number of objects"); err != nil {
+		return err
+	}
+	if _, err := s.bucketDir(name); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, err := s.readBucketConfig(name)
+	if err != nil {
+		return err
+	}
+	objects, _, err := s.BucketStats(name)
+	if err != nil {
+		return err
+	}
+	config.MaxObjects = count
+	config.ObjectCount = int64(objects)
+	return s.writeBucketConfig(name, config)
+}
+
+// BucketUsage returns the running totals of a bucket, the number of its
+// objects and the bytes they use, without walking its objects.
+func (s *Storage) BucketUsage(name string) (int64, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, err := s.readBucketConfig(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	return config.ObjectCount, config.UsedBytes, nil
+}
+
+// checkObjectSize reports whether an object of the given size exceeds the
+// maximum object size of the bucket.
+func (s *Storage) checkObjectSize(name string, size int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, err := s.readBucketConfig(name)
+	if err != nil {
+		return err
+	}
+	if config.MaxObjectSize > 0 && int64(size) > config.MaxObjectSize {
+		return errEntityTooLarge
+	}
+	return nil
+}
+
+// checkQuota reports whether adding delta bytes would overflow the quota of
+// the bucket, without updating its running total.
+func (s *Storage) checkQuota(name string, delta int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, err := s.readBucketConfig(name)
+	if err != nil {
+		return err
+	}
+	if delta > 0 && config.Quota > 0 && config.UsedBytes+delta > config.Quota {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// checkObjectCount reports whether adding objects would exceed the maximum
+// number of objects of the bucket, without updating its count.
+func (s *Storage) checkObjectCount(name string, added int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, err := s.readBucketConfig(name)
+	if err != nil {
+		return err
+	}
+	if added > 0 && config.MaxObjects > 0 && config.ObjectCount+added > config.MaxObjects {
+		return errTooManyObjects
+	}
+	return nil
+}
+
+// updateCount adds delta to the number of objects of the bucket. If the bucket
+// has a maximum number of objects and check is set, the update is rejected
+// when it would exceed it.
+func (s *Storage) updateCount(name string, delta int64, check bool) error {
+	if delta == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, err := s.readBucketConfig(name)
+	if err != nil {
+		return err
+	}
+	if check && config.MaxObjects > 0 && config.ObjectCount+delta > config.MaxObjects {
+		return errTooManyObjects
+	}
+	config.ObjectCount = max(config.ObjectCount+delta, 0)
+	return s.writeBucketConfig(name, config)
+}
+
+// updateUsage adds delta to the running total of the bucket. If the bucket has
+// a quota and check is set, the update is rejected when it would overflow.
+func (s *Storage) updateUsage(name string, delta int64, check bool) error {
+	_, err := s.updateUsageFunc(name, check, func(*bucketConfig) (int64, error) {
+		return delta, nil
+	})
+	return err
+}
+
+// updateUsageFunc is updateUsage with a delta computed from the config of the
+// bucket while s.mu is held, so it is based on the same versioning status the
+// update is written with. An error of delta aborts the update. It returns the
+// delta which was added.
+func (s *Storage) updateUsageFunc(name string, check bool, delta func(config *bucketConfig) (int64, error)) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, err := s.readBucketConfig(name)
+	if err != nil {
+		return 0, err
+	}
+	d, err := delta(config)
+	if err != nil {
+		return 0, err
+	}
+	if check && d > 0 && config.Quota > 0 && config.UsedBytes+d > config.Quota {
+		return 0, errQuotaExceeded
+	}
+	config.UsedBytes += d
+	if config.UsedBytes < 0 {
+		config.UsedBytes = 0
+	}
+	return d, s.writeBucketConfig(name, config)
+}
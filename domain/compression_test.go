@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	storage, err := NewStorage(t.TempDir(), WithCompression())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(strings.Repeat("hello world! ", 1000))
+	if err := storage.Put("bucket", "key", body); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := storage.Get("bucket", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Error("got body differs from the uploaded body")
+	}
+
+	dir, err := storage.objectDir("bucket", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dir + "/body")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() >= int64(len(body)) {
+		t.Errorf("got size on disk: '%d', want less than: '%d'", info.Size(), len(body))
+	}
+
+	head, err := storage.Head("bucket", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Size != int64(len(body)) {
+		t.Errorf("got size: '%d', want size: '%d'", head.Size, len(body))
+	}
+	if head.ContentHash != Sha256Hash(body) {
+		t.Errorf("got hash: '%s', want hash: '%s'", head.ContentHash, Sha256Hash(body))
+	}
+}
@@ -0,0 +1,90 @@
+package domain
+
+import (
+	"net/http"
+	"slices"
+)
+
+const corsFile = "cors.json"
+
+// corsMethods are the methods a CORS rule may allow.
+var corsMethods = []string{"GET", "PUT", "POST", "DELETE", "HEAD"}
+
+// CORSRule allows browsers from the matching origins to send requests with
+// the listed methods and headers to a bucket.
+type CORSRule struct {
+	AllowedOrigins []string `json:"allowed_origins"`
+	AllowedMethods []string `json:"allowed_methods"`
+	AllowedHeaders []string `json:"allowed_headers,omitempty"`
+	ExposeHeaders  []string `json:"expose_headers,omitempty"`
+	MaxAgeSeconds  int      `json:"max_age_seconds,omitempty"`
+}
+
+func validCORSRules(rules []CORSRule) error {
+	if len(rules) < 1 {
+		return &Error{
+			msg:    "CORS configuration must contain at least one rule",
+			Code:   "MalformedXML",
+			Status: http.StatusBadRequest,
+		}
+	}
+	for _, rule := range rules {
+		if len(rule.AllowedOrigins) < 1 || len(rule.AllowedMethods) < 1 {
+			return &Error{
+				msg:    "CORS rule must contain at least one allowed origin and method",
+				Code:   "MalformedXML",
+				Status: http.StatusBadRequest,
+			}
+		}
+		for _, method := range rule.AllowedMethods {
+			if !slices.Contains(corsMethods, method) {
+				return &Error{
+					msg:    "CORS rule contains unsupported method: '" + method + "'",
+					Code:   "MalformedXML",
+					Status: http.StatusBadRequest,
+				}
+			}
+		}
+		if rule.MaxAgeSeconds < 0 {
+			return &Error{
+				msg:    "CORS rule must not have a negative max age",
+				Code:   "MalformedXML",
+				Status: http.StatusBadRequest,
+			}
+		}
+	}
+	return nil
+}
+
+var errNoSuchCORS = &Error{
+	msg:    "the CORS configuration does not exist",
+	Code:   "NoSuchCORSConfiguration",
+	Status: http.StatusNotFound,
+}
+
+// SetBucketCORS replaces the CORS rules of a bucket.
+func (s *Storage) SetBucketCORS(name string, rules []CORSRule) error {
+	if err := validCORSRules(rules); err != nil {
+		return err
+	}
+	return s.writeBucketFile(name, corsFile, rules)
+}
+
+// BucketCORS returns the CORS rules of a bucket.
+func (s *Storage) BucketCORS(name string) ([]CORSRule, error) {
+	rules := []CORSRule{}
+	ok, err := s.readBucketFile(name, corsFile, &rules)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errNoSuchCORS
+	}
+	return rules, nil
+}
+
+// DeleteBucketCORS removes the CORS rules of a bucket. Deleting a missing
+// configuration is not an error.
+func (s *Storage) DeleteBucketCORS(name string) error {
+	return s.removeBucketFile(name, corsFile)
+}
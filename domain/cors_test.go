@@ -0,0 +1,82 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBucketCORS(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.BucketCORS("bucket"); err == nil || err.(*Error).Code != "NoSuchCORSConfiguration" {
+		t.Errorf("got error: '%v', want code: 'NoSuchCORSConfiguration'", err)
+	}
+
+	rules := []CORSRule{
+		{
+			AllowedOrigins: []string{"https://example.com"},
+			AllowedMethods: []string{"GET", "PUT"},
+			AllowedHeaders: []string{"*"},
+			ExposeHeaders:  []string{"ETag"},
+			MaxAgeSeconds:  3000,
+		},
+		{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET"},
+		},
+	}
+	if err := storage.SetBucketCORS("bucket", rules); err != nil {
+		t.Fatal(err)
+	}
+	got, err := storage.BucketCORS("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, rules) {
+		t.Errorf("got rules: '%v', want rules: '%v'", got, rules)
+	}
+
+	if err := storage.DeleteBucketCORS("bucket"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.BucketCORS("bucket"); err == nil {
+		t.Errorf("got error: '<nil>', want error after delete")
+	}
+}
+
+func TestBucketCORSInvalid(t *testing.T) {
+	var tests = []struct {
+		name  string
+		rules []CORSRule
+	}{
+		{"no rules", []CORSRule{}},
+		{"no origin", []CORSRule{{AllowedMethods: []string{"GET"}}}},
+		{"no method", []CORSRule{{AllowedOrigins: []string{"*"}}}},
+		{"unsupported method", []CORSRule{{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"PATCH"}}}},
+		{"negative max age", []CORSRule{{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}, MaxAgeSeconds: -1}}},
+	}
+
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := storage.SetBucketCORS("bucket", test.rules)
+			domErr, ok := err.(*Error)
+			if !ok || domErr.Code != "MalformedXML" || domErr.Status != 400 {
+				t.Errorf("got error: '%v', want code: 'MalformedXML'", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// blobsDir holds the bodies shared by objects of all buckets, it is hidden
+// from the bucket listing like the trash.
+const blobsDir = ".blobs"
+
+// WithDeduplication stores bodies with the same content only once. The body
+// goes into .blobs/<content hash> of the storage directory, which every
+// object with that content references from its metadata. A blob is removed
+// once the last object referencing it is deleted. Objects are still verified
+// against their checksum on every read. Encrypted bodies are not
+// deduplicated, since every object is encrypted with its own nonce.
+func WithDeduplication() StorageOption {
+	return func(s *Storage) {
+		s.deduplication = true
+	}
+}
+
+// blobInfo is stored next to a blob as <content hash>.json.
+type blobInfo struct {
+	Refs       int  `json:"refs"`
+	Compressed bool `json:"compressed,omitempty"`
+}
+
+func (s *Storage) blobPath(hash string) string {
+	return filepath.Join(s.path, blobsDir, hash)
+}
+
+func readBlobInfo(path string) (*blobInfo, error) {
+	b, err := os.ReadFile(path + ".json")
+	if err != nil {
+		return nil, err
+	}
+	info := &blobInfo{}
+	if err := json.Unmarshal(b, info); err != nil {
+		return nil, fmt.Errorf("could not unmarshal blob info: %w", err)
+	}
+	return info, nil
+}
+
+func (s *Storage) writeBlobInfo(path string, info *blobInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("could not marshal blob info: %w", err)
+	}
+	if err := writeFileAtomic(path+".json", b, s.fileMode); err != nil {
+		return fmt.Errorf("could not write blob info: %w", err)
+	}
+	return nil
+}
+
+// acquireBlob adds a reference to the blob of the body and writes the blob if
+// there is none yet.
+func (s *Storage) acquireBlob(ctx context.Context, hash string, body []byte) (*blobInfo, error) {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	path := s.blobPath(hash)
+	info, err := readBlobInfo(path)
+	if err == nil {
+		info.Refs++
+		return info, s.writeBlobInfo(path, info)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	info = &blobInfo{Refs: 1}
+	data := body
+	if s.compression {
+		if data, err = compress(body); err != nil {
+			return nil, err
+		}
+		info.Compressed = true
+	}
+	if err := mkdirAll(filepath.Dir(path), s.dirMode); err != nil {
+		return nil, err
+	}
+	tmp, err := writeTempCtx(ctx, filepath.Dir(path), data, s.fileMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("could not move blob into place: %w", err)
+	}
+	if err := s.writeBlobInfo(path, info); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return info, nil
+}
+
+// releaseBlob removes a reference to a blob and the blob itself with the
+// last one.
+func (s *Storage) releaseBlob(hash string) error {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	path := s.blobPath(hash)
+	info, err := readBlobInfo(path)
+	if err != nil {
+		return err
+	}
+	info.Refs--
+	if info.Refs > 0 {
+		return s.writeBlobInfo(path, info)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(path + ".json")
+}
+
+// releaseBlobs releases the blobs in the list, an error only leaves a blob
+// behind and is logged.
+func (s *Storage) releaseBlobs(ctx context.Context, hashes []string) {
+	for _, hash := range hashes {
+		if err := s.releaseBlob(hash); err != nil {
+			log.Println("[ERROR] - " + logPrefix(ctx) + "could not release blob: " + err.Error())
+		}
+	}
+}
+
+// blobsIn returns the blobs referenced by all versions of the objects below
+// dir, it has to be called before dir is removed.
+func blobsIn(dir string) []string {
+	hashes := []string{}
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() != "metadata.json" {
+			return nil
+		}
+		if meta, err := readMetadata(filepath.Dir(path)); err == nil && len(meta.Blob) > 0 {
+			hashes = append(hashes, meta.Blob)
+		}
+		return nil
+	})
+	return hashes
+}
+
+// writeBlob references the blob of the body from the metadata of the object
+// instead of writing a body file.
+func (s *Storage) writeBlob(ctx context.Context, dir string, meta *metadata, body []byte) error {
+	blob, err := s.acquireBlob(ctx, meta.ContentHash, body)
+	if err != nil {
+		return err
+	}
+	meta.Blob = meta.ContentHash
+	meta.Compressed = blob.Compressed
+	if err := s.writeMetadata(dir, meta); err != nil {
+		s.releaseBlobs(ctx, []string{meta.Blob})
+		return err
+	}
+	// the body of an object stored before deduplication is not needed
+	// anymore
+	if err := os.Remove(dir + "/body"); err != nil && !os.IsNotExist(err) {
+		log.Println("[ERROR] - " + logPrefix(ctx) + "could not remove replaced body: " + err.Error())
+	}
+	return nil
+}
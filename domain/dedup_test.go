@@ -0,0 +1,168 @@
+package domain
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// blobRefs returns the reference count of the blob of the body, or 0 if
+// there is no blob.
+func blobRefs(t *testing.T, storage *Storage, body string) int {
+	t.Helper()
+	path := storage.blobPath(Sha256Hash([]byte(body)))
+	info, err := readBlobInfo(path)
+	if os.IsNotExist(err) {
+		if exists(path) {
+			t.Errorf("got blob without info at: '%s', want blob removed", path)
+		}
+		return 0
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return info.Refs
+}
+
+func TestDeduplication(t *testing.T) {
+	var tests = []struct {
+		name string
+		call func(s *Storage) error
+		// refs are the reference counts of "hello" and "world" afterwards
+		refs [2]int
+	}{
+		{"first object", func(s *Storage) error {
+			return s.Put("bucket", "a", []byte("hello"))
+		}, [2]int{1, 0}},
+		{"identical object", func(s *Storage) error {
+			return s.Put("bucket", "b", []byte("hello"))
+		}, [2]int{2, 0}},
+		{"identical object in other bucket", func(s *Storage) error {
+			return s.Put("other", "a", []byte("hello"))
+		}, [2]int{3, 0}},
+		{"overwrite with same body", func(s *Storage) error {
+			return s.Put("bucket", "a", []byte("hello"))
+		}, [2]int{3, 0}},
+		{"overwrite with other body", func(s *Storage) error {
+			return s.Put("bucket", "a", []byte("world"))
+		}, [2]int{2, 1}},
+		{"delete", func(s *Storage) error {
+			return s.Delete("bucket", "b")
+		}, [2]int{1, 1}},
+		{"rename", func(s *Storage) error {
+			return s.Rename("bucket", "a", "c")
+		}, [2]int{1, 1}},
+		{"delete last reference", func(s *Storage) error {
+			return s.Delete("bucket", "c")
+		}, [2]int{1, 0}},
+		{"versioned overwrite keeps version", func(s *Storage) error {
+			if err := s.SetBucketVersioning("other", VersioningEnabled); err != nil {
+				return err
+			}
+			return s.Put("other", "a", []byte("world"))
+		}, [2]int{1, 1}},
+		{"delete marker keeps versions", func(s *Storage) error {
+			return s.Delete("other", "a")
+		}, [2]int{1, 1}},
+	}
+
+	storage, err := NewStorage(t.TempDir(), WithDeduplication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bucket := range []string{"bucket", "other"} {
+		if err := storage.NewBucket(bucket, "test-access-key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the steps build on each other, so they run in order
+	for _, test := range tests {
+		if err := test.call(storage); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		for i, body := range []string{"hello", "world"} {
+			if got := blobRefs(t, storage, body); got != test.refs[i] {
+				t.Errorf("%s: got refs of '%s': '%d', want refs: '%d'", test.name, body, got, test.refs[i])
+			}
+		}
+	}
+
+	versions, err := storage.ListObjectVersions("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range versions {
+		if version.DeleteMarker {
+			continue
+		}
+		if _, err := storage.GetVersion("other", "a", version.VersionID); err != nil {
+			t.Errorf("got error: '%v', want version '%s' readable", err, version.VersionID)
+		}
+	}
+}
+
+func TestDeduplicationStorage(t *testing.T) {
+	storage, err := NewStorage(t.TempDir(), WithDeduplication(), WithCompression())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err := storage.Put("bucket", key, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// both objects share the blob and have no body of their own
+	for _, key := range []string{"a", "b"} {
+		dir, _ := storage.objectDir("bucket", key)
+		if exists(dir + "/body") {
+			t.Errorf("got body file for key: '%s', want the blob only", key)
+		}
+		body, err := storage.Get("bucket", key)
+		if err != nil || string(body) != "hello" {
+			t.Errorf("got body: '%s', error: '%v', want body: 'hello'", body, err)
+		}
+	}
+
+	// reads still verify the checksum of the shared body
+	path := storage.blobPath(Sha256Hash([]byte("hello")))
+	data, err := compress([]byte("HELLO"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Get("bucket", "a"); err == nil {
+		t.Error("got error: '<nil>', want checksum mismatch")
+	}
+}
+
+func TestDeduplicationTrash(t *testing.T) {
+	storage, err := NewStorage(t.TempDir(), WithDeduplication(), WithSoftDelete(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("bucket", "key", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete("bucket", "key"); err != nil {
+		t.Fatal(err)
+	}
+	// the trashed object can still be restored
+	if got := blobRefs(t, storage, "hello"); got != 1 {
+		t.Errorf("got refs: '%d', want refs: '1'", got)
+	}
+	if err := storage.sweep(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if got := blobRefs(t, storage, "hello"); got != 0 {
+		t.Errorf("got refs: '%d', want refs: '0'", got)
+	}
+}
@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// WithMinFreeSpace keeps the given amount of bytes free on the filesystem of
+// the storage. Uploads which would cut into it are rejected upfront.
+func WithMinFreeSpace(bytes uint64) StorageOption {
+	return func(s *Storage) {
+		s.minFreeSpace = bytes
+	}
+}
+
+// preflight checks that the filesystem has room for size more bytes, so a
+// write does not fail halfway through with ENOSPC.
+func (s *Storage) preflight(size int) error {
+	available, err := s.freeSpace(s.path)
+	if err != nil {
+		return fmt.Errorf("could not determine free disk space: %w", err)
+	}
+	if available < uint64(size)+s.minFreeSpace {
+		return &Error{
+			msg:    "not enough disk space left to store the object",
+			Code:   "InsufficientStorage",
+			Status: http.StatusInsufficientStorage,
+		}
+	}
+	return nil
+}
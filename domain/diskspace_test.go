@@ -0,0 +1,45 @@
+package domain
+
+import "testing"
+
+func TestPreflight(t *testing.T) {
+	var tests = []struct {
+		name      string
+		available uint64
+		headroom  uint64
+		valid     bool
+	}{
+		{"enough space", 1024, 0, true},
+		{"exactly enough space", 12, 0, true},
+		{"full disk", 0, 0, false},
+		{"not enough space", 11, 0, false},
+		{"enough space but not enough headroom", 1024, 1020, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage, err := NewStorage(t.TempDir(), WithMinFreeSpace(test.headroom))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			storage.freeSpace = func(string) (uint64, error) {
+				return test.available, nil
+			}
+
+			err = storage.Put("bucket", "key", []byte("hello world!"))
+			got := err == nil
+			if got != test.valid {
+				t.Errorf("got valid: '%t', want valid: '%t'", got, test.valid)
+			}
+			if domErr, ok := err.(*Error); err != nil && (!ok || domErr.Status != 507) {
+				t.Errorf("got error: '%v', want status: '%d'", err, 507)
+			}
+			if _, err := storage.Get("bucket", "key"); (err == nil) != test.valid {
+				t.Errorf("got object stored: '%t', want stored: '%t'", err == nil, test.valid)
+			}
+		})
+	}
+}
@@ -1,20 +1,91 @@
 package domain
 
 import (
+	"context"
+	"crypto/md5"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"syscall"
 )
 
 type Error struct {
-	msg    string
+	msg string
+	// Code is the machine-readable S3 error code, e.g. NoSuchKey
+	Code   string
 	Status int
+	// CanonicalRequest and StringToSign are set on a signature mismatch to
+	// what the server computed, which helps clients to debug their signing
+	CanonicalRequest string
+	StringToSign     string
+}
+
+func NewError(status int, code, msg string) *Error {
+	return &Error{msg: msg, Code: code, Status: status}
 }
 
 func (e *Error) Error() string {
 	return e.msg
 }
 
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx which carries the ID of the request it
+// belongs to. Errors the storage logs on behalf of the request include it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request ctx belongs to, or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logPrefix returns the prefix of log lines written on behalf of the request
+// of ctx, it matches the one of the server.
+func logPrefix(ctx context.Context) string {
+	if id := RequestID(ctx); len(id) > 0 {
+		return "request " + id + ": "
+	}
+	return ""
+}
+
+// unwritable turns the error of a write to a read-only or inaccessible data
+// directory into a 503, so clients retry instead of giving up on a server
+// error. The underlying error is only logged when the directory turns
+// unwritable, and a successful write logs that it is writable again.
+func (s *Storage) unwritable(ctx context.Context, err error) error {
+	if err == nil {
+		if s.readOnly.CompareAndSwap(true, false) {
+			log.Println("[INFO] - " + logPrefix(ctx) + "data directory is writable again")
+		}
+		return nil
+	}
+	if errors.Is(err, syscall.EROFS) || errors.Is(err, fs.ErrPermission) {
+		if s.readOnly.CompareAndSwap(false, true) {
+			log.Println("[ERROR] - " + logPrefix(ctx) + "data directory is not writable: " + err.Error())
+		}
+		return &Error{
+			msg:    "storage is temporarily not writable",
+			Code:   "ServiceUnavailable",
+			Status: http.StatusServiceUnavailable,
+		}
+	}
+	return err
+}
+
 func Sha256Hash(data []byte) string {
 	hash := sha256.Sum256(data)
 	return hex.EncodeToString(hash[:])
 }
+
+// ETag returns the quoted hex MD5 of the body, which is what S3 returns as
+// ETag for objects uploaded in a single part.
+func ETag(data []byte) string {
+	hash := md5.Sum(data)
+	return `"` + hex.EncodeToString(hash[:]) + `"`
+}
@@ -0,0 +1,60 @@
+package domain
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// WithEncryption encrypts object bodies with AES-256-GCM before they are
+// written to disk. The key is derived from the master key, which therefore
+// must never change for an existing dataset.
+func WithEncryption(masterKey string) StorageOption {
+	return func(s *Storage) {
+		s.masterKey = &masterKey
+	}
+}
+
+func newCipher(masterKey string) (cipher.AEAD, error) {
+	if len(masterKey) < 1 {
+		return nil, errors.New("encryption is enabled but the master key is empty")
+	}
+	key, err := hkdf.Key(sha256.New, []byte(masterKey), nil, "bucket object encryption", 32)
+	if err != nil {
+		return nil, fmt.Errorf("could not derive encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt returns the hex encoded nonce and the ciphertext.
+func encrypt(aead cipher.AEAD, data []byte) (string, []byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, fmt.Errorf("could not generate nonce: %w", err)
+	}
+	return hex.EncodeToString(nonce), aead.Seal(nil, nonce, data, nil), nil
+}
+
+func decrypt(aead cipher.AEAD, nonce string, data []byte) ([]byte, error) {
+	if aead == nil {
+		return nil, errors.New("object is encrypted but encryption is not configured")
+	}
+	n, err := hex.DecodeString(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode nonce: %w", err)
+	}
+	plain, err := aead.Open(nil, n, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt body: %w", err)
+	}
+	return plain, nil
+}
@@ -0,0 +1,59 @@
+package domain
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	var tests = []struct {
+		name string
+		opts []StorageOption
+	}{
+		{"encryption", []StorageOption{WithEncryption("test-master-key")}},
+		{"encryption and compression", []StorageOption{WithEncryption("test-master-key"), WithCompression()}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage, err := NewStorage(t.TempDir(), test.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			body := []byte("hello world! hello world! hello world!")
+			if err := storage.Put("bucket", "key", body); err != nil {
+				t.Fatal(err)
+			}
+
+			dir, err := storage.objectDir("bucket", "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			onDisk, err := os.ReadFile(dir + "/body")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(onDisk, []byte("hello world!")) {
+				t.Error("got plaintext on disk, want ciphertext")
+			}
+
+			got, err := storage.Get("bucket", "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, body) {
+				t.Errorf("got body: '%s', want body: '%s'", got, body)
+			}
+		})
+	}
+}
+
+func TestEncryptionWithoutKey(t *testing.T) {
+	if _, err := NewStorage(t.TempDir(), WithEncryption("")); err == nil {
+		t.Error("storage with encryption but without key should fail")
+	}
+}
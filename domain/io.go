@@ -0,0 +1,131 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// chunkSize is the amount of bytes copied between checks of the context.
+const chunkSize = 1 << 20
+
+// copyCtx copies from src to dst like io.Copy, but stops with the error of the
+// context once it is done.
+func copyCtx(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	buf := make([]byte, chunkSize)
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			m, err := dst.Write(buf[:n])
+			written += int64(m)
+			if err != nil {
+				return written, err
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		} else if err != nil {
+			return written, err
+		}
+	}
+}
+
+func readFileCtx(ctx context.Context, path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	if _, err := copyCtx(ctx, &buf, f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeTempCtx writes data into a new temporary file in dir and returns its
+// path. The file is removed again if the write fails or ctx is done.
+func writeTempCtx(ctx context.Context, dir string, data []byte, perm os.FileMode) (string, error) {
+	return writeTempFromCtx(ctx, dir, bytes.NewReader(data), perm)
+}
+
+func writeTempFromCtx(ctx context.Context, dir string, src io.Reader, perm os.FileMode) (path string, err error) {
+	f, err := os.CreateTemp(dir, ".body-*")
+	if err != nil {
+		return "", fmt.Errorf("could not create temporary file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err := copyCtx(ctx, f, src); err != nil {
+		return "", fmt.Errorf("could not write temporary file: %w", err)
+	}
+	if err := f.Chmod(perm); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("could not write temporary file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// mkdirAll is like os.MkdirAll, but every directory it creates gets exactly
+// the given permissions, no matter the umask of the process.
+func mkdirAll(path string, perm os.FileMode) error {
+	missing := []string{}
+	for dir := filepath.Clean(path); !exists(dir); dir = filepath.Dir(dir) {
+		missing = append(missing, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	if err := os.MkdirAll(path, perm); err != nil {
+		return err
+	}
+	for _, dir := range missing {
+		if err := os.Chmod(dir, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data. The data is written
+// into a temporary file with a unique name next to it first, so concurrent
+// writers never share a file and readers never see a partial write. The file
+// gets exactly the given permissions, no matter the umask of the process.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
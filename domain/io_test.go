@@ -0,0 +1,154 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// cancelReader cancels the context after the first read.
+type cancelReader struct {
+	r      *bytes.Reader
+	cancel context.CancelFunc
+}
+
+func (c *cancelReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.cancel()
+	return n, err
+}
+
+func TestWriteTempCancel(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	src := &cancelReader{r: bytes.NewReader(make([]byte, 3*chunkSize)), cancel: cancel}
+
+	_, err := writeTempFromCtx(ctx, dir, src, 0644)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error: '%v', want error: '%v'", err, context.Canceled)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got files: '%d', want no leftover files", len(entries))
+	}
+}
+
+func TestPutCtxCancel(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = storage.PutCtx(ctx, "bucket", "key", []byte("hello world!"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error: '%v', want error: '%v'", err, context.Canceled)
+	}
+
+	dir, err := storage.objectDir("bucket", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists(dir) {
+		t.Error("cancelled put left the object directory behind")
+	}
+	count, size, err := storage.BucketStats("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 || size != 0 {
+		t.Errorf("got count: '%d' and size: '%d', want an empty bucket", count, size)
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := storage.objectDir("bucket", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// writers which do not hold the object lock must still get their own files
+	var mu sync.Mutex
+	names := map[string]bool{}
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tmp, err := writeTempCtx(context.Background(), dir, []byte(strconv.Itoa(i)), 0644)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if names[tmp] {
+				t.Errorf("got temporary file: '%s' twice, want unique names", tmp)
+			}
+			names[tmp] = true
+			os.Remove(tmp)
+		}()
+	}
+	wg.Wait()
+
+	bodies := map[string]bool{}
+	for i := range 16 {
+		body := "body " + strconv.Itoa(i)
+		bodies[body] = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := storage.Put("bucket", "key", []byte(body)); err != nil {
+				t.Error(err)
+			}
+			if err := writeFileAtomic(filepath.Join(dir, "extra.json"), []byte(body), 0644); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := storage.Get("bucket", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bodies[string(got)] {
+		t.Errorf("got body: '%s', want body of one of the writes", got)
+	}
+	err = filepath.WalkDir(storage.path, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasPrefix(d.Name(), ".") {
+			t.Errorf("got leftover temporary file: '%s', want none", path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,171 @@
+package domain
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// KeyInfo describes an access key without its secret.
+type KeyInfo struct {
+	AccessKey   string
+	Permissions Permission
+	// Stored is set for keys which are persisted in the key file
+	Stored bool
+}
+
+type storedKey struct {
+	AccessKey   string     `json:"access_key"`
+	SecretKey   string     `json:"secret_key"`
+	Permissions Permission `json:"permissions"`
+}
+
+// LoadKeys adds the keys stored in the file to the Auth and remembers the
+// file, so keys added with StoreKey are written to it. A missing file is not
+// an error, it is created with the first stored key. A stored key never
+// replaces a key the Auth was configured with.
+func (a *Auth) LoadKeys(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not read key file: %w", err)
+	}
+	keys := []storedKey{}
+	if err == nil {
+		if err := json.Unmarshal(b, &keys); err != nil {
+			return fmt.Errorf("could not unmarshal key file content: %w", err)
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.file = path
+	for _, k := range keys {
+		if cred, ok := a.keys[k.AccessKey]; ok && !cred.stored {
+			log.Printf("[WARN] - ignoring stored key '%s', it is already configured", k.AccessKey)
+			continue
+		}
+		a.keys[k.AccessKey] = &credential{secretKey: k.SecretKey, permissions: k.Permissions, stored: true}
+	}
+	return nil
+}
+
+// StoreKey adds a key pair like AddKeyWithPermissions and persists it in the
+// key file. An empty access or secret key is generated. An access key which
+// already exists is rejected.
+func (a *Auth) StoreKey(accessKey, secretKey string, perm Permission) (string, string, error) {
+	if len(accessKey) < 1 {
+		accessKey = strings.ToUpper(randomHex(10))
+	}
+	if len(secretKey) < 1 {
+		secretKey = randomHex(20)
+	}
+	if perm&PermFull == 0 {
+		return "", "", &Error{
+			msg:    "key must have at least one permission",
+			Code:   "InvalidArgument",
+			Status: http.StatusBadRequest,
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.keys[accessKey]; ok {
+		return "", "", &Error{
+			msg:    "the access key id already exists",
+			Code:   "KeyAlreadyExists",
+			Status: http.StatusConflict,
+		}
+	}
+	a.keys[accessKey] = &credential{secretKey: secretKey, permissions: perm, stored: true}
+	if err := a.save(); err != nil {
+		// the key must not be usable if it would be gone after a restart
+		delete(a.keys, accessKey)
+		return "", "", err
+	}
+	return accessKey, secretKey, nil
+}
+
+// RevokeKey removes an access key like RemoveKey and deletes it from the key
+// file. Requests signed with it are rejected from then on. Keys the Auth was
+// configured with are not in the key file and would be back after a restart,
+// so they can not be revoked.
+func (a *Auth) RevokeKey(accessKey string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cred, ok := a.keys[accessKey]
+	if !ok {
+		return &Error{
+			msg:    "the access key id does not exist",
+			Code:   "InvalidAccessKeyId",
+			Status: http.StatusNotFound,
+		}
+	}
+	if !cred.stored {
+		return &Error{
+			msg:    "the access key is configured at startup and can not be revoked",
+			Code:   "InvalidArgument",
+			Status: http.StatusBadRequest,
+		}
+	}
+	delete(a.keys, accessKey)
+	if err := a.save(); err != nil {
+		// the key must stay usable if it would be back after a restart
+		a.keys[accessKey] = cred
+		return err
+	}
+	return nil
+}
+
+// Keys returns all access keys sorted by name.
+func (a *Auth) Keys() []KeyInfo {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	keys := []KeyInfo{}
+	for accessKey, cred := range a.keys {
+		keys = append(keys, KeyInfo{AccessKey: accessKey, Permissions: cred.permissions, Stored: cred.stored})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].AccessKey < keys[j].AccessKey
+	})
+	return keys
+}
+
+// save writes the stored keys into the key file. It must be called while
+// holding a.mu. The file is replaced atomically, so a crash never leaves a
+// truncated file behind.
+func (a *Auth) save() error {
+	if len(a.file) < 1 {
+		return nil
+	}
+	keys := []storedKey{}
+	for accessKey, cred := range a.keys {
+		if cred.stored {
+			keys = append(keys, storedKey{AccessKey: accessKey, SecretKey: cred.secretKey, Permissions: cred.permissions})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].AccessKey < keys[j].AccessKey
+	})
+	b, err := json.Marshal(keys)
+	if err != nil {
+		return fmt.Errorf("could not marshal keys: %w", err)
+	}
+
+	if err := writeFileAtomic(a.file, b, 0600); err != nil {
+		return fmt.Errorf("could not write key file: %w", err)
+	}
+	return nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
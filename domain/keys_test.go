@@ -0,0 +1,110 @@
+package domain
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	auth := NewAuth("test-access-key", "test-secret-key")
+	if err := auth.LoadKeys(path); err != nil {
+		t.Fatal(err)
+	}
+
+	access, secret, err := auth.StoreKey("", "", PermRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(access) < 1 || len(secret) < 1 {
+		t.Fatalf("got key pair: '%s/%s', want generated key pair", access, secret)
+	}
+	if _, _, err := auth.StoreKey("other-key", "other-secret", 0); err == nil {
+		t.Error("key without permissions should be rejected")
+	}
+	for _, existing := range []string{access, "test-access-key"} {
+		if _, _, err := auth.StoreKey(existing, "other-secret", PermFull); !hasCode(err, "KeyAlreadyExists") {
+			t.Errorf("got error: '%v', want code: 'KeyAlreadyExists'", err)
+		}
+	}
+	if got, ok := auth.secretKey("test-access-key"); !ok || got != "test-secret-key" {
+		t.Errorf("got secret: '%s', want secret: 'test-secret-key'", got)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("got mode: '%v', want mode: '%v'", info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	// a restarted server only gets the stored keys back from the file
+	restarted := NewAuth("test-access-key", "test-secret-key")
+	if err := restarted.LoadKeys(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := restarted.secretKey(access); !ok || got != secret {
+		t.Errorf("got secret: '%s', want secret: '%s'", got, secret)
+	}
+	// a key file can not replace the configured key pair either
+	configured := NewAuth(access, "configured-secret-key")
+	if err := configured.LoadKeys(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := configured.secretKey(access); got != "configured-secret-key" {
+		t.Errorf("got secret: '%s', want secret: 'configured-secret-key'", got)
+	}
+	if !restarted.Permitted(access, "GET") || restarted.Permitted(access, "PUT") {
+		t.Error("stored key should only be permitted to read")
+	}
+	if keys := restarted.Keys(); len(keys) != 2 {
+		t.Errorf("got keys: '%v', want 2 keys", keys)
+	}
+
+	if err := restarted.RevokeKey(access); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := restarted.secretKey(access); ok {
+		t.Error("revoked key should be gone")
+	}
+	if err := restarted.RevokeKey(access); !hasCode(err, "InvalidAccessKeyId") {
+		t.Errorf("got error: '%v', want code: 'InvalidAccessKeyId'", err)
+	}
+	again := NewAuth("test-access-key", "test-secret-key")
+	if err := again.LoadKeys(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := again.secretKey(access); ok {
+		t.Error("revoked key should not be loaded again")
+	}
+}
+
+func TestRevokeKey(t *testing.T) {
+	dir := t.TempDir()
+	auth := NewAuth("test-access-key", "test-secret-key")
+	if err := auth.LoadKeys(filepath.Join(dir, "keys.json")); err != nil {
+		t.Fatal(err)
+	}
+	access, _, err := auth.StoreKey("", "", PermFull)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the configured key would be back after a restart
+	if err := auth.RevokeKey("test-access-key"); !hasCode(err, "InvalidArgument") {
+		t.Errorf("got error: '%v', want code: 'InvalidArgument'", err)
+	}
+	if _, ok := auth.secretKey("test-access-key"); !ok {
+		t.Error("configured key should not be revoked")
+	}
+
+	// a key which can not be deleted from the key file stays usable
+	auth.file = filepath.Join(dir, "missing", "keys.json")
+	if err := auth.RevokeKey(access); err == nil {
+		t.Error("got error: '<nil>', want key file to be unwritable")
+	}
+	if _, ok := auth.secretKey(access); !ok {
+		t.Error("key should stay usable if it could not be revoked")
+	}
+}
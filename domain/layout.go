@@ -0,0 +1,101 @@
+package domain
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hashLen is the length of the hex encoded sha256 hash an object directory
+// is named after.
+const hashLen = 64
+
+// WithFanOut nests object directories under levels of shard directories, each
+// named after the next two hex characters of the hash, e.g. with a fan-out of
+// 2 an object is stored under ab/cd/abcd.../. This keeps the number of entries
+// per directory small for buckets with many objects. Existing objects have to
+// be moved with MigrateLayout after changing the fan-out.
+func WithFanOut(levels int) StorageOption {
+	return func(s *Storage) {
+		s.fanOut = min(max(levels, 0), hashLen/2)
+	}
+}
+
+// shardPath returns the directory of the object with the given hash.
+func (s *Storage) shardPath(bucketDir, hash string) string {
+	elems := []string{bucketDir}
+	for i := range s.fanOut {
+		elems = append(elems, hash[i*2:i*2+2])
+	}
+	return filepath.Join(append(elems, hash)...)
+}
+
+// objectDirs returns the directories of all objects in a bucket, no matter
+// how deep they are nested. Hidden directories are used internally (e.g. for
+// the trash) and are skipped.
+func objectDirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read bucket directory: %w", err)
+	}
+
+	dirs := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if len(name) == hashLen {
+			dirs = append(dirs, path)
+			continue
+		}
+		if len(name) != 2 {
+			continue
+		}
+		nested, err := objectDirs(path)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, nested...)
+	}
+	return dirs, nil
+}
+
+// MigrateLayout moves all objects of a bucket which are not stored at the
+// location the configured fan-out expects, e.g. a flat layout into a sharded
+// one. It returns the number of moved objects.
+func (s *Storage) MigrateLayout(bucket string) (int, error) {
+	bucketDir, err := s.bucketDir(bucket)
+	if err != nil {
+		return 0, err
+	}
+	dirs, err := objectDirs(bucketDir)
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, dir := range dirs {
+		target := s.shardPath(bucketDir, filepath.Base(dir))
+		if target == dir {
+			continue
+		}
+		if err := mkdirAll(filepath.Dir(target), s.dirMode); err != nil {
+			return moved, err
+		}
+		if err := os.Rename(dir, target); err != nil {
+			return moved, fmt.Errorf("could not move object directory: %w", err)
+		}
+		moved++
+
+		// remove the shard directories which became empty
+		for parent := filepath.Dir(dir); parent != bucketDir; parent = filepath.Dir(parent) {
+			if os.Remove(parent) != nil {
+				break
+			}
+		}
+	}
+	return moved, nil
+}
@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestShardPath(t *testing.T) {
+	hash := Sha256Hash([]byte("key"))
+
+	var tests = []struct {
+		name   string
+		fanOut int
+		want   string
+	}{
+		{"flat", 0, filepath.Join("/data/bucket", hash)},
+		{"one level", 1, filepath.Join("/data/bucket", hash[:2], hash)},
+		{"two levels", 2, filepath.Join("/data/bucket", hash[:2], hash[2:4], hash)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage := &Storage{}
+			WithFanOut(test.fanOut)(storage)
+			got := storage.shardPath("/data/bucket", hash)
+			if got != test.want {
+				t.Errorf("got path: '%s', want path: '%s'", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	path := t.TempDir()
+	flat, err := NewStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := flat.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		if err := flat.Put("bucket", fmt.Sprintf("key-%d", i), []byte("hello world!")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sharded, err := NewStorage(path, WithFanOut(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved, err := sharded.MigrateLayout("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 10 {
+		t.Errorf("got moved: '%d', want moved: '%d'", moved, 10)
+	}
+
+	count, _, err := sharded.BucketStats("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 10 {
+		t.Errorf("got count: '%d', want count: '%d'", count, 10)
+	}
+	for i := range 10 {
+		key := fmt.Sprintf("key-%d", i)
+		if _, err := sharded.Get("bucket", key); err != nil {
+			t.Errorf("could not get '%s' after migration: %v", key, err)
+		}
+	}
+	if err := sharded.Delete("bucket", "key-0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sharded.Get("bucket", "key-0"); err == nil {
+		t.Error("deleted object should not be readable")
+	}
+}
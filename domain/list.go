@@ -0,0 +1,191 @@
+package domain
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxListKeys is the upper limit of objects returned by a single List call.
+const maxListKeys = 1000
+
+// defaultListConcurrency is the number of metadata files List reads at once.
+const defaultListConcurrency = 8
+
+// WithListConcurrency sets the number of metadata files List reads at once.
+// It defaults to 8.
+func WithListConcurrency(n int) StorageOption {
+	return func(s *Storage) {
+		s.listConcurrency = n
+	}
+}
+
+// ListOptions select the page of objects returned by List.
+type ListOptions struct {
+	Prefix string
+	// Delimiter rolls up the keys which contain it after the prefix into
+	// common prefixes, e.g. "/" to list a single level of "folders"
+	Delimiter         string
+	StartAfter        string
+	ContinuationToken string
+	MaxKeys           int // defaults to (and is capped at) 1000
+}
+
+// ListResult is a single page of objects and common prefixes sorted by key.
+type ListResult struct {
+	Objects               []*ObjectInfo
+	CommonPrefixes        []string
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// encodeToken returns the continuation token resuming after the key. It
+// encodes the key itself instead of an offset, so pages stay stable when
+// objects are added or removed in between.
+func encodeToken(key string) string {
+	return base64.StdEncoding.EncodeToString([]byte(key))
+}
+
+func decodeToken(token string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return "", &Error{
+			msg:    "the continuation token provided is incorrect",
+			Code:   "InvalidArgument",
+			Status: http.StatusBadRequest,
+		}
+	}
+	return string(key), nil
+}
+
+// List returns the objects of a bucket in lexical order of their keys.
+// Listing resumes strictly after the key of the continuation token, or after
+// StartAfter if no token is given.
+func (s *Storage) List(bucket string, opts ListOptions) (*ListResult, error) {
+	dir, err := s.bucketDir(bucket)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := objectDirs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []*ObjectInfo{}
+	for _, meta := range s.readAllMetadata(bucket, dirs) {
+		// objects repaired without their key are only reported by the repair
+		if meta != nil && !meta.DeleteMarker && len(meta.OriginalKey) > 0 {
+			objects = append(objects, newObjectInfo(meta))
+		}
+	}
+	return listPage(objects, opts)
+}
+
+// readAllMetadata reads the metadata of the object directories with up to
+// listConcurrency workers. The result has the order of dirs, the metadata of
+// an object which could not be read is nil. Such objects are logged and
+// skipped instead of failing the whole listing, unless they were deleted in
+// the meantime.
+func (s *Storage) readAllMetadata(bucket string, dirs []string) []*metadata {
+	metas := make([]*metadata, len(dirs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(s.listConcurrency, 1), len(dirs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				meta, err := readMetadata(dirs[i])
+				if err == nil {
+					metas[i] = meta
+				} else if isCorrupted(err) {
+					log.Printf("[ERROR] - corrupted metadata of bucket '%s' at '%s'", bucket, dirs[i])
+				} else if !errors.Is(err, os.ErrNotExist) {
+					log.Printf("[ERROR] - could not read metadata of bucket '%s' at '%s': %s", bucket, dirs[i], err)
+				}
+			}
+		}()
+	}
+	for i := range dirs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return metas
+}
+
+// listPage selects the page of the objects the options ask for.
+func listPage(objects []*ObjectInfo, opts ListOptions) (*ListResult, error) {
+	after := opts.StartAfter
+	if len(opts.ContinuationToken) > 0 {
+		key, err := decodeToken(opts.ContinuationToken)
+		if err != nil {
+			return nil, err
+		}
+		after = key
+	}
+	maxKeys := opts.MaxKeys
+	if maxKeys <= 0 || maxKeys > maxListKeys {
+		maxKeys = maxListKeys
+	}
+
+	page := []*ObjectInfo{}
+	for _, obj := range objects {
+		if !strings.HasPrefix(obj.Key, opts.Prefix) || obj.Key <= after {
+			continue
+		}
+		// a token naming a common prefix resumes after all of its keys
+		if prefix := commonPrefix(obj.Key, opts); len(prefix) > 0 && prefix == after {
+			continue
+		}
+		page = append(page, obj)
+	}
+	sort.Slice(page, func(i, j int) bool {
+		return page[i].Key < page[j].Key
+	})
+
+	// every object and every common prefix counts towards maxKeys, the keys
+	// of a common prefix are adjacent after sorting
+	result := &ListResult{Objects: []*ObjectInfo{}, CommonPrefixes: []string{}}
+	entries, last := 0, ""
+	for _, obj := range page {
+		prefix := commonPrefix(obj.Key, opts)
+		if len(prefix) > 0 && prefix == last {
+			continue
+		}
+		if entries == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = encodeToken(last)
+			break
+		}
+		if len(prefix) > 0 {
+			result.CommonPrefixes = append(result.CommonPrefixes, prefix)
+			last = prefix
+		} else {
+			result.Objects = append(result.Objects, obj)
+			last = obj.Key
+		}
+		entries++
+	}
+	return result, nil
+}
+
+// commonPrefix returns the common prefix the key is rolled up into, which is
+// empty unless the key contains the delimiter after the prefix. The key must
+// start with the prefix.
+func commonPrefix(key string, opts ListOptions) string {
+	if len(opts.Delimiter) < 1 {
+		return ""
+	}
+	rest := key[len(opts.Prefix):]
+	i := strings.Index(rest, opts.Delimiter)
+	if i < 0 {
+		return ""
+	}
+	return opts.Prefix + rest[:i+len(opts.Delimiter)]
+}
@@ -0,0 +1,228 @@
+package domain
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func listKeys(t *testing.T, storage *Storage, opts ListOptions) ([]string, *ListResult) {
+	t.Helper()
+	result, err := storage.List("bucket", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, o := range result.Objects {
+		keys = append(keys, o.Key)
+	}
+	return keys, result
+}
+
+func TestList(t *testing.T) {
+	var tests = []struct {
+		name string
+		opts ListOptions
+		keys []string
+	}{
+		{"all", ListOptions{}, []string{"a", "b/1", "b/2", "c"}},
+		{"prefix", ListOptions{Prefix: "b/"}, []string{"b/1", "b/2"}},
+		{"start after", ListOptions{StartAfter: "b/1"}, []string{"b/2", "c"}},
+		{"start after missing key", ListOptions{StartAfter: "b"}, []string{"b/1", "b/2", "c"}},
+		{"token overrides start after", ListOptions{StartAfter: "a", ContinuationToken: encodeToken("b/2")}, []string{"c"}},
+		{"max keys", ListOptions{MaxKeys: 2}, []string{"a", "b/1"}},
+	}
+
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"c", "b/2", "a", "b/1"} {
+		if err := storage.Put("bucket", key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys, _ := listKeys(t, storage, test.opts)
+			if !reflect.DeepEqual(keys, test.keys) {
+				t.Errorf("got keys: '%v', want keys: '%v'", keys, test.keys)
+			}
+		})
+	}
+
+	if _, err := storage.List("bucket", ListOptions{ContinuationToken: "%%%"}); err == nil {
+		t.Error("got error: '<nil>', want error for invalid token")
+	}
+}
+
+func TestListDelimiter(t *testing.T) {
+	var tests = []struct {
+		name     string
+		opts     ListOptions
+		keys     []string
+		prefixes []string
+	}{
+		{"without delimiter", ListOptions{Prefix: "photos/2024/"}, []string{"photos/2024/a.jpg", "photos/2024/b.jpg"}, []string{}},
+		{"root", ListOptions{Delimiter: "/"}, []string{"readme.md", "z"}, []string{"docs/", "photos/"}},
+		{"folder", ListOptions{Prefix: "photos/", Delimiter: "/"}, []string{"photos/", "photos/cat.jpg"}, []string{"photos/2024/"}},
+		{"nested folder", ListOptions{Prefix: "photos/2024/", Delimiter: "/"}, []string{"photos/2024/a.jpg", "photos/2024/b.jpg"}, []string{}},
+		{"partial prefix", ListOptions{Prefix: "ph", Delimiter: "/"}, []string{}, []string{"photos/"}},
+		{"max keys", ListOptions{Delimiter: "/", MaxKeys: 2}, []string{}, []string{"docs/", "photos/"}},
+		{"token of common prefix", ListOptions{Delimiter: "/", ContinuationToken: encodeToken("docs/")}, []string{"readme.md", "z"}, []string{"photos/"}},
+	}
+
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{
+		"readme.md", "z", "docs/cv.pdf", "docs/notes/todo.txt", "photos/",
+		"photos/cat.jpg", "photos/2024/a.jpg", "photos/2024/b.jpg",
+	} {
+		if err := storage.Put("bucket", key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys, result := listKeys(t, storage, test.opts)
+			if !reflect.DeepEqual(keys, test.keys) {
+				t.Errorf("got keys: '%v', want keys: '%v'", keys, test.keys)
+			}
+			if !reflect.DeepEqual(result.CommonPrefixes, test.prefixes) {
+				t.Errorf("got prefixes: '%v', want prefixes: '%v'", result.CommonPrefixes, test.prefixes)
+			}
+		})
+	}
+
+	// paging one entry at a time lists every common prefix exactly once
+	seen := []string{}
+	page := &ListResult{IsTruncated: true}
+	for page.IsTruncated {
+		var keys []string
+		keys, page = listKeys(t, storage, ListOptions{Delimiter: "/", MaxKeys: 1, ContinuationToken: page.NextContinuationToken})
+		seen = append(append(seen, page.CommonPrefixes...), keys...)
+	}
+	want := []string{"docs/", "photos/", "readme.md", "z"}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("got entries: '%v', want entries: '%v'", seen, want)
+	}
+}
+
+func TestListStablePagination(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "c", "e", "g"} {
+		if err := storage.Put("bucket", key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, page := listKeys(t, storage, ListOptions{MaxKeys: 2})
+	if !page.IsTruncated {
+		t.Fatal("got truncated: 'false', want truncated: 'true'")
+	}
+	seen := keys
+
+	// a key before and a key after the cursor are added, one listed key is
+	// removed, none of them may shift the next page
+	for _, key := range []string{"b", "d"} {
+		if err := storage.Put("bucket", key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.Delete("bucket", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	for page.IsTruncated {
+		keys, page = listKeys(t, storage, ListOptions{MaxKeys: 2, ContinuationToken: page.NextContinuationToken})
+		seen = append(seen, keys...)
+	}
+	want := []string{"a", "c", "d", "e", "g"}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("got keys: '%v', want keys: '%v'", seen, want)
+	}
+}
+
+func TestListConcurrency(t *testing.T) {
+	want := []string{}
+	for i := range 100 {
+		want = append(want, fmt.Sprintf("key-%03d", i))
+	}
+
+	for _, n := range []int{1, 3, 8, 200} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			storage, err := NewStorage(t.TempDir(), WithListConcurrency(n))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			// reverse order, so the object directories are not sorted by key
+			for i := len(want) - 1; i >= 0; i-- {
+				if err := storage.Put("bucket", want[i], []byte("hello")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := storage.Put("bucket", "corrupt", []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			dir, err := storage.objectDir("bucket", "corrupt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(dir+"/metadata.json", []byte(`{"content_sha256": "ab`), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, _ := listKeys(t, storage, ListOptions{})
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got keys: '%v', want keys: '%v'", got, want)
+			}
+		})
+	}
+}
+
+func BenchmarkList(b *testing.B) {
+	for _, n := range []int{1, 8, 32} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			storage, err := NewStorage(b.TempDir(), WithListConcurrency(n))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				b.Fatal(err)
+			}
+			for i := range maxListKeys {
+				if err := storage.Put("bucket", fmt.Sprintf("key-%04d", i), []byte("hello")); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ResetTimer()
+			for range b.N {
+				if _, err := storage.List("bucket", ListOptions{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package domain
+
+import "sync"
+
+// objectLocks serializes the check-then-write cycles on a single object
+// directory, while operations on different objects still run in parallel.
+type objectLocks struct {
+	mu    sync.Mutex
+	locks map[string]*objectLock
+}
+
+type objectLock struct {
+	sync.Mutex
+	// refs counts the holders and waiters, the lock is dropped from the map
+	// once it reaches zero
+	refs int
+}
+
+// lock blocks until the object directory is free and returns the function
+// releasing it again.
+func (o *objectLocks) lock(dir string) func() {
+	o.mu.Lock()
+	if o.locks == nil {
+		o.locks = map[string]*objectLock{}
+	}
+	l, ok := o.locks[dir]
+	if !ok {
+		l = &objectLock{}
+		o.locks[dir] = l
+	}
+	l.refs++
+	o.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		o.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(o.locks, dir)
+		}
+		o.mu.Unlock()
+	}
+}
@@ -0,0 +1,822 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemStorage keeps buckets and objects in memory. It validates names and
+// reports errors exactly like Storage, so the server behaves the same on top
+// of it, but nothing survives a restart.
+type MemStorage struct {
+	mu      sync.Mutex
+	config  *Storage // only carries the options, nothing is stored on disk
+	buckets map[string]*memBucket
+}
+
+type memBucket struct {
+	info       BucketInfo
+	quota      int64
+	maxSize    int64
+	maxObjects int64
+	usedBytes  int64
+	versioning string
+	acl        string
+	cors       []CORSRule
+	website    *WebsiteConfiguration
+	// objects maps the key to its versions, the latest comes first
+	objects map[string][]*memVersion
+	// uploads maps the upload ID to the multipart upload
+	uploads map[string]*memUpload
+}
+
+type memUpload struct {
+	key       string
+	initiated time.Time
+	opts      PutOptions
+	parts     map[int][]byte
+}
+
+type memVersion struct {
+	meta *metadata
+	body []byte
+}
+
+// NewMemStorage returns an empty in-memory storage. Of the options only those
+// which do not concern the files on disk have an effect: WithRegion,
+// WithCaseInsensitiveKeys, WithNFCKeys, WithRelaxedNaming and WithMaxKeyLength.
+func NewMemStorage(opts ...StorageOption) *MemStorage {
+	config := &Storage{region: "us-east-1", maxKeyLength: defaultMaxKeyLength}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return &MemStorage{config: config, buckets: map[string]*memBucket{}}
+}
+
+// Close does nothing, MemStorage has no background goroutines. It exists so
+// both storages can be shut down the same way.
+func (m *MemStorage) Close() {}
+
+// Region returns the region new buckets are created in.
+func (m *MemStorage) Region() string {
+	return m.config.region
+}
+
+// bucket must be called while holding m.mu.
+func (m *MemStorage) bucket(name string) (*memBucket, error) {
+	if err := m.config.validName(name); err != nil {
+		return nil, err
+	}
+	b, ok := m.buckets[name]
+	if !ok {
+		return nil, errNoSuchBucket
+	}
+	return b, nil
+}
+
+// objectKey returns the key an object is stored under in its bucket.
+func (m *MemStorage) objectKey(key string) (string, error) {
+	if err := m.config.validKey(key); err != nil {
+		return "", err
+	}
+	return m.config.canonicalKey(key), nil
+}
+
+func (m *MemStorage) NewBucket(name, owner string) error {
+	if err := m.config.validName(name); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b, ok := m.buckets[name]; ok {
+		if b.info.OwnerAccessKey == owner {
+			return errBucketOwnedByYou
+		}
+		return errBucketExists
+	}
+	m.buckets[name] = &memBucket{
+		info: BucketInfo{
+			Name:           name,
+			CreatedAt:      time.Now().UTC(),
+			OwnerAccessKey: owner,
+			Region:         m.config.region,
+		},
+		objects: map[string][]*memVersion{},
+		uploads: map[string]*memUpload{},
+	}
+	return nil
+}
+
+func (m *MemStorage) Bucket(name string) (*BucketInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(name)
+	if err != nil {
+		return nil, err
+	}
+	info := b.info
+	return &info, nil
+}
+
+// ListBuckets returns all buckets sorted by name.
+func (m *MemStorage) ListBuckets() ([]*BucketInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	buckets := []*BucketInfo{}
+	for _, b := range m.buckets {
+		info := b.info
+		buckets = append(buckets, &info)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Name < buckets[j].Name
+	})
+	return buckets, nil
+}
+
+func (m *MemStorage) BucketStats(name string) (int, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	count := 0
+	var size int64
+	for _, versions := range b.objects {
+		if latest := versions[0].meta; !latest.DeleteMarker {
+			count++
+			size += int64(latest.ContentSize)
+		}
+	}
+	return count, size, nil
+}
+
+// BucketUsage returns the number of objects of a bucket and the bytes of all
+// of their versions.
+func (m *MemStorage) BucketUsage(name string) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	var count int64
+	for _, versions := range b.objects {
+		if !versions[0].meta.DeleteMarker {
+			count++
+		}
+	}
+	return count, b.usedBytes, nil
+}
+
+// ScrubMismatches is always 0, memory is never scrubbed.
+func (m *MemStorage) ScrubMismatches(bucket string) int {
+	return 0
+}
+
+// RepairBucket never repairs anything, objects in memory have no metadata
+// files which could get lost.
+func (m *MemStorage) RepairBucket(name string) (*RepairResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.bucket(name); err != nil {
+		return nil, err
+	}
+	return &RepairResult{Repaired: []RepairedObject{}, Failed: []string{}}, nil
+}
+
+func (m *MemStorage) SetBucketQuota(name string, bytes int64) error {
+	if err := validLimit(bytes, "bucket quota"); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(name)
+	if err != nil {
+		return err
+	}
+	b.quota = bytes
+	return nil
+}
+
+func (m *MemStorage) SetBucketMaxObjectSize(name string, bytes int64) error {
+	if err := validLimit(bytes, "maximum object size"); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(name)
+	if err != nil {
+		return err
+	}
+	b.maxSize = bytes
+	return nil
+}
+
+func (m *MemStorage) SetBucketMaxObjects(name string, count int64) error {
+	if err := validLimit(count, "maximum number of objects"); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(name)
+	if err != nil {
+		return err
+	}
+	b.maxObjects = count
+	return nil
+}
+
+// checkObjectCount reports whether a new object under the key would exceed
+// the maximum number of objects of the bucket. It must be called while
+// holding m.mu.
+func (b *memBucket) checkObjectCount(objKey string) error {
+	if b.maxObjects < 1 {
+		return nil
+	}
+	if versions := b.objects[objKey]; len(versions) > 0 && !versions[0].meta.DeleteMarker {
+		return nil
+	}
+	var count int64
+	for _, versions := range b.objects {
+		if !versions[0].meta.DeleteMarker {
+			count++
+		}
+	}
+	if count >= b.maxObjects {
+		return errTooManyObjects
+	}
+	return nil
+}
+
+func (m *MemStorage) SetBucketVersioning(name, status string) error {
+	if err := validVersioning(status); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(name)
+	if err != nil {
+		return err
+	}
+	b.versioning = status
+	return nil
+}
+
+func (m *MemStorage) BucketVersioning(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(name)
+	if err != nil {
+		return "", err
+	}
+	return b.versioning, nil
+}
+
+func (m *MemStorage) SetBucketACL(name, acl string) error {
+	if err := validACL(acl); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(name)
+	if err != nil {
+		return err
+	}
+	b.acl = acl
+	return nil
+}
+
+func (m *MemStorage) BucketACL(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(name)
+	if err != nil {
+		return "", err
+	}
+	if len(b.acl) < 1 {
+		return ACLPrivate, nil
+	}
+	return b.acl, nil
+}
+
+func (m *MemStorage) SetBucketCORS(name string, rules []CORSRule) error {
+	if err := validCORSRules(rules); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(name)
+	if err != nil {
+		return err
+	}
+	b.cors = rules
+	return nil
+}
+
+func (m *MemStorage) BucketCORS(name string) ([]CORSRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(name)
+	if err != nil {
+		return nil, err
+	}
+	if b.cors == nil {
+		return nil, errNoSuchCORS
+	}
+	return b.cors, nil
+}
+
+func (m *MemStorage) DeleteBucketCORS(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(name)
+	if err != nil {
+		return err
+	}
+	b.cors = nil
+	return nil
+}
+
+func (m *MemStorage) SetBucketWebsite(name string, config *WebsiteConfiguration) error {
+	if err := validWebsite(config); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(name)
+	if err != nil {
+		return err
+	}
+	website := *config
+	b.website = &website
+	return nil
+}
+
+func (m *MemStorage) BucketWebsite(name string) (*WebsiteConfiguration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(name)
+	if err != nil {
+		return nil, err
+	}
+	if b.website == nil {
+		return nil, errNoSuchWebsite
+	}
+	website := *b.website
+	return &website, nil
+}
+
+func (m *MemStorage) DeleteBucketWebsite(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(name)
+	if err != nil {
+		return err
+	}
+	b.website = nil
+	return nil
+}
+
+func (m *MemStorage) Put(bucket, key string, body []byte) error {
+	return m.PutObject(context.Background(), bucket, key, body, PutOptions{})
+}
+
+func (m *MemStorage) PutCtx(ctx context.Context, bucket, key string, body []byte) error {
+	return m.PutObject(ctx, bucket, key, body, PutOptions{})
+}
+
+func (m *MemStorage) PutIfAbsentCtx(ctx context.Context, bucket, key string, body []byte) error {
+	return m.PutObject(ctx, bucket, key, body, PutOptions{IfAbsent: true})
+}
+
+func (m *MemStorage) PutObject(ctx context.Context, bucket, key string, body []byte, opts PutOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := validStorageClass(opts.StorageClass); err != nil {
+		return err
+	}
+	objKey, err := m.objectKey(key)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	versions := b.objects[objKey]
+	if opts.IfAbsent && len(versions) > 0 && !versions[0].meta.DeleteMarker {
+		return errObjectExists
+	}
+	if len(versions) > 0 {
+		if err := checkLock(b.versioning, versions[0].meta, opts.BypassGovernance); err != nil {
+			return err
+		}
+	}
+	if b.maxSize > 0 && int64(len(body)) > b.maxSize {
+		return errEntityTooLarge
+	}
+	if err := b.checkObjectCount(objKey); err != nil {
+		return err
+	}
+
+	// an overwrite only accounts for the difference in size, unless
+	// the previous version is retained
+	delta := int64(len(body))
+	if len(versions) > 0 && !retained(b.versioning, versions[0].meta) {
+		delta -= int64(versions[0].meta.ContentSize)
+	}
+	if delta > 0 && b.quota > 0 && b.usedBytes+delta > b.quota {
+		return errQuotaExceeded
+	}
+	b.usedBytes = max(b.usedBytes+delta, 0)
+
+	meta := &metadata{
+		ContentHash: Sha256Hash(body),
+		ContentSize: len(body),
+		ETag:        ETag(body),
+		OriginalKey: key,
+	}
+	meta.setAttributes(opts)
+	meta.setModified(time.Now())
+	b.objects[objKey] = m.nextVersions(b.versioning, versions, &memVersion{
+		meta: meta,
+		body: append([]byte{}, body...),
+	})
+	return nil
+}
+
+// nextVersions returns the versions of an object after v replaced the latest
+// version, which is only kept if it has to be retained.
+func (m *MemStorage) nextVersions(status string, versions []*memVersion, v *memVersion) []*memVersion {
+	switch status {
+	case VersioningEnabled:
+		v.meta.VersionID = newVersionID()
+	case VersioningSuspended:
+		v.meta.VersionID = nullVersion
+	}
+	if len(versions) > 0 && !retained(status, versions[0].meta) {
+		versions = versions[1:]
+	}
+
+	next := []*memVersion{v}
+	for _, old := range versions {
+		// there is only a single null version
+		if status == VersioningSuspended && versionOf(old.meta) == nullVersion {
+			continue
+		}
+		next = append(next, old)
+	}
+	return next
+}
+
+// CheckPut runs every check of Put for a body of the given size without
+// storing anything.
+func (m *MemStorage) CheckPut(bucket, key string, size int) error {
+	objKey, err := m.objectKey(key)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	if b.maxSize > 0 && int64(size) > b.maxSize {
+		return errEntityTooLarge
+	}
+	if err := b.checkObjectCount(objKey); err != nil {
+		return err
+	}
+	delta := int64(size)
+	if versions := b.objects[objKey]; len(versions) > 0 && !retained(b.versioning, versions[0].meta) {
+		delta -= int64(versions[0].meta.ContentSize)
+	}
+	if delta > 0 && b.quota > 0 && b.usedBytes+delta > b.quota {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+func (m *MemStorage) Get(bucket, key string) ([]byte, error) {
+	body, _, err := m.GetVersionCtx(context.Background(), bucket, key, "")
+	return body, err
+}
+
+func (m *MemStorage) GetCtx(ctx context.Context, bucket, key string) ([]byte, error) {
+	body, _, err := m.GetVersionCtx(ctx, bucket, key, "")
+	return body, err
+}
+
+func (m *MemStorage) GetVersion(bucket, key, versionID string) ([]byte, error) {
+	body, _, err := m.GetVersionCtx(context.Background(), bucket, key, versionID)
+	return body, err
+}
+
+func (m *MemStorage) GetVersionCtx(ctx context.Context, bucket, key, versionID string) ([]byte, *ObjectInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, err := m.lookup(bucket, key, versionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append([]byte{}, v.body...), newObjectInfo(v.meta), nil
+}
+
+func (m *MemStorage) Head(bucket, key string) (*ObjectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, err := m.lookup(bucket, key, "")
+	if err != nil {
+		return nil, err
+	}
+	return newObjectInfo(v.meta), nil
+}
+
+// Metadata returns the metadata of a version of an object as JSON, in the
+// format Storage persists it in.
+func (m *MemStorage) Metadata(bucket, key, versionID string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, err := m.lookup(bucket, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v.meta)
+}
+
+// lookup returns an object version which is not a delete marker. It must be
+// called while holding m.mu.
+func (m *MemStorage) lookup(bucket, key, versionID string) (*memVersion, error) {
+	objKey, err := m.objectKey(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	versions := b.objects[objKey]
+	if len(versions) < 1 {
+		return nil, errNoSuchKey
+	}
+
+	if versionID == "" {
+		if versions[0].meta.DeleteMarker {
+			return nil, errNoSuchKey
+		}
+		return versions[0], nil
+	}
+	if err := safePath(versionID); err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if versionOf(v.meta) != versionID {
+			continue
+		}
+		if v.meta.DeleteMarker {
+			return nil, errDeleteMarker
+		}
+		return v, nil
+	}
+	return nil, errNoSuchVersion
+}
+
+func (m *MemStorage) Delete(bucket, key string) error {
+	return m.DeleteCtx(context.Background(), bucket, key)
+}
+
+// DeleteCtx removes an object, or replaces it with a delete marker if the
+// bucket is versioned. Deleting a missing object is not an error.
+func (m *MemStorage) DeleteCtx(ctx context.Context, bucket, key string) error {
+	return m.DeleteObject(ctx, bucket, key, DeleteOptions{})
+}
+
+// DeleteObject is like DeleteCtx but only deletes the object if it meets the
+// conditions of the options.
+func (m *MemStorage) DeleteObject(ctx context.Context, bucket, key string, opts DeleteOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	objKey, err := m.objectKey(key)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	versions := b.objects[objKey]
+	if len(opts.IfMatch) > 0 {
+		if len(versions) < 1 || versions[0].meta.DeleteMarker {
+			return errNoSuchKey
+		}
+		if !versions[0].meta.matches(opts.IfMatch) {
+			return errPreconditionFailed
+		}
+	}
+	if len(versions) < 1 {
+		return nil
+	}
+	if err := checkLock(b.versioning, versions[0].meta, opts.BypassGovernance); err != nil {
+		return err
+	}
+
+	if b.versioning != "" {
+		// the null version of a suspended bucket is replaced by the marker
+		if !retained(b.versioning, versions[0].meta) {
+			b.usedBytes = max(b.usedBytes-int64(versions[0].meta.ContentSize), 0)
+		}
+		marker := &metadata{OriginalKey: key, DeleteMarker: true}
+		marker.setModified(time.Now())
+		b.objects[objKey] = m.nextVersions(b.versioning, versions, &memVersion{meta: marker})
+		return nil
+	}
+	delete(b.objects, objKey)
+	b.usedBytes = max(b.usedBytes-int64(versions[0].meta.ContentSize), 0)
+	return nil
+}
+
+// List returns the objects of a bucket in lexical order of their keys.
+func (m *MemStorage) List(bucket string, opts ListOptions) (*ListResult, error) {
+	m.mu.Lock()
+	b, err := m.bucket(bucket)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	objects := []*ObjectInfo{}
+	for _, versions := range b.objects {
+		if latest := versions[0].meta; !latest.DeleteMarker {
+			objects = append(objects, newObjectInfo(latest))
+		}
+	}
+	m.mu.Unlock()
+	return listPage(objects, opts)
+}
+
+// ListObjectVersions returns all versions of all objects in a bucket, sorted
+// by key and from the newest to the oldest version.
+func (m *MemStorage) ListObjectVersions(bucket string) ([]*ObjectVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(b.objects))
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	versions := []*ObjectVersion{}
+	for _, key := range keys {
+		for i, v := range b.objects[key] {
+			versions = append(versions, newObjectVersion(v.meta, i == 0))
+		}
+	}
+	return versions, nil
+}
+
+func (m *MemStorage) CreateMultipartUpload(bucket, key string, opts PutOptions) (string, error) {
+	if err := validStorageClass(opts.StorageClass); err != nil {
+		return "", err
+	}
+	if _, err := m.objectKey(key); err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return "", err
+	}
+	id := newUploadID()
+	b.uploads[id] = &memUpload{key: key, initiated: time.Now().UTC(), opts: opts, parts: map[int][]byte{}}
+	return id, nil
+}
+
+// upload must be called while holding m.mu.
+func (m *MemStorage) upload(bucket, key, uploadID string) (*memUpload, error) {
+	objKey, err := m.objectKey(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	upload, ok := b.uploads[uploadID]
+	if !ok || m.config.canonicalKey(upload.key) != objKey {
+		return nil, errNoSuchUpload
+	}
+	return upload, nil
+}
+
+func (m *MemStorage) UploadPart(ctx context.Context, bucket, key, uploadID string, part int, body []byte) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if part < 1 || part > maxPartNumber {
+		return "", errInvalidPartNumber
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, err := m.upload(bucket, key, uploadID)
+	if err != nil {
+		return "", err
+	}
+	upload.parts[part] = append([]byte{}, body...)
+	return ETag(body), nil
+}
+
+func (m *MemStorage) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []CompletedPart) (string, error) {
+	if err := checkParts(parts); err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	upload, err := m.upload(bucket, key, uploadID)
+	if err != nil {
+		m.mu.Unlock()
+		return "", err
+	}
+	body := []byte{}
+	datas := [][]byte{}
+	for _, part := range parts {
+		data, ok := upload.parts[part.PartNumber]
+		if !ok || !sameETag(ETag(data), part.ETag) {
+			m.mu.Unlock()
+			return "", errInvalidPart
+		}
+		body = append(body, data...)
+		datas = append(datas, data)
+	}
+	// a second completion of the same upload fails with NoSuchUpload
+	b, _ := m.bucket(bucket)
+	delete(b.uploads, uploadID)
+	m.mu.Unlock()
+
+	opts := upload.opts
+	opts.etag = multipartETag(datas)
+	if err := m.PutObject(ctx, bucket, upload.key, body, opts); err != nil {
+		// the upload can be completed again, e.g. once there is space
+		m.mu.Lock()
+		b.uploads[uploadID] = upload
+		m.mu.Unlock()
+		return "", err
+	}
+	return opts.etag, nil
+}
+
+func (m *MemStorage) AbortMultipartUpload(bucket, key, uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.upload(bucket, key, uploadID); err != nil {
+		return err
+	}
+	b, _ := m.bucket(bucket)
+	delete(b.uploads, uploadID)
+	return nil
+}
+
+// ListMultipartUploads returns the uploads of a bucket in progress sorted by
+// key and initiation.
+func (m *MemStorage) ListMultipartUploads(bucket string) ([]*MultipartUpload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	uploads := []*MultipartUpload{}
+	for id, upload := range b.uploads {
+		uploads = append(uploads, &MultipartUpload{
+			Key:          upload.key,
+			UploadID:     id,
+			Initiated:    upload.initiated,
+			StorageClass: storageClass(upload.opts.StorageClass),
+		})
+	}
+	sortUploads(uploads)
+	return uploads, nil
+}
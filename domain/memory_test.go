@@ -0,0 +1,221 @@
+package domain
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemStorageLifecycle(t *testing.T) {
+	storage := NewMemStorage()
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	body := []byte("hello world!")
+	if err := storage.Put("bucket", "dir/key", body); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := storage.Get("bucket", "dir/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(body) {
+		t.Errorf("got body: '%s', want body: '%s'", got, body)
+	}
+	// the stored body must not change with the slice it was written from
+	body[0] = 'j'
+	if got, _ := storage.Get("bucket", "dir/key"); string(got) != "hello world!" {
+		t.Errorf("got body: '%s', want body: 'hello world!'", got)
+	}
+
+	info, err := storage.Head("bucket", "dir/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 12 || info.ETag != ETag([]byte("hello world!")) {
+		t.Errorf("got info: '%+v', want size: '12' and etag: '%s'", info, ETag([]byte("hello world!")))
+	}
+
+	result, err := storage.List("bucket", ListOptions{Prefix: "dir/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Objects) != 1 || result.Objects[0].Key != "dir/key" {
+		t.Errorf("got objects: '%v', want objects: '[dir/key]'", result.Objects)
+	}
+	count, size, err := storage.BucketStats("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || size != 12 {
+		t.Errorf("got stats: '%d/%d', want stats: '1/12'", count, size)
+	}
+
+	if err := storage.Delete("bucket", "dir/key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Get("bucket", "dir/key"); !hasCode(err, "NoSuchKey") {
+		t.Errorf("got error: '%v', want code: 'NoSuchKey'", err)
+	}
+	if err := storage.Delete("bucket", "dir/key"); err != nil {
+		t.Errorf("got error: '%v', want error: '<nil>'", err)
+	}
+}
+
+// backend is the part of the method set both storages share which the error
+// comparison needs.
+type backend interface {
+	NewBucket(name, owner string) error
+	Put(bucket, key string, body []byte) error
+	PutIfAbsentCtx(ctx context.Context, bucket, key string, body []byte) error
+	GetVersion(bucket, key, versionID string) ([]byte, error)
+	SetBucketQuota(name string, bytes int64) error
+	SetBucketMaxObjectSize(name string, bytes int64) error
+	SetBucketMaxObjects(name string, count int64) error
+	SetBucketVersioning(name, status string) error
+	Delete(bucket, key string) error
+}
+
+// TestMemStorageErrors checks that MemStorage fails with the same error codes
+// as the filesystem storage.
+func TestMemStorageErrors(t *testing.T) {
+	var tests = []struct {
+		name string
+		call func(s backend) error
+		code string
+	}{
+		{"invalid bucket name", func(s backend) error {
+			return s.NewBucket("Bucket", "test-access-key")
+		}, "InvalidBucketName"},
+		{"reserved bucket name", func(s backend) error {
+			return s.NewBucket("version", "test-access-key")
+		}, "InvalidBucketName"},
+		{"bucket owned by you", func(s backend) error {
+			return s.NewBucket("bucket", "test-access-key")
+		}, "BucketAlreadyOwnedByYou"},
+		{"bucket of someone else", func(s backend) error {
+			return s.NewBucket("bucket", "other-access-key")
+		}, "BucketAlreadyExists"},
+		{"missing bucket", func(s backend) error {
+			return s.Put("missing", "key", []byte("hello"))
+		}, "NoSuchBucket"},
+		{"mixed case bucket", func(s backend) error {
+			_, err := s.GetVersion("Bucket", "key", "")
+			return err
+		}, "InvalidBucketName"},
+		{"missing key", func(s backend) error {
+			_, err := s.GetVersion("bucket", "missing", "")
+			return err
+		}, "NoSuchKey"},
+		{"empty key", func(s backend) error {
+			return s.Put("bucket", "", []byte("hello"))
+		}, "InvalidArgument"},
+		{"empty key get", func(s backend) error {
+			_, err := s.GetVersion("bucket", "", "")
+			return err
+		}, "InvalidArgument"},
+		{"empty key delete", func(s backend) error {
+			return s.Delete("bucket", "")
+		}, "InvalidArgument"},
+		{"path traversal", func(s backend) error {
+			return s.Put("bucket", "../key", []byte("hello"))
+		}, "InvalidArgument"},
+		{"existing key", func(s backend) error {
+			return s.PutIfAbsentCtx(context.Background(), "bucket", "key", []byte("hello"))
+		}, "PreconditionFailed"},
+		{"negative quota", func(s backend) error {
+			return s.SetBucketQuota("bucket", -1)
+		}, "InvalidArgument"},
+		{"invalid versioning status", func(s backend) error {
+			return s.SetBucketVersioning("bucket", "Disabled")
+		}, "IllegalVersioningConfigurationException"},
+		{"quota exceeded", func(s backend) error {
+			if err := s.SetBucketQuota("bucket", 10); err != nil {
+				return err
+			}
+			return s.Put("bucket", "other", []byte("hello world!"))
+		}, "QuotaExceeded"},
+		{"entity too large", func(s backend) error {
+			if err := s.SetBucketMaxObjectSize("bucket", 4); err != nil {
+				return err
+			}
+			return s.Put("bucket", "other", []byte("hello"))
+		}, "EntityTooLarge"},
+		{"missing version", func(s backend) error {
+			_, err := s.GetVersion("bucket", "key", "missing")
+			return err
+		}, "NoSuchVersion"},
+		{"delete marker version", func(s backend) error {
+			if err := s.SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+				return err
+			}
+			if err := s.Delete("bucket", "key"); err != nil {
+				return err
+			}
+			_, err := s.GetVersion("bucket", "key", "")
+			return err
+		}, "NoSuchKey"},
+	}
+
+	disk, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range map[string]backend{"filesystem": disk, "memory": NewMemStorage()} {
+		t.Run(name, func(t *testing.T) {
+			if err := s.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Put("bucket", "key", []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			// the steps build on each other, so they run in order
+			for _, test := range tests {
+				if err := test.call(s); !hasCode(err, test.code) {
+					t.Errorf("%s: got error: '%v', want code: '%s'", test.name, err, test.code)
+				}
+			}
+		})
+	}
+}
+
+func hasCode(err error, code string) bool {
+	domErr, ok := err.(*Error)
+	return ok && domErr.Code == code
+}
+
+func TestMemStorageVersioning(t *testing.T) {
+	storage := NewMemStorage()
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"first", "second"} {
+		if err := storage.Put("bucket", "key", []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.Delete("bucket", "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := storage.ListObjectVersions("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("got versions: '%d', want versions: '3'", len(versions))
+	}
+	if !versions[0].IsLatest || !versions[0].DeleteMarker {
+		t.Errorf("got latest version: '%+v', want latest delete marker", versions[0])
+	}
+	got, err := storage.GetVersion("bucket", "key", versions[2].VersionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "first" {
+		t.Errorf("got body: '%s', want body: 'first'", got)
+	}
+}
@@ -0,0 +1,230 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// metadataFormat serializes the metadata of objects. Every format has to be
+// recognizable by its first bytes, so metadata is read no matter which format
+// it was written in.
+type metadataFormat interface {
+	marshal(meta *metadata) ([]byte, error)
+	unmarshal(b []byte, meta *metadata) error
+	detect(b []byte) bool
+}
+
+// metadataFormats are tried in order when reading. JSON comes last, it was
+// the only format before and takes everything the others do not recognize.
+var metadataFormats = []metadataFormat{compactFormat{}, jsonFormat{}}
+
+// WithCompactMetadata writes the metadata of objects in a compact binary
+// layout instead of JSON, which is about half the size. The file keeps its
+// name metadata.json. Metadata of either format is always read, so the
+// option can be switched on and off at any time.
+func WithCompactMetadata() StorageOption {
+	return func(s *Storage) {
+		s.metadataFormat = compactFormat{}
+	}
+}
+
+func decodeMetadata(b []byte) (*metadata, error) {
+	meta := &metadata{}
+	for _, format := range metadataFormats {
+		if format.detect(b) {
+			return meta, format.unmarshal(b, meta)
+		}
+	}
+	return nil, errors.New("unknown metadata format")
+}
+
+type jsonFormat struct{}
+
+func (jsonFormat) marshal(meta *metadata) ([]byte, error) {
+	return json.Marshal(meta)
+}
+
+func (jsonFormat) unmarshal(b []byte, meta *metadata) error {
+	return json.Unmarshal(b, meta)
+}
+
+func (jsonFormat) detect(b []byte) bool {
+	return true
+}
+
+// compactMagic starts the compact layout, JSON never starts with a zero byte.
+// The last byte is the version of the layout, every version appends fields
+// to the previous one.
+var compactMagic = []byte{0, 'b', 'm', compactVersion}
+
+// compactVersion 2 added Blob, 3 added ContentType.
+const compactVersion = 3
+
+// compactFormat writes the fields of the metadata in their order of
+// declaration: strings prefixed with their length, integers as varints, times
+// as presence byte followed by unix seconds and nanoseconds and the booleans
+// as one byte of flags.
+type compactFormat struct{}
+
+const (
+	flagDeleteMarker = 1 << iota
+	flagCompressed
+	flagLegalHold
+)
+
+func (compactFormat) marshal(meta *metadata) ([]byte, error) {
+	w := &compactWriter{b: append([]byte{}, compactMagic...)}
+	w.string(meta.ContentHash)
+	w.int(int64(meta.ContentSize))
+	w.string(meta.ETag)
+	w.string(meta.OriginalKey)
+	w.int(meta.LastModified)
+	w.time(meta.ModifiedAt)
+	w.string(meta.VersionID)
+	w.string(meta.Nonce)
+	w.string(meta.ContentEncoding)
+	w.string(meta.CacheControl)
+	w.string(meta.Expires)
+	w.string(meta.StorageClass)
+	w.string(meta.RetentionMode)
+	w.time(meta.RetainUntil)
+	w.string(meta.Blob)
+	w.string(meta.ContentType)
+
+	var flags byte
+	if meta.DeleteMarker {
+		flags |= flagDeleteMarker
+	}
+	if meta.Compressed {
+		flags |= flagCompressed
+	}
+	if meta.LegalHold {
+		flags |= flagLegalHold
+	}
+	w.b = append(w.b, flags)
+	return w.b, nil
+}
+
+func (compactFormat) unmarshal(b []byte, meta *metadata) error {
+	version := b[len(compactMagic)-1]
+	if version > compactVersion {
+		return fmt.Errorf("unknown version %d of the compact metadata", version)
+	}
+	r := &compactReader{b: b[len(compactMagic):]}
+	meta.ContentHash = r.string()
+	meta.ContentSize = int(r.int())
+	meta.ETag = r.string()
+	meta.OriginalKey = r.string()
+	meta.LastModified = r.int()
+	meta.ModifiedAt = r.time()
+	meta.VersionID = r.string()
+	meta.Nonce = r.string()
+	meta.ContentEncoding = r.string()
+	meta.CacheControl = r.string()
+	meta.Expires = r.string()
+	meta.StorageClass = r.string()
+	meta.RetentionMode = r.string()
+	meta.RetainUntil = r.time()
+	if version >= 2 {
+		meta.Blob = r.string()
+	}
+	if version >= 3 {
+		meta.ContentType = r.string()
+	}
+
+	flags := r.byte()
+	meta.DeleteMarker = flags&flagDeleteMarker != 0
+	meta.Compressed = flags&flagCompressed != 0
+	meta.LegalHold = flags&flagLegalHold != 0
+	if r.err == nil && len(r.b) > 0 {
+		r.err = errors.New("trailing bytes after metadata")
+	}
+	return r.err
+}
+
+func (compactFormat) detect(b []byte) bool {
+	magic := compactMagic[:len(compactMagic)-1]
+	return len(b) > len(magic) && bytes.HasPrefix(b, magic)
+}
+
+type compactWriter struct {
+	b []byte
+}
+
+func (w *compactWriter) string(s string) {
+	w.b = binary.AppendUvarint(w.b, uint64(len(s)))
+	w.b = append(w.b, s...)
+}
+
+func (w *compactWriter) int(n int64) {
+	w.b = binary.AppendVarint(w.b, n)
+}
+
+func (w *compactWriter) time(t time.Time) {
+	if t.IsZero() {
+		w.b = append(w.b, 0)
+		return
+	}
+	w.b = append(w.b, 1)
+	w.int(t.Unix())
+	w.int(int64(t.Nanosecond()))
+}
+
+// compactReader keeps the first error, every read after it returns the zero
+// value.
+type compactReader struct {
+	b   []byte
+	err error
+}
+
+var errTruncatedMetadata = errors.New("metadata is truncated")
+
+func (r *compactReader) byte() byte {
+	if r.err != nil || len(r.b) < 1 {
+		r.fail()
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *compactReader) string() string {
+	n, read := binary.Uvarint(r.b)
+	if r.err != nil || read <= 0 || uint64(len(r.b)-read) < n {
+		r.fail()
+		return ""
+	}
+	s := string(r.b[read : read+int(n)])
+	r.b = r.b[read+int(n):]
+	return s
+}
+
+func (r *compactReader) int() int64 {
+	n, read := binary.Varint(r.b)
+	if r.err != nil || read <= 0 {
+		r.fail()
+		return 0
+	}
+	r.b = r.b[read:]
+	return n
+}
+
+func (r *compactReader) time() time.Time {
+	if r.byte() == 0 {
+		return time.Time{}
+	}
+	sec := r.int()
+	nsec := r.int()
+	return time.Unix(sec, nsec).UTC()
+}
+
+func (r *compactReader) fail() {
+	if r.err == nil {
+		r.err = errTruncatedMetadata
+	}
+}
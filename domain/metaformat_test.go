@@ -0,0 +1,144 @@
+package domain
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// filledMetadata sets every field of the metadata, so a field the compact
+// format forgets fails the round trip.
+func filledMetadata(t *testing.T) *metadata {
+	meta := &metadata{}
+	v := reflect.ValueOf(meta).Elem()
+	for i := range v.NumField() {
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(v.Type().Field(i).Name + " ü")
+		case reflect.Int, reflect.Int64:
+			field.SetInt(int64(1000 + i))
+		case reflect.Bool:
+			field.SetBool(true)
+		default:
+			if field.Type() != reflect.TypeOf(time.Time{}) {
+				t.Fatalf("got field of type: '%s', want a type the test can fill", field.Type())
+			}
+			field.Set(reflect.ValueOf(time.Unix(1718000000+int64(i), 123456789).UTC()))
+		}
+	}
+	return meta
+}
+
+func TestMetadataFormats(t *testing.T) {
+	var tests = []struct {
+		name   string
+		format metadataFormat
+	}{
+		{"json", jsonFormat{}},
+		{"compact", compactFormat{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, want := range []*metadata{filledMetadata(t), {}} {
+				b, err := test.format.marshal(want)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := decodeMetadata(b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("got metadata: '%+v', want metadata: '%+v'", got, want)
+				}
+			}
+		})
+	}
+
+	b, err := compactFormat{}.marshal(filledMetadata(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{len(compactMagic), len(b) / 2, len(b) - 1} {
+		if _, err := decodeMetadata(b[:n]); err == nil {
+			t.Errorf("got error: '<nil>', want error for metadata truncated to %d bytes", n)
+		}
+	}
+	if _, err := decodeMetadata(append(b, 0)); err == nil {
+		t.Error("got error: '<nil>', want error for trailing bytes")
+	}
+
+	// version 1 had no blob and version 2 no content type, which are the
+	// last fields before the flags
+	want := filledMetadata(t)
+	want.Blob = ""
+	want.ContentType = ""
+	b, err = compactFormat{}.marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for version, dropped := range map[byte]int{1: 2, 2: 1} {
+		old := append(append([]byte{}, b[:len(b)-1-dropped]...), b[len(b)-1])
+		old[len(compactMagic)-1] = version
+		if got, err := decodeMetadata(old); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("got metadata: '%+v', error: '%v', want metadata of version %d readable", got, err, version)
+		}
+	}
+	b[len(compactMagic)-1] = compactVersion + 1
+	if _, err := decodeMetadata(b); err == nil {
+		t.Error("got error: '<nil>', want error for unknown version")
+	}
+}
+
+// TestCompactMetadata checks that storages with and without compact metadata
+// read the objects of each other.
+func TestCompactMetadata(t *testing.T) {
+	path := t.TempDir()
+	compact, err := NewStorage(path, WithCompactMetadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := NewStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := compact.Put("bucket", "compact", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.Put("bucket", "plain", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	compactDir, _ := compact.objectDir("bucket", "compact")
+	plainDir, _ := plain.objectDir("bucket", "plain")
+	compactFile, err := os.ReadFile(compactDir + "/metadata.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainFile, err := os.ReadFile(plainDir + "/metadata.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(compactFile, compactMagic) || len(compactFile) >= len(plainFile) {
+		t.Errorf("got compact size: '%d', want compact metadata smaller than '%d'", len(compactFile), len(plainFile))
+	}
+
+	for name, storage := range map[string]*Storage{"compact": compact, "plain": plain} {
+		for _, key := range []string{"compact", "plain"} {
+			info, err := storage.Head("bucket", key)
+			if err != nil {
+				t.Fatalf("%s storage, key %s: %v", name, key, err)
+			}
+			if info.Key != key || info.Size != 5 || info.ContentHash != Sha256Hash([]byte("hello")) {
+				t.Errorf("%s storage: got object: '%+v', want key: '%s'", name, info, key)
+			}
+		}
+	}
+}
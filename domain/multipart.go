@@ -0,0 +1,371 @@
+package domain
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// uploadsDir holds the multipart uploads in progress of a bucket, every upload
+// gets a directory with its manifest and one file per uploaded part. It is
+// hidden from the object listing like the trash.
+const uploadsDir = ".uploads"
+
+// maxPartNumber is the highest part number S3 accepts.
+const maxPartNumber = 10000
+
+var (
+	errNoSuchUpload = &Error{
+		msg:    "the specified multipart upload does not exist",
+		Code:   "NoSuchUpload",
+		Status: http.StatusNotFound,
+	}
+	errInvalidPart = &Error{
+		msg:    "one or more of the specified parts could not be found or its entity tag does not match",
+		Code:   "InvalidPart",
+		Status: http.StatusBadRequest,
+	}
+	errInvalidPartOrder = &Error{
+		msg:    "the list of parts was not in ascending order",
+		Code:   "InvalidPartOrder",
+		Status: http.StatusBadRequest,
+	}
+	errInvalidPartNumber = &Error{
+		msg:    fmt.Sprintf("part number must be an integer between 1 and %d", maxPartNumber),
+		Code:   "InvalidArgument",
+		Status: http.StatusBadRequest,
+	}
+	errNoParts = &Error{
+		msg:    "a multipart upload must be completed with at least one part",
+		Code:   "MalformedXML",
+		Status: http.StatusBadRequest,
+	}
+)
+
+// MultipartUpload is a multipart upload which was neither completed nor
+// aborted yet.
+type MultipartUpload struct {
+	Key          string
+	UploadID     string
+	Initiated    time.Time
+	StorageClass string
+}
+
+// CompletedPart names a part of a multipart upload and the entity tag it was
+// uploaded with.
+type CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// checkParts validates the list of parts an upload is completed with.
+func checkParts(parts []CompletedPart) error {
+	if len(parts) < 1 {
+		return errNoParts
+	}
+	for i, part := range parts {
+		if part.PartNumber < 1 || part.PartNumber > maxPartNumber {
+			return errInvalidPartNumber
+		}
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return errInvalidPartOrder
+		}
+	}
+	return nil
+}
+
+// sameETag compares entity tags with or without their quotes.
+func sameETag(a, b string) bool {
+	return strings.Trim(a, `"`) == strings.Trim(b, `"`)
+}
+
+// multipartETag returns the entity tag S3 gives an object uploaded in parts,
+// the MD5 of the concatenated MD5s of the parts followed by their count.
+func multipartETag(parts [][]byte) string {
+	hash := md5.New()
+	for _, part := range parts {
+		sum := md5.Sum(part)
+		hash.Write(sum[:])
+	}
+	return fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(hash.Sum(nil)), len(parts))
+}
+
+// uploadManifest is stored as upload.json in the directory of an upload.
+type uploadManifest struct {
+	Key             string    `json:"key"`
+	Initiated       time.Time `json:"initiated"`
+	ContentEncoding string    `json:"content_encoding,omitempty"`
+	ContentType     string    `json:"content_type,omitempty"`
+	CacheControl    string    `json:"cache_control,omitempty"`
+	Expires         string    `json:"expires,omitempty"`
+	StorageClass    string    `json:"storage_class,omitempty"`
+}
+
+func (m *uploadManifest) options() PutOptions {
+	return PutOptions{
+		ContentEncoding: m.ContentEncoding,
+		ContentType:     m.ContentType,
+		CacheControl:    m.CacheControl,
+		Expires:         m.Expires,
+		StorageClass:    m.StorageClass,
+	}
+}
+
+// newUploadID returns a random upload ID. Upload IDs become directory names,
+// so only IDs of this form are ever resolved.
+func newUploadID() string {
+	return randomHex(16)
+}
+
+func validUploadID(id string) bool {
+	_, err := hex.DecodeString(id)
+	return err == nil && len(id) == 32
+}
+
+// uploadDir returns the directory of a multipart upload, which does not need
+// to exist.
+func (s *Storage) uploadDir(bucket, uploadID string) (string, error) {
+	dir, err := s.bucketDir(bucket)
+	if err != nil {
+		return "", err
+	}
+	if !validUploadID(uploadID) {
+		return "", errNoSuchUpload
+	}
+	return filepath.Join(dir, uploadsDir, uploadID), nil
+}
+
+func readManifest(dir string) (*uploadManifest, error) {
+	b, err := os.ReadFile(dir + "/upload.json")
+	if err != nil {
+		return nil, fmt.Errorf("could not read upload manifest: %w", err)
+	}
+	manifest := &uploadManifest{}
+	if err := json.Unmarshal(b, manifest); err != nil {
+		return nil, fmt.Errorf("could not unmarshal upload manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// openUpload returns the directory and the manifest of an upload of the key.
+// The caller must hold the lock of the directory.
+func (s *Storage) openUpload(bucket, key, uploadID string) (string, *uploadManifest, error) {
+	if err := s.validKey(key); err != nil {
+		return "", nil, err
+	}
+	dir, err := s.uploadDir(bucket, uploadID)
+	if err != nil {
+		return "", nil, err
+	}
+	manifest, err := readManifest(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil, errNoSuchUpload
+	} else if err != nil {
+		return "", nil, err
+	}
+	if s.canonicalKey(manifest.Key) != s.canonicalKey(key) {
+		return "", nil, errNoSuchUpload
+	}
+	return dir, manifest, nil
+}
+
+func partPath(dir string, part int) string {
+	return filepath.Join(dir, fmt.Sprintf("%05d", part))
+}
+
+// CreateMultipartUpload starts a multipart upload of the key and returns its
+// ID. The attributes of the options are stored with the object once the
+// upload is completed. The upload is persisted, so parts can still be
+// uploaded after a restart.
+func (s *Storage) CreateMultipartUpload(bucket, key string, opts PutOptions) (id string, err error) {
+	defer func() { err = s.unwritable(context.Background(), err) }()
+	if err := validStorageClass(opts.StorageClass); err != nil {
+		return "", err
+	}
+	if _, err := s.objectDir(bucket, key); err != nil {
+		return "", err
+	}
+
+	id = newUploadID()
+	dir, err := s.uploadDir(bucket, id)
+	if err != nil {
+		return "", err
+	}
+	if err := mkdirAll(dir, s.dirMode); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(&uploadManifest{
+		Key:             key,
+		Initiated:       time.Now().UTC(),
+		ContentEncoding: opts.ContentEncoding,
+		ContentType:     opts.ContentType,
+		CacheControl:    opts.CacheControl,
+		Expires:         opts.Expires,
+		StorageClass:    opts.StorageClass,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not marshal upload manifest: %w", err)
+	}
+	if err := writeFileAtomic(dir+"/upload.json", b, s.fileMode); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("could not write upload manifest: %w", err)
+	}
+	return id, nil
+}
+
+// UploadPart stores a part of a multipart upload and returns its entity tag.
+// A part uploaded again under the same number replaces the previous one.
+func (s *Storage) UploadPart(ctx context.Context, bucket, key, uploadID string, part int, body []byte) (etag string, err error) {
+	defer func() { err = s.unwritable(ctx, err) }()
+	if part < 1 || part > maxPartNumber {
+		return "", errInvalidPartNumber
+	}
+	dir, _, err := s.openUpload(bucket, key, uploadID)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := writeTempCtx(ctx, dir, body, s.fileMode)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp)
+
+	// parts are written in parallel, only moving them into place has to wait
+	// for an upload being completed or aborted
+	unlock := s.objects.lock(dir)
+	defer unlock()
+	if _, _, err := s.openUpload(bucket, key, uploadID); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, partPath(dir, part)); err != nil {
+		return "", fmt.Errorf("could not move part into place: %w", err)
+	}
+	return ETag(body), nil
+}
+
+// CompleteMultipartUpload stores the listed parts as a single object under
+// the key of the upload and returns its entity tag. Every part has to match
+// the entity tag it was uploaded with. The parts which are not listed are
+// discarded along with the upload.
+func (s *Storage) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []CompletedPart) (etag string, err error) {
+	defer func() { err = s.unwritable(ctx, err) }()
+	if err := checkParts(parts); err != nil {
+		return "", err
+	}
+	dir, err := s.uploadDir(bucket, uploadID)
+	if err != nil {
+		return "", err
+	}
+	unlock := s.objects.lock(dir)
+	defer unlock()
+	_, manifest, err := s.openUpload(bucket, key, uploadID)
+	if err != nil {
+		return "", err
+	}
+
+	body := []byte{}
+	datas := [][]byte{}
+	for _, part := range parts {
+		data, err := readFileCtx(ctx, partPath(dir, part.PartNumber))
+		if errors.Is(err, os.ErrNotExist) {
+			return "", errInvalidPart
+		} else if err != nil {
+			return "", fmt.Errorf("could not read part: %w", err)
+		}
+		if !sameETag(ETag(data), part.ETag) {
+			return "", errInvalidPart
+		}
+		body = append(body, data...)
+		datas = append(datas, data)
+	}
+	opts := manifest.options()
+	opts.etag = multipartETag(datas)
+	if err := s.PutObject(ctx, bucket, manifest.Key, body, opts); err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Println("[ERROR] - " + logPrefix(ctx) + "could not remove completed upload: " + err.Error())
+	}
+	return opts.etag, nil
+}
+
+// AbortMultipartUpload discards a multipart upload and all of its parts.
+func (s *Storage) AbortMultipartUpload(bucket, key, uploadID string) (err error) {
+	defer func() { err = s.unwritable(context.Background(), err) }()
+	dir, err := s.uploadDir(bucket, uploadID)
+	if err != nil {
+		return err
+	}
+	unlock := s.objects.lock(dir)
+	defer unlock()
+	if _, _, err := s.openUpload(bucket, key, uploadID); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("could not remove upload: %w", err)
+	}
+	return nil
+}
+
+// ListMultipartUploads returns the uploads of a bucket in progress sorted by
+// key and initiation. They are read from disk, so uploads started before a
+// restart are listed as well.
+func (s *Storage) ListMultipartUploads(bucket string) ([]*MultipartUpload, error) {
+	bucketDir, err := s.bucketDir(bucket)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(bucketDir, uploadsDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read uploads directory: %w", err)
+	}
+
+	uploads := []*MultipartUpload{}
+	for _, entry := range entries {
+		if !entry.IsDir() || !validUploadID(entry.Name()) {
+			continue
+		}
+		manifest, err := readManifest(filepath.Join(bucketDir, uploadsDir, entry.Name()))
+		if errors.Is(err, os.ErrNotExist) {
+			// completed or aborted meanwhile
+			continue
+		} else if err != nil {
+			log.Printf("[ERROR] - could not read upload '%s' of bucket '%s': %s", entry.Name(), bucket, err)
+			continue
+		}
+		uploads = append(uploads, &MultipartUpload{
+			Key:          manifest.Key,
+			UploadID:     entry.Name(),
+			Initiated:    manifest.Initiated,
+			StorageClass: storageClass(manifest.StorageClass),
+		})
+	}
+	sortUploads(uploads)
+	return uploads, nil
+}
+
+func storageClass(class string) string {
+	if len(class) < 1 {
+		return StorageClassStandard
+	}
+	return class
+}
+
+func sortUploads(uploads []*MultipartUpload) {
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Key != uploads[j].Key {
+			return uploads[i].Key < uploads[j].Key
+		}
+		return uploads[i].Initiated.Before(uploads[j].Initiated)
+	})
+}
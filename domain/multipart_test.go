@@ -0,0 +1,143 @@
+package domain
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMultipartUploadRestart(t *testing.T) {
+	path := t.TempDir()
+	storage, err := NewStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	id, err := storage.CreateMultipartUpload("bucket", "key", PutOptions{CacheControl: "no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := storage.UploadPart(context.Background(), "bucket", "key", id, 1, []byte("hello "))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the upload continues on a new instance as after a restart
+	restarted, err := NewStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploads, err := restarted.ListMultipartUploads("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 1 || uploads[0].UploadID != id || uploads[0].Key != "key" {
+		t.Fatalf("got uploads: '%v', want upload: '%s'", uploads, id)
+	}
+	second, err := restarted.UploadPart(context.Background(), "bucket", "key", id, 2, []byte("world!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := []CompletedPart{{PartNumber: 1, ETag: first}, {PartNumber: 2, ETag: second}}
+	etag, err := restarted.CompleteMultipartUpload(context.Background(), "bucket", "key", id, parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, info, err := restarted.GetVersionCtx(context.Background(), "bucket", "key", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "hello world!" || info.ETag != etag || info.CacheControl != "no-cache" {
+		t.Errorf("got body: '%s', info: '%+v', want body: 'hello world!'", body, info)
+	}
+	if uploads, _ := restarted.ListMultipartUploads("bucket"); len(uploads) != 0 {
+		t.Errorf("got uploads: '%v', want no uploads", uploads)
+	}
+	// the upload directory is hidden from the objects of the bucket
+	if count, _, err := restarted.BucketStats("bucket"); err != nil || count != 1 {
+		t.Errorf("got count: '%d', error: '%v', want count: '1'", count, err)
+	}
+}
+
+func TestMultipartUploadErrors(t *testing.T) {
+	var tests = []struct {
+		name string
+		call func(s multipartBackend, id, etag string) error
+		code string
+	}{
+		{"unknown upload", func(s multipartBackend, id, etag string) error {
+			_, err := s.UploadPart(context.Background(), "bucket", "key", "0123456789abcdef0123456789abcdef", 1, []byte("a"))
+			return err
+		}, "NoSuchUpload"},
+		{"malformed upload id", func(s multipartBackend, id, etag string) error {
+			_, err := s.UploadPart(context.Background(), "bucket", "key", "../../other", 1, []byte("a"))
+			return err
+		}, "NoSuchUpload"},
+		{"other key", func(s multipartBackend, id, etag string) error {
+			_, err := s.UploadPart(context.Background(), "bucket", "other", id, 1, []byte("a"))
+			return err
+		}, "NoSuchUpload"},
+		{"part number too high", func(s multipartBackend, id, etag string) error {
+			_, err := s.UploadPart(context.Background(), "bucket", "key", id, 10001, []byte("a"))
+			return err
+		}, "InvalidArgument"},
+		{"no parts", func(s multipartBackend, id, etag string) error {
+			_, err := s.CompleteMultipartUpload(context.Background(), "bucket", "key", id, nil)
+			return err
+		}, "MalformedXML"},
+		{"missing part", func(s multipartBackend, id, etag string) error {
+			_, err := s.CompleteMultipartUpload(context.Background(), "bucket", "key", id, []CompletedPart{{PartNumber: 2, ETag: etag}})
+			return err
+		}, "InvalidPart"},
+		{"wrong etag", func(s multipartBackend, id, etag string) error {
+			_, err := s.CompleteMultipartUpload(context.Background(), "bucket", "key", id, []CompletedPart{{PartNumber: 1, ETag: `"abc"`}})
+			return err
+		}, "InvalidPart"},
+		{"unordered parts", func(s multipartBackend, id, etag string) error {
+			parts := []CompletedPart{{PartNumber: 1, ETag: etag}, {PartNumber: 1, ETag: etag}}
+			_, err := s.CompleteMultipartUpload(context.Background(), "bucket", "key", id, parts)
+			return err
+		}, "InvalidPartOrder"},
+		{"completed upload", func(s multipartBackend, id, etag string) error {
+			if _, err := s.CompleteMultipartUpload(context.Background(), "bucket", "key", id, []CompletedPart{{PartNumber: 1, ETag: etag}}); err != nil {
+				return err
+			}
+			return s.AbortMultipartUpload("bucket", "key", id)
+		}, "NoSuchUpload"},
+	}
+
+	disk, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range map[string]multipartBackend{"filesystem": disk, "memory": NewMemStorage()} {
+		if err := s.NewBucket("bucket", "test-access-key"); err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range tests {
+			id, err := s.CreateMultipartUpload("bucket", "key", PutOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			etag, err := s.UploadPart(context.Background(), "bucket", "key", id, 1, []byte("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := test.call(s, id, etag); !hasCode(err, test.code) {
+				t.Errorf("%s %s: got error: '%v', want code: '%s'", name, test.name, err, test.code)
+			}
+		}
+	}
+}
+
+// multipartBackend is the part of the method set both storages share which
+// the multipart tests need.
+type multipartBackend interface {
+	NewBucket(name, owner string) error
+	CreateMultipartUpload(bucket, key string, opts PutOptions) (string, error)
+	UploadPart(ctx context.Context, bucket, key, uploadID string, part int, body []byte) (string, error)
+	CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []CompletedPart) (string, error)
+	AbortMultipartUpload(bucket, key, uploadID string) error
+}
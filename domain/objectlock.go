@@ -0,0 +1,189 @@
+package domain
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// retention modes of object lock
+const (
+	// RetentionGovernance can be shortened or lifted by bypassing it
+	RetentionGovernance = "GOVERNANCE"
+	// RetentionCompliance can only be extended until it expires
+	RetentionCompliance = "COMPLIANCE"
+)
+
+// ObjectLock protects an object version from being deleted or overwritten,
+// either until a date (retention) or until the legal hold is removed.
+type ObjectLock struct {
+	// Mode is RetentionGovernance or RetentionCompliance, empty without
+	// retention
+	Mode        string
+	RetainUntil time.Time
+	LegalHold   bool
+}
+
+var errObjectLocked = &Error{
+	msg:    "the object is protected by object lock",
+	Code:   "AccessDenied",
+	Status: http.StatusForbidden,
+}
+
+func (m *metadata) lock() ObjectLock {
+	return ObjectLock{Mode: m.RetentionMode, RetainUntil: m.RetainUntil, LegalHold: m.LegalHold}
+}
+
+// protected reports whether the version may not be deleted or overwritten at
+// the given time. Only governance retention is lifted by bypass.
+func (l ObjectLock) protected(now time.Time, bypass bool) bool {
+	if l.LegalHold {
+		return true
+	}
+	if len(l.Mode) < 1 || !now.Before(l.RetainUntil) {
+		return false
+	}
+	return l.Mode == RetentionCompliance || !bypass
+}
+
+// checkLock fails with AccessDenied if replacing the current version of an
+// object would lose a version which is locked. Versions which are retained
+// by versioning stay protected and are not lost.
+func checkLock(status string, current *metadata, bypass bool) error {
+	if current == nil || retained(status, current) {
+		return nil
+	}
+	if current.lock().protected(time.Now(), bypass) {
+		return errObjectLocked
+	}
+	return nil
+}
+
+// setRetention replaces the retention of a version. An active retention can
+// always be extended, shortening or removing it is only possible for
+// governance retention with bypass.
+func (m *metadata) setRetention(next ObjectLock, bypass bool, now time.Time) error {
+	switch next.Mode {
+	case "":
+		if !next.RetainUntil.IsZero() {
+			return &Error{
+				msg:    "retention date requires a retention mode",
+				Code:   "MalformedXML",
+				Status: http.StatusBadRequest,
+			}
+		}
+	case RetentionGovernance, RetentionCompliance:
+		if !next.RetainUntil.After(now) {
+			return &Error{
+				msg:    "retention date must be in the future",
+				Code:   "InvalidArgument",
+				Status: http.StatusBadRequest,
+			}
+		}
+	default:
+		return &Error{
+			msg:    "retention mode must be either GOVERNANCE or COMPLIANCE",
+			Code:   "MalformedXML",
+			Status: http.StatusBadRequest,
+		}
+	}
+
+	current := m.lock()
+	active := len(current.Mode) > 0 && now.Before(current.RetainUntil)
+	weaker := len(next.Mode) < 1 || next.RetainUntil.Before(current.RetainUntil) ||
+		(current.Mode == RetentionCompliance && next.Mode != RetentionCompliance)
+	if active && weaker && (current.Mode == RetentionCompliance || !bypass) {
+		return errObjectLocked
+	}
+	m.RetentionMode = next.Mode
+	m.RetainUntil = next.RetainUntil.UTC()
+	return nil
+}
+
+// SetObjectRetention replaces the retention of an object version, the latest
+// one if versionID is empty. Governance retention is only shortened or
+// removed with bypassGovernance.
+func (s *Storage) SetObjectRetention(bucket, key, versionID string, retention ObjectLock, bypassGovernance bool) error {
+	return s.updateMetadata(bucket, key, versionID, func(meta *metadata) error {
+		return meta.setRetention(retention, bypassGovernance, time.Now())
+	})
+}
+
+// SetObjectLegalHold places or removes the legal hold of an object version,
+// the latest one if versionID is empty.
+func (s *Storage) SetObjectLegalHold(bucket, key, versionID string, on bool) error {
+	return s.updateMetadata(bucket, key, versionID, func(meta *metadata) error {
+		meta.LegalHold = on
+		return nil
+	})
+}
+
+// ObjectLock returns the lock of an object version, the latest one if
+// versionID is empty.
+func (s *Storage) ObjectLock(bucket, key, versionID string) (*ObjectLock, error) {
+	_, meta, err := s.lookup(context.Background(), bucket, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	lock := meta.lock()
+	return &lock, nil
+}
+
+// updateMetadata changes the metadata of an object version in place.
+func (s *Storage) updateMetadata(bucket, key, versionID string, update func(meta *metadata) error) (err error) {
+	defer func() { err = s.unwritable(context.Background(), err) }()
+	dir, err := s.objectDir(bucket, key)
+	if err != nil {
+		return err
+	}
+	unlock := s.objects.lock(dir)
+	defer unlock()
+
+	path, meta, err := s.lookup(context.Background(), bucket, key, versionID)
+	if err != nil {
+		return err
+	}
+	if err := update(meta); err != nil {
+		return err
+	}
+	return s.writeMetadata(path, meta)
+}
+
+// SetObjectRetention replaces the retention of an object version, the latest
+// one if versionID is empty. Governance retention is only shortened or
+// removed with bypassGovernance.
+func (m *MemStorage) SetObjectRetention(bucket, key, versionID string, retention ObjectLock, bypassGovernance bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, err := m.lookup(bucket, key, versionID)
+	if err != nil {
+		return err
+	}
+	return v.meta.setRetention(retention, bypassGovernance, time.Now())
+}
+
+// SetObjectLegalHold places or removes the legal hold of an object version,
+// the latest one if versionID is empty.
+func (m *MemStorage) SetObjectLegalHold(bucket, key, versionID string, on bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, err := m.lookup(bucket, key, versionID)
+	if err != nil {
+		return err
+	}
+	v.meta.LegalHold = on
+	return nil
+}
+
+// ObjectLock returns the lock of an object version, the latest one if
+// versionID is empty.
+func (m *MemStorage) ObjectLock(bucket, key, versionID string) (*ObjectLock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, err := m.lookup(bucket, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	lock := v.meta.lock()
+	return &lock, nil
+}
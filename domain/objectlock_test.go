@@ -0,0 +1,187 @@
+package domain
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// lockBackend is the part of the method set both storages share which the
+// object lock tests need.
+type lockBackend interface {
+	backend
+	DeleteObject(ctx context.Context, bucket, key string, opts DeleteOptions) error
+	PutObject(ctx context.Context, bucket, key string, body []byte, opts PutOptions) error
+	Rename(bucket, srcKey, dstKey string) error
+	SetObjectRetention(bucket, key, versionID string, retention ObjectLock, bypassGovernance bool) error
+	SetObjectLegalHold(bucket, key, versionID string, on bool) error
+	ObjectLock(bucket, key, versionID string) (*ObjectLock, error)
+}
+
+func TestObjectLock(t *testing.T) {
+	ctx := context.Background()
+	later := time.Now().Add(time.Hour)
+	var tests = []struct {
+		name string
+		call func(s lockBackend) error
+		code string
+	}{
+		{"invalid mode", func(s lockBackend) error {
+			return s.SetObjectRetention("bucket", "governed", "", ObjectLock{Mode: "FOREVER", RetainUntil: later}, false)
+		}, "MalformedXML"},
+		{"date in the past", func(s lockBackend) error {
+			return s.SetObjectRetention("bucket", "governed", "", ObjectLock{Mode: RetentionGovernance, RetainUntil: time.Now().Add(-time.Hour)}, false)
+		}, "InvalidArgument"},
+		{"governance", func(s lockBackend) error {
+			return s.SetObjectRetention("bucket", "governed", "", ObjectLock{Mode: RetentionGovernance, RetainUntil: later}, false)
+		}, ""},
+		{"delete governed", func(s lockBackend) error {
+			return s.Delete("bucket", "governed")
+		}, "AccessDenied"},
+		{"overwrite governed", func(s lockBackend) error {
+			return s.Put("bucket", "governed", []byte("other"))
+		}, "AccessDenied"},
+		{"rename governed", func(s lockBackend) error {
+			return s.Rename("bucket", "governed", "other")
+		}, "AccessDenied"},
+		{"rename onto governed", func(s lockBackend) error {
+			return s.Rename("bucket", "free", "governed")
+		}, "AccessDenied"},
+		{"shorten governance", func(s lockBackend) error {
+			return s.SetObjectRetention("bucket", "governed", "", ObjectLock{Mode: RetentionGovernance, RetainUntil: later.Add(-time.Minute)}, false)
+		}, "AccessDenied"},
+		{"shorten governance with bypass", func(s lockBackend) error {
+			return s.SetObjectRetention("bucket", "governed", "", ObjectLock{Mode: RetentionGovernance, RetainUntil: later.Add(-time.Minute)}, true)
+		}, ""},
+		{"overwrite governed with bypass", func(s lockBackend) error {
+			return s.PutObject(ctx, "bucket", "governed", []byte("other"), PutOptions{BypassGovernance: true})
+		}, ""},
+		{"compliance", func(s lockBackend) error {
+			return s.SetObjectRetention("bucket", "complied", "", ObjectLock{Mode: RetentionCompliance, RetainUntil: later}, false)
+		}, ""},
+		{"delete complied with bypass", func(s lockBackend) error {
+			return s.DeleteObject(ctx, "bucket", "complied", DeleteOptions{BypassGovernance: true})
+		}, "AccessDenied"},
+		{"shorten compliance with bypass", func(s lockBackend) error {
+			return s.SetObjectRetention("bucket", "complied", "", ObjectLock{Mode: RetentionCompliance, RetainUntil: later.Add(-time.Minute)}, true)
+		}, "AccessDenied"},
+		{"compliance to governance", func(s lockBackend) error {
+			return s.SetObjectRetention("bucket", "complied", "", ObjectLock{Mode: RetentionGovernance, RetainUntil: later}, true)
+		}, "AccessDenied"},
+		{"remove compliance", func(s lockBackend) error {
+			return s.SetObjectRetention("bucket", "complied", "", ObjectLock{}, true)
+		}, "AccessDenied"},
+		{"extend compliance", func(s lockBackend) error {
+			return s.SetObjectRetention("bucket", "complied", "", ObjectLock{Mode: RetentionCompliance, RetainUntil: later.Add(time.Hour)}, false)
+		}, ""},
+		{"legal hold", func(s lockBackend) error {
+			return s.SetObjectLegalHold("bucket", "free", "", true)
+		}, ""},
+		{"delete held with bypass", func(s lockBackend) error {
+			return s.DeleteObject(ctx, "bucket", "free", DeleteOptions{BypassGovernance: true})
+		}, "AccessDenied"},
+		{"release legal hold", func(s lockBackend) error {
+			return s.SetObjectLegalHold("bucket", "free", "", false)
+		}, ""},
+		{"delete released", func(s lockBackend) error {
+			return s.Delete("bucket", "free")
+		}, ""},
+		{"lock of missing key", func(s lockBackend) error {
+			return s.SetObjectLegalHold("bucket", "free", "", true)
+		}, "NoSuchKey"},
+	}
+
+	disk, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range map[string]lockBackend{"filesystem": disk, "memory": NewMemStorage()} {
+		t.Run(name, func(t *testing.T) {
+			if err := s.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range []string{"governed", "complied", "free"} {
+				if err := s.Put("bucket", key, []byte("hello")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// the steps build on each other, so they run in order
+			for _, test := range tests {
+				err := test.call(s)
+				if test.code == "" && err != nil {
+					t.Errorf("%s: got error: '%v', want error: '<nil>'", test.name, err)
+				} else if test.code != "" && !hasCode(err, test.code) {
+					t.Errorf("%s: got error: '%v', want code: '%s'", test.name, err, test.code)
+				}
+			}
+
+			lock, err := s.ObjectLock("bucket", "complied", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lock.Mode != RetentionCompliance || !lock.RetainUntil.Equal(later.Add(time.Hour).UTC()) {
+				t.Errorf("got lock: '%+v', want mode: '%s'", lock, RetentionCompliance)
+			}
+		})
+	}
+}
+
+func TestObjectLockExpires(t *testing.T) {
+	disk, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range map[string]lockBackend{"filesystem": disk, "memory": NewMemStorage()} {
+		t.Run(name, func(t *testing.T) {
+			if err := s.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Put("bucket", "key", []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			until := time.Now().Add(100 * time.Millisecond)
+			if err := s.SetObjectRetention("bucket", "key", "", ObjectLock{Mode: RetentionCompliance, RetainUntil: until}, false); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Delete("bucket", "key"); !hasCode(err, "AccessDenied") {
+				t.Fatalf("got error: '%v', want code: 'AccessDenied'", err)
+			}
+
+			time.Sleep(time.Until(until))
+			if err := s.Delete("bucket", "key"); err != nil {
+				t.Errorf("got error: '%v', want error: '<nil>'", err)
+			}
+		})
+	}
+}
+
+// TestObjectLockVersioning checks that a locked version does not block
+// deletes which keep it as a noncurrent version.
+func TestObjectLockVersioning(t *testing.T) {
+	disk, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range map[string]lockBackend{"filesystem": disk, "memory": NewMemStorage()} {
+		t.Run(name, func(t *testing.T) {
+			if err := s.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Put("bucket", "key", []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SetObjectLegalHold("bucket", "key", "", true); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Put("bucket", "key", []byte("world")); err != nil {
+				t.Errorf("got error: '%v', want error: '<nil>'", err)
+			}
+			if err := s.Delete("bucket", "key"); err != nil {
+				t.Errorf("got error: '%v', want error: '<nil>'", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package domain
+
+import "net/http"
+
+// objectDeleter is implemented by both storages, DeletePrefix only needs to
+// list and delete the objects.
+type objectDeleter interface {
+	List(bucket string, opts ListOptions) (*ListResult, error)
+	Delete(bucket, key string) error
+}
+
+// DeletePrefix deletes all objects whose key starts with the prefix and
+// returns how many were deleted. Every object is deleted like with Delete,
+// so versioning and the trash apply.
+func (s *Storage) DeletePrefix(bucket, prefix string) (int, error) {
+	return deletePrefix(s, bucket, prefix)
+}
+
+// DeletePrefix deletes all objects whose key starts with the prefix and
+// returns how many were deleted.
+func (m *MemStorage) DeletePrefix(bucket, prefix string) (int, error) {
+	return deletePrefix(m, bucket, prefix)
+}
+
+func deletePrefix(s objectDeleter, bucket, prefix string) (int, error) {
+	// an empty prefix would empty the whole bucket by accident
+	if len(prefix) < 1 {
+		return 0, &Error{
+			msg:    "prefix to delete must not be empty",
+			Code:   "InvalidArgument",
+			Status: http.StatusBadRequest,
+		}
+	}
+
+	deleted := 0
+	opts := ListOptions{Prefix: prefix}
+	for {
+		page, err := s.List(bucket, opts)
+		if err != nil {
+			return deleted, err
+		}
+		for _, obj := range page.Objects {
+			if err := s.Delete(bucket, obj.Key); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+		if !page.IsTruncated {
+			return deleted, nil
+		}
+		opts.ContinuationToken = page.NextContinuationToken
+	}
+}
@@ -0,0 +1,63 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDeletePrefix(t *testing.T) {
+	var tests = []struct {
+		name    string
+		prefix  string
+		deleted int
+		left    []string
+	}{
+		{"directory", "logs/", 3, []string{"logsearch", "other/logs/a"}},
+		{"partial name", "logs", 4, []string{"other/logs/a"}},
+		{"single object", "other/logs/a", 1, []string{"logs/2024/01", "logs/2024/02", "logs/a", "logsearch"}},
+		{"no match", "missing/", 0, []string{"logs/2024/01", "logs/2024/02", "logs/a", "logsearch", "other/logs/a"}},
+	}
+
+	keys := []string{"logs/2024/01", "logs/2024/02", "logs/a", "logsearch", "other/logs/a"}
+	for _, test := range tests {
+		disk, err := NewStorage(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, s := range map[string]interface {
+			backend
+			List(bucket string, opts ListOptions) (*ListResult, error)
+			DeletePrefix(bucket, prefix string) (int, error)
+		}{"filesystem": disk, "memory": NewMemStorage()} {
+			t.Run(test.name+"/"+name, func(t *testing.T) {
+				if err := s.NewBucket("bucket", "test-access-key"); err != nil {
+					t.Fatal(err)
+				}
+				for _, key := range keys {
+					if err := s.Put("bucket", key, []byte(key)); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				deleted, err := s.DeletePrefix("bucket", test.prefix)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if deleted != test.deleted {
+					t.Errorf("got deleted: '%d', want deleted: '%d'", deleted, test.deleted)
+				}
+				result, err := s.List("bucket", ListOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				left := []string{}
+				for _, obj := range result.Objects {
+					left = append(left, obj.Key)
+				}
+				if strings.Join(left, ",") != strings.Join(test.left, ",") {
+					t.Errorf("got keys: '%v', want keys: '%v'", left, test.left)
+				}
+			})
+		}
+	}
+}
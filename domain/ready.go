@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"fmt"
+	"os"
+)
+
+// WithPendingPath lets NewStorage succeed while the path does not exist yet,
+// e.g. because the volume it lives on is still being attached. Until Ready
+// reports the path as usable every operation fails.
+func WithPendingPath() StorageOption {
+	return func(s *Storage) {
+		s.pendingPath = true
+	}
+}
+
+func checkDir(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("path '%s' does not exist", path)
+	} else if err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("path '%s' is not a directory", path)
+	}
+	return nil
+}
+
+// Ready reports whether the path of the storage exists and is writable.
+func (s *Storage) Ready() error {
+	if err := checkDir(s.path); err != nil {
+		return err
+	}
+	probe, err := os.CreateTemp(s.path, ".ready-*")
+	if err != nil {
+		return fmt.Errorf("path '%s' is not writable: %w", s.path, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// Ready always succeeds, the memory is there from the start.
+func (m *MemStorage) Ready() error {
+	return nil
+}
@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPendingPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	if _, err := NewStorage(path); err == nil {
+		t.Fatal("got error: '<nil>', want error for missing path")
+	}
+
+	storage, err := NewStorage(path, WithPendingPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Ready(); err == nil {
+		t.Error("got error: '<nil>', want error for missing path")
+	}
+
+	// the volume gets attached
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Ready(); err != nil {
+		t.Fatalf("got error: '%v', want error: '<nil>'", err)
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got entries: '%d', want entries: '0'", len(entries))
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Errorf("got error: '%v', want error: '<nil>'", err)
+	}
+}
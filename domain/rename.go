@@ -0,0 +1,157 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+var errRenameVersioned = &Error{
+	msg:    "objects of a bucket with versioning can not be renamed",
+	Code:   "InvalidRequest",
+	Status: http.StatusBadRequest,
+}
+
+// Rename moves an object to another key of the same bucket. An object which
+// already exists under the destination key is overwritten. Objects of buckets
+// which ever had versioning enabled can not be renamed.
+func (s *Storage) Rename(bucket, srcKey, dstKey string) error {
+	return s.rename(bucket, srcKey, dstKey, false)
+}
+
+// RenameIfAbsent is like Rename but fails with PreconditionFailed if an
+// object exists under the destination key.
+func (s *Storage) RenameIfAbsent(bucket, srcKey, dstKey string) error {
+	return s.rename(bucket, srcKey, dstKey, true)
+}
+
+func (s *Storage) rename(bucket, srcKey, dstKey string, ifAbsent bool) (err error) {
+	defer func() { err = s.unwritable(context.Background(), err) }()
+	src, err := s.objectDir(bucket, srcKey)
+	if err != nil {
+		return err
+	}
+	dst, err := s.objectDir(bucket, dstKey)
+	if err != nil {
+		return err
+	}
+	status, err := s.versioning(bucket)
+	if err != nil {
+		return err
+	}
+	if status != "" {
+		return errRenameVersioned
+	}
+
+	// both objects are locked in the same order, so two renames in opposite
+	// directions can not deadlock
+	first, second := src, dst
+	if second < first {
+		first, second = second, first
+	}
+	unlock := s.objects.lock(first)
+	defer unlock()
+	if second != first {
+		unlock := s.objects.lock(second)
+		defer unlock()
+	}
+
+	_, meta, err := s.lookup(context.Background(), bucket, srcKey, "")
+	if err != nil {
+		return err
+	}
+	// a rename removes the source key, and the destination if it exists
+	if err := checkLock("", meta, false); err != nil {
+		return err
+	}
+	// keys which only differ in case or normalization share their directory
+	// with WithCaseInsensitiveKeys or WithNFCKeys, then only the original key
+	// changes
+	if src != dst && exists(dst) {
+		if ifAbsent {
+			return errObjectExists
+		}
+		if old, err := readMetadata(dst); err == nil {
+			if err := checkLock("", old, false); err != nil {
+				return err
+			}
+		}
+		if err := s.removeObject(context.Background(), bucket, dst); err != nil {
+			return err
+		}
+	}
+
+	meta.OriginalKey = dstKey
+	if err := s.writeMetadata(src, meta); err != nil {
+		return err
+	}
+	if src == dst {
+		return nil
+	}
+	if err := mkdirAll(filepath.Dir(dst), s.dirMode); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		meta.OriginalKey = srcKey
+		s.writeMetadata(src, meta)
+		return fmt.Errorf("could not move object directory: %w", err)
+	}
+	return nil
+}
+
+// Rename moves an object to another key of the same bucket, overwriting the
+// object under the destination key.
+func (m *MemStorage) Rename(bucket, srcKey, dstKey string) error {
+	return m.rename(bucket, srcKey, dstKey, false)
+}
+
+// RenameIfAbsent is like Rename but fails with PreconditionFailed if an
+// object exists under the destination key.
+func (m *MemStorage) RenameIfAbsent(bucket, srcKey, dstKey string) error {
+	return m.rename(bucket, srcKey, dstKey, true)
+}
+
+func (m *MemStorage) rename(bucket, srcKey, dstKey string, ifAbsent bool) error {
+	src, err := m.objectKey(srcKey)
+	if err != nil {
+		return err
+	}
+	dst, err := m.objectKey(dstKey)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	if b.versioning != "" {
+		return errRenameVersioned
+	}
+	v, err := m.lookup(bucket, srcKey, "")
+	if err != nil {
+		return err
+	}
+	if err := checkLock("", v.meta, false); err != nil {
+		return err
+	}
+	if old := b.objects[dst]; src != dst && len(old) > 0 {
+		if ifAbsent {
+			return errObjectExists
+		}
+		if err := checkLock("", old[0].meta, false); err != nil {
+			return err
+		}
+		b.usedBytes = max(b.usedBytes-int64(old[0].meta.ContentSize), 0)
+	}
+
+	meta := *v.meta
+	meta.OriginalKey = dstKey
+	delete(b.objects, src)
+	b.objects[dst] = []*memVersion{{meta: &meta, body: v.body}}
+	return nil
+}
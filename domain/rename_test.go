@@ -0,0 +1,105 @@
+package domain
+
+import (
+	"testing"
+)
+
+// renamer is implemented by both storages.
+type renamer interface {
+	backend
+	Rename(bucket, srcKey, dstKey string) error
+	RenameIfAbsent(bucket, srcKey, dstKey string) error
+	BucketStats(name string) (int, int64, error)
+}
+
+func TestRename(t *testing.T) {
+	var tests = []struct {
+		name     string
+		src      string
+		dst      string
+		ifAbsent bool
+		code     string
+		want     map[string]string // bodies by key after the rename
+	}{
+		{"rename", "a", "c", false, "", map[string]string{"b": "bb", "c": "a"}},
+		{"rename into directory", "a", "dir/a", false, "", map[string]string{"b": "bb", "dir/a": "a"}},
+		{"rename over existing", "a", "b", false, "", map[string]string{"b": "a"}},
+		{"rename over existing if absent", "a", "b", true, "PreconditionFailed", map[string]string{"a": "a", "b": "bb"}},
+		{"rename onto itself", "a", "a", false, "", map[string]string{"a": "a", "b": "bb"}},
+		{"rename missing key", "missing", "c", false, "NoSuchKey", map[string]string{"a": "a", "b": "bb"}},
+	}
+
+	for _, test := range tests {
+		disk, err := NewStorage(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, s := range map[string]renamer{"filesystem": disk, "memory": NewMemStorage()} {
+			t.Run(test.name+"/"+name, func(t *testing.T) {
+				if err := s.NewBucket("bucket", "test-access-key"); err != nil {
+					t.Fatal(err)
+				}
+				for key, body := range map[string]string{"a": "a", "b": "bb"} {
+					if err := s.Put("bucket", key, []byte(body)); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				if test.ifAbsent {
+					err = s.RenameIfAbsent("bucket", test.src, test.dst)
+				} else {
+					err = s.Rename("bucket", test.src, test.dst)
+				}
+				if len(test.code) < 1 && err != nil {
+					t.Fatalf("got error: '%v', want error: 'nil'", err)
+				} else if len(test.code) > 0 && !hasCode(err, test.code) {
+					t.Fatalf("got error: '%v', want code: '%s'", err, test.code)
+				}
+
+				for key, want := range test.want {
+					got, err := s.GetVersion("bucket", key, "")
+					if err != nil {
+						t.Errorf("got error: '%v' for key: '%s', want body: '%s'", err, key, want)
+					} else if string(got) != want {
+						t.Errorf("got body: '%s' for key: '%s', want body: '%s'", got, key, want)
+					}
+				}
+				if test.src != test.dst && len(test.code) < 1 {
+					if _, err := s.GetVersion("bucket", test.src, ""); !hasCode(err, "NoSuchKey") {
+						t.Errorf("got error: '%v' for source key, want code: 'NoSuchKey'", err)
+					}
+				}
+				count, _, err := s.BucketStats("bucket")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if count != len(test.want) {
+					t.Errorf("got object count: '%d', want object count: '%d'", count, len(test.want))
+				}
+			})
+		}
+	}
+}
+
+func TestRenameVersioned(t *testing.T) {
+	disk, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range map[string]renamer{"filesystem": disk, "memory": NewMemStorage()} {
+		t.Run(name, func(t *testing.T) {
+			if err := s.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Put("bucket", "a", []byte("a")); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Rename("bucket", "a", "b"); !hasCode(err, "InvalidRequest") {
+				t.Errorf("got error: '%v', want code: 'InvalidRequest'", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package domain
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+var errRepairEncrypted = &Error{
+	msg:    "encrypted objects can not be repaired, the nonce is part of the metadata",
+	Code:   "NotImplemented",
+	Status: http.StatusNotImplemented,
+}
+
+// Repair recomputes the metadata of an object from its body file. It is meant
+// to recover objects whose metadata.json got corrupted.
+func (s *Storage) Repair(bucket, key string) error {
+	dir, err := s.objectDir(bucket, key)
+	if err != nil {
+		return err
+	}
+	if !exists(dir) {
+		return errNoSuchKey
+	}
+	if s.encryption != nil {
+		return errRepairEncrypted
+	}
+	unlock := s.objects.lock(dir)
+	defer unlock()
+	_, err = s.rebuildMetadata(dir, key)
+	return err
+}
+
+// RepairedObject is an object whose metadata was rebuilt from its body. The
+// key is empty if it could not be derived, the directory is the hash the
+// object is stored under.
+type RepairedObject struct {
+	Key       string
+	Directory string
+	Size      int64
+}
+
+// RepairResult summarizes a repair of a bucket. Failed are the directories of
+// objects whose metadata is missing and whose body could not be read either.
+type RepairResult struct {
+	Repaired []RepairedObject
+	Failed   []string
+}
+
+// RepairBucket rebuilds the metadata of every object of a bucket whose
+// metadata.json is missing or can not be parsed, e.g. after a restore which
+// only copied the body files. The key of such an object is only known if an
+// archived version still records it, objects without a key are left out of
+// the listings. The usage and the number of objects of the bucket are counted
+// again afterwards.
+func (s *Storage) RepairBucket(name string) (*RepairResult, error) {
+	bucketDir, err := s.bucketDir(name)
+	if err != nil {
+		return nil, err
+	}
+	if s.encryption != nil {
+		return nil, errRepairEncrypted
+	}
+	dirs, err := objectDirs(bucketDir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RepairResult{Repaired: []RepairedObject{}, Failed: []string{}}
+	for _, dir := range dirs {
+		unlock := s.objects.lock(dir)
+		if _, err := readMetadata(dir); err == nil {
+			unlock()
+			continue
+		}
+		meta, err := s.rebuildMetadata(dir, s.deriveKey(name, dir))
+		unlock()
+		if err != nil {
+			log.Printf("[ERROR] - could not repair object of bucket '%s' at '%s': %s", name, dir, err)
+			result.Failed = append(result.Failed, filepath.Base(dir))
+			continue
+		}
+		result.Repaired = append(result.Repaired, RepairedObject{
+			Key:       meta.OriginalKey,
+			Directory: filepath.Base(dir),
+			Size:      int64(meta.ContentSize),
+		})
+	}
+	if err := s.recount(name, dirs); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// recount replaces the usage and the number of objects of a bucket with
+// those of its object directories. Unlike BucketStats the usage includes the
+// archived versions and objects whose metadata can not be read are skipped.
+func (s *Storage) recount(name string, dirs []string) error {
+	var count, size int64
+	for _, dir := range dirs {
+		current, err := readMetadata(dir)
+		if err != nil {
+			continue
+		}
+		if !current.DeleteMarker {
+			count++
+			size += int64(current.ContentSize)
+		}
+		archived, err := os.ReadDir(filepath.Join(dir, "versions"))
+		if err != nil {
+			continue
+		}
+		for _, v := range archived {
+			if meta, err := readMetadata(filepath.Join(dir, "versions", v.Name())); err == nil {
+				size += int64(meta.ContentSize)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	config, err := s.readBucketConfig(name)
+	if err != nil {
+		return err
+	}
+	config.UsedBytes = size
+	config.ObjectCount = count
+	return s.writeBucketConfig(name, config)
+}
+
+// rebuildMetadata writes the metadata of an object computed from its body
+// file. The caller must hold the lock of the object.
+func (s *Storage) rebuildMetadata(dir, key string) (*metadata, error) {
+	body, err := os.ReadFile(dir + "/body")
+	if err != nil {
+		return nil, fmt.Errorf("could not read data file: %w", err)
+	}
+	// compression may have been enabled or disabled since the object was
+	// stored, so only the marker in the body tells whether it is compressed
+	compressed := false
+	if isCompressed(body) {
+		if plain, err := decompress(body); err == nil {
+			body, compressed = plain, true
+		}
+	}
+	info, err := os.Stat(dir + "/body")
+	if err != nil {
+		return nil, fmt.Errorf("could not stat data file: %w", err)
+	}
+
+	meta := &metadata{
+		ContentHash: Sha256Hash(body),
+		ContentSize: len(body),
+		ETag:        ETag(body),
+		OriginalKey: key,
+		Compressed:  compressed,
+	}
+	meta.setModified(info.ModTime())
+	return meta, s.writeMetadata(dir, meta)
+}
+
+// deriveKey returns the key an archived version of the object recorded, if
+// it still hashes to the directory of the object.
+func (s *Storage) deriveKey(bucket, dir string) string {
+	entries, err := os.ReadDir(filepath.Join(dir, "versions"))
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		meta, err := readMetadata(filepath.Join(dir, "versions", entry.Name()))
+		if err != nil || len(meta.OriginalKey) < 1 {
+			continue
+		}
+		if keyDir, err := s.objectDir(bucket, meta.OriginalKey); err == nil && keyDir == dir {
+			return meta.OriginalKey
+		}
+	}
+	return ""
+}
@@ -0,0 +1,194 @@
+package domain
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRepairBucket(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	bodies := map[string]string{"missing": "hello", "corrupted": "hello world", "intact": "!"}
+	for key, body := range bodies {
+		if err := storage.Put("bucket", key, []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the archived version still knows the key of the object
+	if err := storage.SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"first", "second"} {
+		if err := storage.Put("bucket", "versioned", []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dirs := map[string]string{}
+	for _, key := range []string{"missing", "corrupted", "versioned"} {
+		dirs[key], _ = storage.objectDir("bucket", key)
+	}
+	for _, key := range []string{"missing", "versioned"} {
+		if err := os.Remove(dirs[key] + "/metadata.json"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(dirs["corrupted"]+"/metadata.json", []byte(`{"content_sha256": "ab`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// an object without body can not be repaired
+	broken := filepath.Join(filepath.Dir(dirs["missing"]), strings.Repeat("a", hashLen))
+	if err := os.Mkdir(broken, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// the totals are counted again, whatever they were before
+	storage.mu.Lock()
+	config, err := storage.readBucketConfig("bucket")
+	if err == nil {
+		config.UsedBytes, config.ObjectCount = 1000, 1000
+		err = storage.writeBucketConfig("bucket", config)
+	}
+	storage.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := storage.RepairBucket("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, object := range result.Repaired {
+		got = append(got, object.Key+"/"+object.Directory)
+	}
+	sort.Strings(got)
+	want := []string{"/" + filepath.Base(dirs["corrupted"]), "/" + filepath.Base(dirs["missing"]), "versioned/" + filepath.Base(dirs["versioned"])}
+	sort.Strings(want)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got repaired: '%v', want repaired: '%v'", got, want)
+	}
+	if len(result.Failed) != 1 || result.Failed[0] != filepath.Base(broken) {
+		t.Errorf("got failed: '%v', want failed: '[%s]'", result.Failed, filepath.Base(broken))
+	}
+
+	// the objects are reachable again under their keys
+	bodies["versioned"] = "second"
+	for key, body := range bodies {
+		got, err := storage.Get("bucket", key)
+		if err != nil || string(got) != body {
+			t.Errorf("got body: '%s', error: '%v', want body: '%s'", got, err, body)
+		}
+	}
+
+	storage.mu.Lock()
+	config, err = storage.readBucketConfig("bucket")
+	storage.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// all versions count towards the usage, "first" and "second" included
+	if config.ObjectCount != 4 || config.UsedBytes != 28 {
+		t.Errorf("got count: '%d', used bytes: '%d', want count: '4', used bytes: '28'", config.ObjectCount, config.UsedBytes)
+	}
+
+	// objects without a key are left out of the listings
+	list, err := storage.List("bucket", ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, object := range list.Objects {
+		keys = append(keys, object.Key)
+	}
+	if strings.Join(keys, ",") != "intact,versioned" {
+		t.Errorf("got keys: '%v', want keys: '[intact versioned]'", keys)
+	}
+
+	if err := os.Remove(broken); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := storage.ListObjectVersions("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range versions {
+		if len(version.Key) < 1 {
+			t.Errorf("got version without key: '%+v'", version)
+		}
+	}
+	if result, err := storage.RepairBucket("bucket"); err != nil || len(result.Repaired) != 0 || len(result.Failed) != 0 {
+		t.Errorf("got result: '%+v', error: '%v', want nothing left to repair", result, err)
+	}
+}
+
+func TestRepairCompressed(t *testing.T) {
+	path := t.TempDir()
+	compressing, err := NewStorage(path, WithCompression())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := compressing.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressing.Put("bucket", "key", []byte("hello world!")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the object is repaired after compression was turned off again
+	storage, err := NewStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := storage.objectDir("bucket", "key")
+	if err := os.Remove(dir + "/metadata.json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Repair("bucket", "key"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := storage.Get("bucket", "key")
+	if err != nil || string(got) != "hello world!" {
+		t.Errorf("got body: '%s', error: '%v', want body: 'hello world!'", got, err)
+	}
+}
+
+func TestRepairGzipUpload(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("hello"))
+	w.Close()
+	if err := storage.Put("bucket", "a.gz", buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	// an upload which is gzip itself is not decompressed
+	dir, _ := storage.objectDir("bucket", "a.gz")
+	if err := os.Remove(dir + "/metadata.json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Repair("bucket", "a.gz"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := storage.Get("bucket", "a.gz")
+	if err != nil || !bytes.Equal(got, buf.Bytes()) {
+		t.Errorf("got body: '%x', error: '%v', want body: '%x'", got, err, buf.Bytes())
+	}
+}
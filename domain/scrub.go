@@ -0,0 +1,296 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// scrubRate limits the bytes per second the scrubber reads from disk across
+// all of its workers, so it does not starve live traffic.
+const scrubRate = 16 << 20
+
+// WithScrubber starts a background goroutine which verifies the checksums of
+// all objects every interval, reading up to concurrency objects at once.
+func WithScrubber(interval time.Duration, concurrency int) StorageOption {
+	return func(s *Storage) {
+		s.scrubInterval = interval
+		s.scrubConcurrency = concurrency
+	}
+}
+
+// ScrubMismatches returns the number of objects of a bucket whose body did
+// not match its checksum during the last scrub.
+func (s *Storage) ScrubMismatches(bucket string) int {
+	s.scrubMu.Lock()
+	defer s.scrubMu.Unlock()
+	return s.mismatches[bucket]
+}
+
+func (s *Storage) scrubber() {
+	defer s.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(s.scrubInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if _, err := s.Scrub(ctx); err != nil && ctx.Err() == nil {
+				log.Println("[ERROR] - could not scrub storage: " + err.Error())
+			}
+		}
+	}
+}
+
+// Scrub reads every object version of all buckets and compares its body with
+// the checksum of the metadata. Mismatches are logged and counted per bucket.
+// It returns the total number of mismatches found.
+func (s *Storage) Scrub(ctx context.Context) (int, error) {
+	buckets, err := s.ListBuckets()
+	if err != nil {
+		return 0, err
+	}
+
+	concurrency := max(s.scrubConcurrency, 1)
+	limit := &throttle{rate: scrubRate}
+	total := 0
+	for _, b := range buckets {
+		dirs, err := s.scrubDirs(b.Name)
+		if err != nil {
+			return total, err
+		}
+
+		jobs := make(chan string)
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		count := 0
+		for range concurrency {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for dir := range jobs {
+					if !s.verify(ctx, b.Name, dir, limit) {
+						mu.Lock()
+						count++
+						mu.Unlock()
+					}
+				}
+			}()
+		}
+	feed:
+		for _, dir := range dirs {
+			select {
+			case <-ctx.Done():
+				break feed
+			case jobs <- dir:
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		s.scrubMu.Lock()
+		if s.mismatches == nil {
+			s.mismatches = map[string]int{}
+		}
+		s.mismatches[b.Name] = count
+		s.scrubMu.Unlock()
+		total += count
+	}
+	return total, nil
+}
+
+// scrubDirs returns the directories of all object versions of a bucket.
+func (s *Storage) scrubDirs(bucket string) ([]string, error) {
+	dir, err := s.bucketDir(bucket)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := objectDirs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := []string{}
+	for _, objDir := range objects {
+		dirs = append(dirs, objDir)
+		entries, err := os.ReadDir(filepath.Join(objDir, "versions"))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				dirs = append(dirs, filepath.Join(objDir, "versions", entry.Name()))
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// verify reports whether the object version in dir is intact and logs why it
+// is not.
+func (s *Storage) verify(ctx context.Context, bucket, dir string, limit *throttle) bool {
+	meta, err := s.inspect(ctx, dir, limit)
+	if err == nil {
+		return true
+	}
+	key := ""
+	if meta != nil {
+		key = meta.OriginalKey
+	}
+	log.Printf("[ERROR] - scrub found corrupt object of bucket '%s' key '%s' at '%s': %s", bucket, key, dir, err)
+	return false
+}
+
+// inspect reads the object version in dir and returns why it is corrupt: its
+// metadata can not be read, its body can not be read or does not match the
+// checksum and size of the metadata. Delete markers have no body and are
+// always intact, so is every version once ctx is done. The metadata is nil if
+// it could not be read. Without limit the body is read as fast as possible.
+//
+// The version is read without the lock of its object, so a write may replace
+// it meanwhile. A version only counts as corrupt if it is still corrupt when
+// it is read again while holding the lock, and not if it was removed.
+func (s *Storage) inspect(ctx context.Context, dir string, limit *throttle) (*metadata, error) {
+	meta, err := s.check(ctx, dir, limit)
+	if err == nil {
+		return meta, nil
+	}
+	object := dir
+	if filepath.Base(filepath.Dir(dir)) == "versions" {
+		object = filepath.Dir(filepath.Dir(dir))
+	}
+	unlock := s.objects.lock(object)
+	defer unlock()
+	if !exists(dir) {
+		return meta, nil
+	}
+	return s.check(ctx, dir, nil)
+}
+
+// check is inspect without the second look.
+func (s *Storage) check(ctx context.Context, dir string, limit *throttle) (*metadata, error) {
+	meta, err := readMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	if meta.DeleteMarker {
+		return meta, nil
+	}
+	if limit != nil {
+		if err := limit.wait(ctx, meta.ContentSize); err != nil {
+			return meta, nil
+		}
+	}
+
+	body, err := s.readBody(ctx, dir, meta)
+	if err != nil {
+		if ctx.Err() != nil {
+			return meta, nil
+		}
+		return meta, err
+	}
+	if len(body) != meta.ContentSize {
+		return meta, fmt.Errorf("size mismatch: metadata has %d bytes, body has %d bytes", meta.ContentSize, len(body))
+	}
+	if Sha256Hash(body) != meta.ContentHash {
+		return meta, errors.New("checksum mismatch")
+	}
+	return meta, nil
+}
+
+// Corruption is an object version which failed verification.
+type Corruption struct {
+	Bucket string
+	// Key is empty if the metadata could not be read
+	Key    string
+	Dir    string
+	Reason string
+}
+
+// Verify checks every object version of all buckets like Scrub, but as fast as
+// the disk allows and without updating the mismatches of the scrubber. It is
+// meant for offline checks, e.g. of a backup, and returns the number of object
+// versions checked along with the corrupt ones.
+func (s *Storage) Verify(ctx context.Context) (int, []Corruption, error) {
+	buckets, err := s.ListBuckets()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	checked := 0
+	corrupt := []Corruption{}
+	for _, b := range buckets {
+		dirs, err := s.scrubDirs(b.Name)
+		if err != nil {
+			return checked, corrupt, err
+		}
+		for _, dir := range dirs {
+			if err := ctx.Err(); err != nil {
+				return checked, corrupt, err
+			}
+			checked++
+			meta, err := s.inspect(ctx, dir, nil)
+			if err == nil {
+				continue
+			}
+			c := Corruption{Bucket: b.Name, Dir: dir, Reason: err.Error()}
+			if meta != nil {
+				c.Key = meta.OriginalKey
+			}
+			corrupt = append(corrupt, c)
+		}
+	}
+	return checked, corrupt, ctx.Err()
+}
+
+// throttle spaces out reads so that on average no more than rate bytes per
+// second are read.
+type throttle struct {
+	mu   sync.Mutex
+	rate int64
+	next time.Time
+}
+
+// wait blocks until n more bytes may be read or ctx is done.
+func (t *throttle) wait(ctx context.Context, n int) error {
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	delay := t.next.Sub(now)
+	t.next = t.next.Add(time.Duration(int64(n) * int64(time.Second) / t.rate))
+	t.mu.Unlock()
+
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,124 @@
+package domain
+
+import (
+	"context"
+	"maps"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestScrub(t *testing.T) {
+	storage, err := NewStorage(t.TempDir(), WithScrubber(time.Hour, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := storage.Put("bucket", key, []byte("hello world!")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir, err := storage.objectDir("bucket", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/body", []byte("hello w0rld!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := storage.Scrub(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != 1 {
+		t.Errorf("got mismatches: '%d', want mismatches: '1'", found)
+	}
+	if got := storage.ScrubMismatches("bucket"); got != 1 {
+		t.Errorf("got bucket mismatches: '%d', want bucket mismatches: '1'", got)
+	}
+
+	if err := storage.Put("bucket", "b", []byte("hello world!")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Scrub(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := storage.ScrubMismatches("bucket"); got != 0 {
+		t.Errorf("got bucket mismatches: '%d', want bucket mismatches: '0'", got)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	storage, err := NewStorage(t.TempDir(), WithCompression())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"intact", "checksum", "metadata"} {
+		if err := storage.Put("bucket", key, []byte("hello world!")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir, err := storage.objectDir("bucket", "checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := compress([]byte("hello w0rld!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/body", body, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir, err = storage.objectDir("bucket", "metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/metadata.json", []byte(`{"content_sha256": "ab`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checked, corrupt, err := storage.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked != 3 {
+		t.Errorf("got checked: '%d', want checked: '3'", checked)
+	}
+	got := map[string]string{}
+	for _, c := range corrupt {
+		got[c.Key] = c.Reason
+	}
+	want := map[string]string{"checksum": "checksum mismatch", "": "metadata of the object is corrupted"}
+	if !maps.Equal(got, want) {
+		t.Errorf("got corrupt: '%v', want corrupt: '%v'", got, want)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	limit := &throttle{rate: 1000}
+	start := time.Now()
+	for range 3 {
+		if err := limit.wait(context.Background(), 50); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first read is free, the other two have to wait 50ms each
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("got elapsed: '%v', want elapsed: '>= 100ms'", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limit.wait(ctx, 1000); err == nil {
+		t.Error("wait should fail once the context is done")
+	}
+}
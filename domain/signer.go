@@ -0,0 +1,100 @@
+package domain
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// dateFormat is the format of the x-amz-date header.
+const dateFormat = "20060102T150405Z"
+
+// Signer signs requests with SigV4 the same way Auth.Validate verifies them.
+type Signer struct {
+	accessKey string
+	secretKey string
+	region    string
+	// sessionToken is set for temporary credentials
+	sessionToken string
+	now          func() time.Time
+}
+
+func NewSigner(accessKey, secretKey, region string) *Signer {
+	return &Signer{
+		accessKey: accessKey,
+		secretKey: secretKey,
+		region:    region,
+		now:       time.Now,
+	}
+}
+
+// NewSessionSigner returns a signer for temporary credentials, which send
+// their session token along with every request.
+func NewSessionSigner(accessKey, secretKey, sessionToken, region string) *Signer {
+	s := NewSigner(accessKey, secretKey, region)
+	s.sessionToken = sessionToken
+	return s
+}
+
+// Sign sets the x-amz-date, x-amz-content-sha256 and Authorization headers
+// of the request, and x-amz-security-token for temporary credentials. The
+// body has to be the exact payload of the request.
+func (s *Signer) Sign(r *http.Request, body []byte) {
+	s.SignPayload(r, Sha256Hash(body))
+}
+
+// SignPayload is like Sign but takes the value of the x-amz-content-sha256
+// header instead of the body, e.g. "STREAMING-UNSIGNED-PAYLOAD-TRAILER".
+func (s *Signer) SignPayload(r *http.Request, bodyHash string) {
+	date := s.now().UTC().Format(dateFormat)
+	r.Header.Set("x-amz-date", date)
+	r.Header.Set("x-amz-content-sha256", bodyHash)
+
+	headers := http.Header{}
+	headers.Set("host", host(r))
+	headers.Set("x-amz-content-sha256", bodyHash)
+	headers.Set("x-amz-date", date)
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	if len(s.sessionToken) > 0 {
+		r.Header.Set("x-amz-security-token", s.sessionToken)
+		headers.Set("x-amz-security-token", s.sessionToken)
+		signed += ";x-amz-security-token"
+	}
+	cred := date[:8] + "/" + s.region + "/s3/aws4_request"
+
+	sig := signature(s.secretKey, r.Method, r.URL.RequestURI(), headers, signed, bodyHash, date, cred)
+
+	r.Header.Set("Authorization", signAlgorithm+" Credential="+s.accessKey+"/"+cred+
+		", SignedHeaders="+signed+", Signature="+sig)
+}
+
+// Presign adds the signature to the query string of the request, so the URL
+// can be handed to someone without credentials until it expires. Only the
+// host header is signed.
+func (s *Signer) Presign(r *http.Request, expires time.Duration) {
+	date := s.now().UTC().Format(dateFormat)
+	cred := date[:8] + "/" + s.region + "/s3/aws4_request"
+
+	query := r.URL.Query()
+	query.Set("X-Amz-Algorithm", signAlgorithm)
+	query.Set("X-Amz-Credential", s.accessKey+"/"+cred)
+	query.Set("X-Amz-Date", date)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if len(s.sessionToken) > 0 {
+		query.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+	r.URL.RawQuery = query.Encode()
+
+	headers := http.Header{}
+	headers.Set("host", host(r))
+	sig := signature(s.secretKey, r.Method, r.URL.RequestURI(), headers, "host", unsignedPayload, date, cred)
+	r.URL.RawQuery += "&X-Amz-Signature=" + sig
+}
+
+func host(r *http.Request) string {
+	if len(r.Host) > 0 {
+		return r.Host
+	}
+	return r.URL.Host
+}
@@ -0,0 +1,124 @@
+package domain
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// requestHeaders returns the headers of the request the way the server
+// passes them to Auth.
+func requestHeaders(r *http.Request) http.Header {
+	headers := r.Header.Clone()
+	headers.Set("host", r.Host)
+	return headers
+}
+
+func TestSignerSign(t *testing.T) {
+	var tests = []struct {
+		name   string
+		method string
+		url    string
+		body   string
+	}{
+		{"get object", "GET", "http://localhost:8000/bucket/key", ""},
+		{"put object", "PUT", "http://localhost:8000/bucket/key", "hello world!"},
+		{"escaped key", "GET", "http://localhost:8000/bucket/dir%2Fkey%20name", ""},
+		{"query", "GET", "http://localhost:8000/bucket?prefix=a&list-type=2", ""},
+		{"subresource", "PUT", "http://localhost:8000/bucket?versioning", "<VersioningConfiguration/>"},
+	}
+
+	auth := NewAuth("test-access-key", "test-secret-key")
+	signer := NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.url, nil)
+			signer.Sign(r, []byte(test.body))
+
+			headers := requestHeaders(r)
+			accessKey, err := auth.Validate(test.method, r.URL.RequestURI(), headers, Sha256Hash([]byte(test.body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if accessKey != "test-access-key" {
+				t.Errorf("got access key: '%s', want access key: 'test-access-key'", accessKey)
+			}
+
+			// a different method must not verify with the same signature
+			if _, err := auth.Validate("DELETE", r.URL.RequestURI(), headers, Sha256Hash([]byte(test.body))); err == nil {
+				t.Error("got error: '<nil>', want error for tampered method")
+			}
+		})
+	}
+}
+
+func TestSignerPresign(t *testing.T) {
+	auth := NewAuth("test-access-key", "test-secret-key")
+	signer := NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	signedAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	signer.now = func() time.Time { return signedAt }
+
+	r := httptest.NewRequest("GET", "http://localhost:8000/bucket/key", nil)
+	signer.Presign(r, 15*time.Minute)
+	uri := r.URL.RequestURI()
+	headers := http.Header{}
+	headers.Set("host", r.Host)
+
+	var tests = []struct {
+		name   string
+		method string
+		uri    string
+		now    time.Time
+		valid  bool
+	}{
+		{"valid", "GET", uri, signedAt.Add(time.Minute), true},
+		{"expired", "GET", uri, signedAt.Add(16 * time.Minute), false},
+		{"within clock skew", "GET", uri, signedAt.Add(-14 * time.Minute), true},
+		{"dated in the future", "GET", uri, signedAt.Add(-16 * time.Minute), false},
+		{"other method", "PUT", uri, signedAt.Add(time.Minute), false},
+		{"other key", "GET", strings.Replace(uri, "/bucket/key", "/bucket/other", 1), signedAt.Add(time.Minute), false},
+		{"extended expiry", "GET", strings.Replace(uri, "X-Amz-Expires=900", "X-Amz-Expires=9000", 1), signedAt.Add(time.Minute), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := auth.ValidatePresigned(test.method, test.uri, headers, test.now)
+			got := err == nil
+			if got != test.valid {
+				t.Errorf("got valid: '%t', want valid: '%t' (%v)", got, test.valid, err)
+			}
+		})
+	}
+}
+
+func TestSessionSigner(t *testing.T) {
+	auth := NewAuth("test-access-key", "test-secret-key")
+	auth.SetTokenValidator(func(accessKey, token string) error {
+		if accessKey != "test-access-key" || token != "session-token" {
+			return errors.New("unknown token")
+		}
+		return nil
+	})
+	signer := NewSessionSigner("test-access-key", "test-secret-key", "session-token", "us-east-1")
+
+	r := httptest.NewRequest("PUT", "http://localhost:8000/bucket/key", nil)
+	signer.Sign(r, []byte("hello"))
+	if got := r.Header.Get("x-amz-security-token"); got != "session-token" {
+		t.Errorf("got token: '%s', want token: 'session-token'", got)
+	}
+	if _, err := auth.Validate("PUT", r.URL.RequestURI(), requestHeaders(r), Sha256Hash([]byte("hello"))); err != nil {
+		t.Errorf("got error: '%v', want error: '<nil>'", err)
+	}
+
+	r = httptest.NewRequest("GET", "http://localhost:8000/bucket/key", nil)
+	signer.Presign(r, 15*time.Minute)
+	if _, err := auth.ValidatePresigned("GET", r.URL.RequestURI(), requestHeaders(r), time.Now()); err != nil {
+		t.Errorf("got error: '%v', want error: '<nil>'", err)
+	}
+	tampered := strings.Replace(r.URL.RequestURI(), "session-token", "other-token", 1)
+	if _, err := auth.ValidatePresigned("GET", tampered, requestHeaders(r), time.Now()); !hasCode(err, "SignatureDoesNotMatch") {
+		t.Errorf("got error: '%v', want code: 'SignatureDoesNotMatch'", err)
+	}
+}
@@ -1,57 +1,307 @@
 package domain
 
 import (
+	"context"
+	"crypto/cipher"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 type Storage struct {
 	path string
+	// mu guards the read-modify-write cycles of the bucket.json files
+	mu sync.Mutex
+
+	region              string
+	caseInsensitiveKeys bool
+	nfcKeys             bool
+	relaxedNaming       bool
+	fanOut              int
+	compression         bool
+	deduplication       bool
+	masterKey           *string
+	encryption          cipher.AEAD
+	trashRetention      time.Duration
+	minFreeSpace        uint64
+	scrubInterval       time.Duration
+	scrubConcurrency    int
+	listConcurrency     int
+	maxKeyLength        int
+	pendingPath         bool
+	metadataFormat      metadataFormat
+	fileMode            os.FileMode
+	dirMode             os.FileMode
+	// freeSpace is replaced in tests to simulate a full disk
+	freeSpace func(path string) (uint64, error)
+	// moveBody is replaced in tests to simulate a failing body write
+	moveBody func(oldpath, newpath string) error
+
+	// objects serializes writes to the same object
+	objects objectLocks
+	// blobMu guards the reference counts of the deduplicated bodies
+	blobMu sync.Mutex
+	// readOnly is set while writes fail because the data directory is not
+	// writable, so the failure is only logged once
+	readOnly atomic.Bool
+
+	// scrubMu guards the mismatches found by the last scrub of every bucket
+	scrubMu    sync.Mutex
+	mismatches map[string]int
+
+	// done stops the background goroutines, wg waits for them to finish
+	done chan struct{}
+	wg   sync.WaitGroup
 }
 
-func NewStorage(path string) (*Storage, error) {
-	info, err := os.Stat(path)
-	if os.IsNotExist(err) {
-		return nil, fmt.Errorf("path '%s' does not exist", path)
-	} else if err != nil {
-		return nil, err
-	} else if !info.IsDir() {
-		return nil, fmt.Errorf("path '%s' is not a directory", path)
+// errors shared by the storage backends
+var (
+	errNoSuchBucket = &Error{
+		msg:    "requested bucket does not exist",
+		Code:   "NoSuchBucket",
+		Status: http.StatusNotFound,
+	}
+	errBucketOwnedByYou = &Error{
+		msg:    "requested bucket already exists and is owned by you",
+		Code:   "BucketAlreadyOwnedByYou",
+		Status: http.StatusConflict,
+	}
+	errBucketExists = &Error{
+		msg:    "requested bucket name is not available",
+		Code:   "BucketAlreadyExists",
+		Status: http.StatusConflict,
+	}
+	errNoSuchKey = &Error{
+		msg:    "object under requested key does not exist",
+		Code:   "NoSuchKey",
+		Status: http.StatusNotFound,
+	}
+	errObjectExists = &Error{
+		msg:    "object under requested key already exists",
+		Code:   "PreconditionFailed",
+		Status: http.StatusPreconditionFailed,
+	}
+	errPreconditionFailed = &Error{
+		msg:    "object under requested key does not match the precondition",
+		Code:   "PreconditionFailed",
+		Status: http.StatusPreconditionFailed,
+	}
+	errDeleteMarker = &Error{
+		msg:    "requested version is a delete marker",
+		Code:   "MethodNotAllowed",
+		Status: http.StatusMethodNotAllowed,
+	}
+	errNoSuchVersion = &Error{
+		msg:    "requested version does not exist",
+		Code:   "NoSuchVersion",
+		Status: http.StatusNotFound,
+	}
+)
+
+// defaultMaxKeyLength is the maximum length of object keys in bytes.
+const defaultMaxKeyLength = 1024
+
+type StorageOption func(*Storage)
+
+// WithRegion sets the region new buckets are created in. It defaults to
+// us-east-1.
+func WithRegion(region string) StorageOption {
+	return func(s *Storage) {
+		s.region = region
+	}
+}
+
+// WithCaseInsensitiveKeys lowercases object keys before they are hashed, so
+// "Foo" and "foo" address the same object. The original key is still stored
+// in the metadata. Enabling it is not retroactive: objects stored before under
+// a key containing uppercase letters are not reachable anymore.
+func WithCaseInsensitiveKeys() StorageOption {
+	return func(s *Storage) {
+		s.caseInsensitiveKeys = true
+	}
+}
+
+// WithNFCKeys normalizes object keys to the Unicode normalization form C
+// before they are hashed, so "café" sent in the decomposed form (as macOS does)
+// and in the composed form address the same object. Keys which are not valid
+// UTF-8 are rejected. Like WithCaseInsensitiveKeys it is not retroactive.
+func WithNFCKeys() StorageOption {
+	return func(s *Storage) {
+		s.nfcKeys = true
+	}
+}
+
+// WithRelaxedNaming replaces the AWS bucket naming rules with a relaxed policy
+// which allows uppercase letters, underscores and names of up to 255
+// characters. Names which are unsafe as directory names are still rejected.
+func WithRelaxedNaming() StorageOption {
+	return func(s *Storage) {
+		s.relaxedNaming = true
+	}
+}
+
+// WithMaxKeyLength limits object keys to the given amount of bytes. It
+// defaults to 1024, the limit of S3.
+func WithMaxKeyLength(bytes int) StorageOption {
+	return func(s *Storage) {
+		s.maxKeyLength = bytes
+	}
+}
+
+// WithFileModes sets the permissions of the files and directories the storage
+// creates. They are applied exactly, the umask of the process has no effect.
+// They default to 0644 and 0755.
+func WithFileModes(file, dir os.FileMode) StorageOption {
+	return func(s *Storage) {
+		s.fileMode = file
+		s.dirMode = dir
+	}
+}
+
+func NewStorage(path string, opts ...StorageOption) (*Storage, error) {
+	s := &Storage{
+		path:            path,
+		region:          "us-east-1",
+		maxKeyLength:    defaultMaxKeyLength,
+		listConcurrency: defaultListConcurrency,
+		metadataFormat:  jsonFormat{},
+		fileMode:        0644,
+		dirMode:         0755,
+		freeSpace:       freeSpace,
+		moveBody:        os.Rename,
+		done:            make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if !s.pendingPath {
+		if err := checkDir(path); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if s.masterKey != nil {
+		if s.encryption, err = newCipher(*s.masterKey); err != nil {
+			return nil, err
+		}
+	}
+	if s.trashRetention > 0 {
+		s.wg.Add(1)
+		go s.sweeper()
+	}
+	if s.scrubInterval > 0 {
+		s.wg.Add(1)
+		go s.scrubber()
 	}
-	return &Storage{path: path}, nil
+	return s, nil
+}
+
+// Close stops all background goroutines of the storage and waits for them
+// to finish.
+func (s *Storage) Close() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+// Region returns the region new buckets are created in.
+func (s *Storage) Region() string {
+	return s.region
+}
+
+// reservedNames are the paths the server answers outside of the S3 API, a
+// bucket of that name could not be listed.
+var reservedNames = []string{"healthz", "metrics", "readyz", "version"}
+
+// validName checks the name of a bucket against the naming policy of the
+// storage.
+func (s *Storage) validName(name string) error {
+	if slices.Contains(reservedNames, name) {
+		return &Error{
+			msg:    fmt.Sprintf("bucket name '%s' is reserved", name),
+			Code:   "InvalidBucketName",
+			Status: http.StatusBadRequest,
+		}
+	}
+	if s.relaxedNaming {
+		return relaxedName(name)
+	}
+	return strictName(name)
+}
+
+// relaxedName allows every name that is safe to use as a directory name:
+// up to 255 letters of any case, numbers, periods, hyphens and underscores,
+// not starting with a period.
+func relaxedName(name string) error {
+	if len(name) < 1 || len(name) > 255 {
+		return &Error{
+			msg:    "bucket name must be between 1 and 255 characters long",
+			Code:   "InvalidBucketName",
+			Status: http.StatusBadRequest,
+		}
+	}
+	for _, char := range name {
+		if char < unicode.MaxASCII && (unicode.IsLetter(char) || unicode.IsDigit(char) ||
+			char == '.' || char == '-' || char == '_') {
+			continue
+		}
+		return &Error{
+			msg:    "bucket name can consist only of letters, numbers, periods, hyphens and underscores",
+			Code:   "InvalidBucketName",
+			Status: http.StatusBadRequest,
+		}
+	}
+	// this also rejects "." and ".." as well as hidden directories
+	if strings.HasPrefix(name, ".") {
+		return &Error{
+			msg:    "bucket name can not begin with a period",
+			Code:   "InvalidBucketName",
+			Status: http.StatusBadRequest,
+		}
+	}
+	return nil
 }
 
 // implemented naming rules from the following link:
 // https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html
-func validName(name string) error {
+func strictName(name string) error {
 	// Bucket names must be between 3 (min) and 63 (max) characters long.
 	if len(name) < 3 {
 		return &Error{
 			msg:    "bucket name must be at least 3 characters long",
+			Code:   "InvalidBucketName",
 			Status: http.StatusBadRequest,
 		}
 	}
 	if len(name) > 63 {
 		return &Error{
 			msg:    "bucket name must be not longer than 63 characters",
+			Code:   "InvalidBucketName",
 			Status: http.StatusBadRequest,
 		}
 	}
 
 	// Bucket names can consist only of lowercase letters, numbers, periods (.), and hyphens (-).
 	for _, char := range name {
-		if unicode.IsLower(char) || unicode.IsDigit(char) || string(char) == "." || string(char) == "-" {
+		if char < unicode.MaxASCII && (unicode.IsLower(char) || unicode.IsDigit(char) || char == '.' || char == '-') {
 			continue
 		}
 		return &Error{
 			msg:    "bucket name can consist only of lowercase letters, numbers, periods and hyphens",
+			Code:   "InvalidBucketName",
 			Status: http.StatusBadRequest,
 		}
 	}
@@ -60,12 +310,14 @@ func validName(name string) error {
 	if begin := rune(name[:1][0]); !unicode.IsLower(begin) && !unicode.IsDigit(begin) {
 		return &Error{
 			msg:    "bucket name must begin with a letter or number",
+			Code:   "InvalidBucketName",
 			Status: http.StatusBadRequest,
 		}
 	}
 	if end := rune(name[len(name)-1:][0]); !unicode.IsLower(end) && !unicode.IsDigit(end) {
 		return &Error{
 			msg:    "bucket name must end with a letter or number",
+			Code:   "InvalidBucketName",
 			Status: http.StatusBadRequest,
 		}
 	}
@@ -74,14 +326,29 @@ func validName(name string) error {
 	if strings.Contains(name, "..") {
 		return &Error{
 			msg:    "bucket name can not contain two adjacent periods",
+			Code:   "InvalidBucketName",
 			Status: http.StatusBadRequest,
 		}
 	}
 
+	// Bucket names used in virtual-hosted-style requests become DNS names, so
+	// every label between periods must be non-empty and must not begin or end
+	// with a hyphen.
+	for _, label := range strings.Split(name, ".") {
+		if len(label) < 1 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return &Error{
+				msg:    "bucket name labels must begin and end with a letter or number",
+				Code:   "InvalidBucketName",
+				Status: http.StatusBadRequest,
+			}
+		}
+	}
+
 	// Bucket names must not be formatted as an IP address (for example, 192.168.5.4).
 	if net.ParseIP(name) != nil {
 		return &Error{
 			msg:    "bucket name can not be formatted as an IP address",
+			Code:   "InvalidBucketName",
 			Status: http.StatusBadRequest,
 		}
 	}
@@ -94,6 +361,7 @@ func validName(name string) error {
 		if strings.HasPrefix(name, p) {
 			return &Error{
 				msg:    fmt.Sprintf("bucket name can not begin with the prefix: '%s'", p),
+				Code:   "InvalidBucketName",
 				Status: http.StatusBadRequest,
 			}
 		}
@@ -113,11 +381,12 @@ func validName(name string) error {
 	// Bucket names must not end with the suffix --table-s3. This suffix is reserved for
 	// S3 Tables buckets. For more information, see Amazon S3 table bucket, table, and
 	// namespace naming rules.
-	suffix := []string{"-s3alias", "--ol-s3", "--x-s3", "--table-s3"}
+	suffix := []string{"-s3alias", "--ol-s3", ".mrap", "--x-s3", "--table-s3"}
 	for _, s := range suffix {
 		if strings.HasSuffix(name, s) {
 			return &Error{
 				msg:    fmt.Sprintf("bucket name can not end with the suffix: '%s'", s),
+				Code:   "InvalidBucketName",
 				Status: http.StatusBadRequest,
 			}
 		}
@@ -126,23 +395,166 @@ func validName(name string) error {
 	return nil
 }
 
-func (s *Storage) existPath(path string) bool {
-	_, err := os.Stat(s.path + "/" + path)
+// safePath rejects path elements that could be used to escape the
+// directory they are joined onto.
+func safePath(elem string) error {
+	if strings.ContainsRune(elem, 0) ||
+		filepath.IsAbs(elem) ||
+		strings.HasPrefix(elem, "/") ||
+		strings.HasPrefix(elem, "\\") {
+		return &Error{
+			msg:    "path contains forbidden characters",
+			Code:   "InvalidArgument",
+			Status: http.StatusBadRequest,
+		}
+	}
+	for _, part := range strings.FieldsFunc(elem, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return &Error{
+				msg:    "path must not contain '..' elements",
+				Code:   "InvalidArgument",
+				Status: http.StatusBadRequest,
+			}
+		}
+	}
+	return nil
+}
+
+// validKey checks an object key before it is used to address an object. The
+// length is counted in bytes of the UTF-8 encoding, like S3 does.
+func (s *Storage) validKey(key string) error {
+	if len(key) < 1 {
+		return &Error{
+			msg:    "object key must not be empty",
+			Code:   "InvalidArgument",
+			Status: http.StatusBadRequest,
+		}
+	}
+	if len(key) > s.maxKeyLength {
+		return &Error{
+			msg:    fmt.Sprintf("object key must not be longer than %d bytes", s.maxKeyLength),
+			Code:   "KeyTooLongError",
+			Status: http.StatusBadRequest,
+		}
+	}
+	if s.nfcKeys && !utf8.ValidString(key) {
+		return &Error{
+			msg:    "object key must be valid UTF-8",
+			Code:   "InvalidArgument",
+			Status: http.StatusBadRequest,
+		}
+	}
+	return safePath(key)
+}
+
+// canonicalKey returns the form of a valid key which is hashed to find the
+// object, see WithCaseInsensitiveKeys and WithNFCKeys.
+func (s *Storage) canonicalKey(key string) string {
+	if s.nfcKeys {
+		key = norm.NFC.String(key)
+	}
+	if s.caseInsensitiveKeys {
+		key = strings.ToLower(key)
+	}
+	return key
+}
+
+// resolve joins the elements onto the storage path and verifies that the
+// result is still contained in the storage directory.
+func (s *Storage) resolve(elems ...string) (string, error) {
+	for _, elem := range elems {
+		if err := safePath(elem); err != nil {
+			return "", err
+		}
+	}
+
+	path := filepath.Join(append([]string{s.path}, elems...)...)
+	rel, err := filepath.Rel(s.path, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &Error{
+			msg:    "path escapes the storage directory",
+			Code:   "InvalidArgument",
+			Status: http.StatusBadRequest,
+		}
+	}
+	return path, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
 	return err == nil
 }
 
-func (s *Storage) NewBucket(name string) error {
-	if err := validName(name); err != nil {
+// bucketDir returns the directory of an existing bucket. The naming policy
+// only applies when a bucket is created, a bucket created under the relaxed
+// policy stays reachable when the storage is strict again. Every name still
+// has to be safe as directory name, which also keeps out the hidden
+// directories of the storage.
+func (s *Storage) bucketDir(bucket string) (string, error) {
+	if err := relaxedName(bucket); err != nil {
+		return "", err
+	}
+	dir, err := s.resolve(bucket)
+	if err != nil {
+		return "", err
+	}
+	if !exists(dir) {
+		// a name which could never have been created is invalid rather
+		// than missing, e.g. "MyBucket" of /MyBucket/key
+		if err := s.validName(bucket); err != nil {
+			return "", err
+		}
+		return "", errNoSuchBucket
+	}
+	return dir, nil
+}
+
+// objectDir returns the directory an object is stored in, which does not need
+// to exist yet. The key is hashed, so it can never reach the filesystem as is.
+func (s *Storage) objectDir(bucket, key string) (string, error) {
+	if err := s.validKey(key); err != nil {
+		return "", err
+	}
+	dir, err := s.bucketDir(bucket)
+	if err != nil {
+		return "", err
+	}
+	return s.shardPath(dir, Sha256Hash([]byte(s.canonicalKey(key)))), nil
+}
+
+// NewBucket creates a bucket owned by the given access key.
+func (s *Storage) NewBucket(name, owner string) (err error) {
+	defer func() { err = s.unwritable(context.Background(), err) }()
+	if err := s.validName(name); err != nil {
 		return err
 	}
-	if s.existPath(name) {
-		return &Error{
-			msg:    "requested bucket name is not available",
-			Status: http.StatusConflict,
+	dir, err := s.resolve(name)
+	if err != nil {
+		return err
+	}
+	if exists(dir) {
+		if info, err := s.Bucket(name); err == nil && info.OwnerAccessKey == owner {
+			return errBucketOwnedByYou
 		}
+		return errBucketExists
 	}
 
-	if err := os.MkdirAll(s.path+"/"+name, 0755); err != nil {
+	if err := mkdirAll(dir, s.dirMode); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err = s.writeBucketConfig(name, &bucketConfig{
+		CreatedAt:      time.Now().UTC(),
+		OwnerAccessKey: owner,
+		Region:         s.region,
+	})
+	if err != nil {
+		// a bucket without its config would be treated as a legacy bucket
+		if err := os.RemoveAll(dir); err != nil {
+			log.Println("[ERROR] - could not clean up bucket directory: " + err.Error())
+		}
 		return err
 	}
 
@@ -150,97 +562,661 @@ func (s *Storage) NewBucket(name string) error {
 }
 
 type metadata struct {
-	ContentHash  string `json:"content_sha256"`
-	ContentSize  int    `json:"content_size"`
-	OriginalKey  string `json:"original_key"`
-	LastModified int64  `json:"last_modified"`
+	ContentHash string `json:"content_sha256"`
+	ContentSize int    `json:"content_size"`
+	ETag        string `json:"etag,omitempty"`
+	OriginalKey string `json:"original_key"`
+	// LastModified is kept in unix seconds for older readers, ModifiedAt
+	// carries the full precision
+	LastModified int64     `json:"last_modified"`
+	ModifiedAt   time.Time `json:"modified_at,omitzero"`
+	VersionID    string    `json:"version_id,omitempty"`
+	DeleteMarker bool      `json:"delete_marker,omitempty"`
+	Compressed   bool      `json:"compressed,omitempty"`
+	// Nonce is set if the body is encrypted
+	Nonce string `json:"nonce,omitempty"`
+	// ContentEncoding is the encoding the client uploaded the body with, it
+	// is returned as is and has nothing to do with Compressed
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	// ContentType is the media type sent on upload, objects without one are
+	// served with the type of their extension
+	ContentType string `json:"content_type,omitempty"`
+	// CacheControl and Expires are returned as is for caches and CDNs
+	CacheControl string `json:"cache_control,omitempty"`
+	Expires      string `json:"expires,omitempty"`
+	// StorageClass is empty for objects stored before it was recorded
+	StorageClass string `json:"storage_class,omitempty"`
+	// LegalHold, RetentionMode and RetainUntil are the object lock of the
+	// version
+	LegalHold     bool      `json:"legal_hold,omitempty"`
+	RetentionMode string    `json:"retention_mode,omitempty"`
+	RetainUntil   time.Time `json:"retain_until,omitzero"`
+	// Blob is the content hash of the shared body in .blobs if the object
+	// was stored with deduplication, it has no body file then
+	Blob string `json:"blob,omitempty"`
 }
 
-func (s *Storage) Get(bucket, key string) ([]byte, error) {
-	if !s.existPath(bucket) {
-		return nil, &Error{
-			msg:    "requested bucket does not exist",
-			Status: http.StatusNotFound,
+// DeleteOptions carry the conditions an object has to meet to be deleted.
+type DeleteOptions struct {
+	// IfMatch fails the delete with PreconditionFailed unless the ETag of
+	// the object is one of the listed entity tags
+	IfMatch string
+	// BypassGovernance allows deleting an object under governance retention
+	BypassGovernance bool
+}
+
+// PutOptions carry the attributes stored along with an object.
+type PutOptions struct {
+	// ContentEncoding is echoed back on downloads, e.g. "gzip"
+	ContentEncoding string
+	// ContentType is echoed back on downloads, if empty the type is inferred
+	// from the extension of the key
+	ContentType string
+	// CacheControl and Expires are echoed back on downloads, e.g.
+	// "max-age=3600"
+	CacheControl string
+	Expires      string
+	// StorageClass is only recorded, there is a single tier. It defaults to
+	// STANDARD.
+	StorageClass string
+	// IfAbsent fails the upload with PreconditionFailed if the key is taken
+	IfAbsent bool
+	// BypassGovernance allows overwriting an object under governance
+	// retention
+	BypassGovernance bool
+	// etag replaces the MD5 of the body, it is set for multipart uploads
+	etag string
+}
+
+func (m *metadata) setAttributes(opts PutOptions) {
+	m.ContentEncoding = opts.ContentEncoding
+	m.ContentType = opts.ContentType
+	m.CacheControl = opts.CacheControl
+	m.Expires = opts.Expires
+	m.StorageClass = opts.StorageClass
+	if len(m.StorageClass) < 1 {
+		m.StorageClass = StorageClassStandard
+	}
+	if len(opts.etag) > 0 {
+		m.ETag = opts.etag
+	}
+}
+
+// StorageClassStandard is the storage class of objects uploaded without one.
+const StorageClassStandard = "STANDARD"
+
+// storageClasses are the storage classes of S3 an upload may request.
+var storageClasses = []string{
+	StorageClassStandard, "REDUCED_REDUNDANCY", "STANDARD_IA", "ONEZONE_IA", "INTELLIGENT_TIERING",
+	"GLACIER", "DEEP_ARCHIVE", "GLACIER_IR", "OUTPOSTS", "SNOW", "EXPRESS_ONEZONE",
+}
+
+func validStorageClass(class string) error {
+	if len(class) > 0 && !slices.Contains(storageClasses, class) {
+		return &Error{
+			msg:    "the storage class '" + class + "' is not valid",
+			Code:   "InvalidStorageClass",
+			Status: http.StatusBadRequest,
 		}
 	}
+	return nil
+}
 
-	hash := Sha256Hash([]byte(key))
-	if !s.existPath(bucket + "/" + hash) {
+// readMetadata reads the metadata of an object in whichever format it was
+// written.
+func readMetadata(dir string) (*metadata, error) {
+	b, err := os.ReadFile(dir + "/metadata.json")
+	if err != nil {
+		return nil, fmt.Errorf("could not read metadata file: %w", err)
+	}
+	meta, err := decodeMetadata(b)
+	if err != nil {
 		return nil, &Error{
-			msg:    "object under requested key does not exist",
-			Status: http.StatusNotFound,
+			msg:    "metadata of the object is corrupted",
+			Code:   "CorruptedMetadata",
+			Status: http.StatusInternalServerError,
 		}
 	}
+	return meta, nil
+}
 
-	path := s.path + "/" + bucket + "/" + hash
-	body, err := os.ReadFile(path + "/body")
+func (s *Storage) writeMetadata(dir string, meta *metadata) error {
+	b, err := s.metadataFormat.marshal(meta)
 	if err != nil {
-		return nil, fmt.Errorf("could not read data file: %w", err)
+		return fmt.Errorf("could not marshal metadata struct: %w", err)
+	}
+	if err := writeFileAtomic(dir+"/metadata.json", b, s.fileMode); err != nil {
+		return fmt.Errorf("could not write metadata.json: %w", err)
 	}
+	return nil
+}
 
-	meta := &metadata{}
-	b, err := os.ReadFile(path + "/metadata.json")
+func isCorrupted(err error) bool {
+	domErr, ok := err.(*Error)
+	return ok && domErr.Code == "CorruptedMetadata"
+}
+
+// BucketStats returns the number of objects in a bucket and the sum of their
+// sizes. Only the metadata files are read, the bodies are never touched.
+// Objects whose metadata can not be read are skipped like in the listings.
+func (s *Storage) BucketStats(name string) (int, int64, error) {
+	dir, err := s.bucketDir(name)
 	if err != nil {
-		return nil, fmt.Errorf("could not read metadata file: %w", err)
+		return 0, 0, err
+	}
+
+	dirs, err := objectDirs(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	count := 0
+	var size int64
+	for _, meta := range s.readAllMetadata(name, dirs) {
+		if meta == nil || meta.DeleteMarker {
+			continue
+		}
+		count++
+		size += int64(meta.ContentSize)
+	}
+
+	return count, size, nil
+}
+
+func (s *Storage) Get(bucket, key string) ([]byte, error) {
+	body, _, err := s.GetVersionCtx(context.Background(), bucket, key, "")
+	return body, err
+}
+
+// GetCtx is like Get but aborts reading the body once ctx is done.
+func (s *Storage) GetCtx(ctx context.Context, bucket, key string) ([]byte, error) {
+	body, _, err := s.GetVersionCtx(ctx, bucket, key, "")
+	return body, err
+}
+
+type ObjectInfo struct {
+	Key             string
+	VersionID       string
+	ContentHash     string
+	ETag            string
+	Size            int64
+	LastModified    time.Time
+	ContentEncoding string
+	ContentType     string
+	CacheControl    string
+	Expires         string
+	StorageClass    string
+	Lock            ObjectLock
+}
+
+func newObjectInfo(meta *metadata) *ObjectInfo {
+	info := &ObjectInfo{
+		Key:             meta.OriginalKey,
+		VersionID:       meta.VersionID,
+		ContentHash:     meta.ContentHash,
+		ETag:            meta.etag(),
+		Size:            int64(meta.ContentSize),
+		LastModified:    meta.modified(),
+		ContentEncoding: meta.ContentEncoding,
+		ContentType:     meta.ContentType,
+		CacheControl:    meta.CacheControl,
+		Expires:         meta.Expires,
+		StorageClass:    meta.StorageClass,
+		Lock:            meta.lock(),
+	}
+	if len(info.StorageClass) < 1 {
+		info.StorageClass = StorageClassStandard
+	}
+	return info
+}
+
+// modified returns the time of the last modification. Metadata written
+// before the full precision was stored falls back to seconds.
+func (m *metadata) modified() time.Time {
+	if !m.ModifiedAt.IsZero() {
+		return m.ModifiedAt
+	}
+	return time.Unix(m.LastModified, 0).UTC()
+}
+
+func (m *metadata) setModified(t time.Time) {
+	m.LastModified = t.UTC().Unix()
+	m.ModifiedAt = t.UTC()
+}
+
+// etag falls back to the content hash for objects stored before the MD5 was
+// part of the metadata.
+func (m *metadata) etag() string {
+	if len(m.ETag) > 0 {
+		return m.ETag
 	}
-	if err := json.Unmarshal(b, meta); err != nil {
-		return nil, fmt.Errorf("could not unmarshal metadata.json content: %w", err)
+	return `"` + m.ContentHash + `"`
+}
+
+// matches reports whether one of the entity tags of an If-Match header is
+// the ETag or the content hash of the object. "*" matches every object.
+func (m *metadata) matches(ifMatch string) bool {
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.Trim(strings.TrimSpace(tag), `"`)
+		if tag == "*" || tag == strings.Trim(m.etag(), `"`) || tag == m.ContentHash {
+			return true
+		}
+	}
+	return false
+}
+
+// Head returns the information about the latest version of an object without
+// reading its body.
+func (s *Storage) Head(bucket, key string) (*ObjectInfo, error) {
+	_, meta, err := s.lookup(context.Background(), bucket, key, "")
+	if err != nil {
+		return nil, err
+	}
+	return newObjectInfo(meta), nil
+}
+
+// Metadata returns the metadata stored along with a version of an object as
+// JSON, like it is persisted in metadata.json without WithCompactMetadata. An
+// empty version ID refers to the latest version.
+func (s *Storage) Metadata(bucket, key, versionID string) ([]byte, error) {
+	_, meta, err := s.lookup(context.Background(), bucket, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(meta)
+}
+
+// GetVersion returns the body of a specific version of an object. An empty
+// version ID refers to the latest version.
+func (s *Storage) GetVersion(bucket, key, versionID string) ([]byte, error) {
+	body, _, err := s.GetVersionCtx(context.Background(), bucket, key, versionID)
+	return body, err
+}
+
+// GetVersionCtx is like GetVersion but aborts reading the body once ctx is
+// done. It also returns the information about the object version.
+func (s *Storage) GetVersionCtx(ctx context.Context, bucket, key, versionID string) ([]byte, *ObjectInfo, error) {
+	path, meta, err := s.lookup(ctx, bucket, key, versionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body, err := s.readBody(ctx, path, meta)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	if Sha256Hash(body) != meta.ContentHash {
-		return nil, errors.New("content checksum mismatch")
+		return nil, nil, errors.New("content checksum mismatch")
 	}
 
-	return body, nil
+	return body, newObjectInfo(meta), nil
 }
 
-func (s *Storage) Put(bucket, key string, body []byte) error {
-	if !s.existPath(bucket) {
-		return &Error{
-			msg:    "requested bucket does not exist",
-			Status: http.StatusNotFound,
+// lookup returns the directory and the metadata of an object version which is
+// not a delete marker.
+func (s *Storage) lookup(ctx context.Context, bucket, key, versionID string) (string, *metadata, error) {
+	path, err := s.objectDir(bucket, key)
+	if err != nil {
+		return "", nil, err
+	}
+	if !exists(path) {
+		return "", nil, errNoSuchKey
+	}
+	if versionID != "" {
+		if path, err = versionDir(path, versionID); err != nil {
+			return "", nil, err
+		}
+	}
+
+	meta, err := readMetadata(path)
+	if isCorrupted(err) {
+		log.Printf("[ERROR] - %scorrupted metadata of bucket '%s' key '%s' at '%s'", logPrefix(ctx), bucket, key, path)
+		return "", nil, err
+	} else if err != nil {
+		return "", nil, err
+	}
+	if meta.DeleteMarker && versionID == "" {
+		return "", nil, errNoSuchKey
+	} else if meta.DeleteMarker {
+		return "", nil, errDeleteMarker
+	}
+
+	return path, meta, nil
+}
+
+// readBody reads the body file of an object and reverts the encoding it was
+// stored with.
+func (s *Storage) readBody(ctx context.Context, dir string, meta *metadata) ([]byte, error) {
+	path := dir + "/body"
+	if len(meta.Blob) > 0 {
+		path = s.blobPath(meta.Blob)
+	}
+	body, err := readFileCtx(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read data file: %w", err)
+	}
+	if len(meta.Nonce) > 0 {
+		if body, err = decrypt(s.encryption, meta.Nonce, body); err != nil {
+			return nil, err
 		}
 	}
+	if meta.Compressed {
+		if body, err = decompress(body); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+func (s *Storage) Put(bucket, key string, body []byte) error {
+	return s.PutCtx(context.Background(), bucket, key, body)
+}
+
+// PutCtx is like Put but aborts writing the body once ctx is done, in which
+// case nothing of the partial write is left behind.
+func (s *Storage) PutCtx(ctx context.Context, bucket, key string, body []byte) error {
+	return s.PutObject(ctx, bucket, key, body, PutOptions{})
+}
 
-	hash := Sha256Hash([]byte(key))
+// PutIfAbsentCtx is like PutCtx but only creates the object if there is none
+// under the key yet. Otherwise it fails with PreconditionFailed.
+func (s *Storage) PutIfAbsentCtx(ctx context.Context, bucket, key string, body []byte) error {
+	return s.PutObject(ctx, bucket, key, body, PutOptions{IfAbsent: true})
+}
+
+// PutObject is like PutCtx but stores the attributes of the options along
+// with the object.
+func (s *Storage) PutObject(ctx context.Context, bucket, key string, body []byte, opts PutOptions) (err error) {
+	defer func() { err = s.unwritable(ctx, err) }()
+	if err := validStorageClass(opts.StorageClass); err != nil {
+		return err
+	}
 	// create directory namespace so we can store
 	// metadata next to the file content
-	dir := s.path + "/" + bucket + "/" + hash
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	dir, err := s.objectDir(bucket, key)
+	if err != nil {
 		return err
 	}
+	unlock := s.objects.lock(dir)
+	defer unlock()
 
-	meta, err := json.Marshal(&metadata{
-		ContentHash:  Sha256Hash(body),
-		ContentSize:  len(body),
-		OriginalKey:  key,
-		LastModified: time.Now().UTC().Unix(),
+	// the metadata of the current version is read once, it can not change
+	// while the lock is held. It is nil if there is none or it is corrupted.
+	present := exists(dir)
+	current, _ := readMetadata(dir)
+
+	// an object with corrupted metadata still exists
+	if opts.IfAbsent && present && (current == nil || !current.DeleteMarker) {
+		return errObjectExists
+	}
+
+	if err := s.checkObjectSize(bucket, len(body)); err != nil {
+		return err
+	}
+	if err := s.preflight(len(body)); err != nil {
+		return err
+	}
+
+	// only a new key counts towards the maximum number of objects
+	added := addsObject(present, current)
+	if err := s.updateCount(bucket, added, true); err != nil {
+		return err
+	}
+	// whether the current version is locked and how much an overwrite adds
+	// depend on the versioning status, which is read under the same lock the
+	// usage is updated with. An overwrite only accounts for the difference in
+	// size, unless the previous version is retained.
+	var status string
+	delta, err := s.updateUsageFunc(bucket, true, func(config *bucketConfig) (int64, error) {
+		status = config.Versioning
+		if err := checkLock(status, current, opts.BypassGovernance); err != nil {
+			return 0, err
+		}
+		delta := int64(len(body))
+		if current != nil && !retained(status, current) {
+			delta -= int64(current.ContentSize)
+		}
+		return delta, nil
 	})
 	if err != nil {
-		return fmt.Errorf("could not marshal metadata struct: %w", err)
+		s.revertCount(ctx, bucket, added)
+		return err
 	}
-	if err := os.WriteFile(dir+"/metadata.json", meta, 0644); err != nil {
-		return fmt.Errorf("could not write metadata.json: %w", err)
+
+	versionID, err := s.nextVersion(dir, status, current)
+	if err != nil {
+		return err
+	}
+
+	if err := s.write(ctx, dir, key, versionID, body, opts); err != nil {
+		if err := s.updateUsage(bucket, -delta, false); err != nil {
+			log.Println("[ERROR] - " + logPrefix(ctx) + "could not revert bucket usage: " + err.Error())
+		}
+		s.revertCount(ctx, bucket, added)
+		// the archived version becomes the current one again
+		if current != nil && status != "" && retained(status, current) {
+			if err := unarchive(dir, versionOf(current)); err != nil {
+				log.Println("[ERROR] - " + logPrefix(ctx) + "could not restore previous version: " + err.Error())
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// CheckPut runs every check of Put for a body of the given size without
+// storing anything, so clients can find out up front if an upload would be
+// accepted.
+func (s *Storage) CheckPut(bucket, key string, size int) error {
+	dir, err := s.objectDir(bucket, key)
+	if err != nil {
+		return err
+	}
+	status, err := s.versioning(bucket)
+	if err != nil {
+		return err
+	}
+	if err := s.checkObjectSize(bucket, size); err != nil {
+		return err
+	}
+	if err := s.preflight(size); err != nil {
+		return err
+	}
+	if err := s.checkObjectCount(bucket, newObject(dir)); err != nil {
+		return err
+	}
+
+	delta := int64(size)
+	if old, err := readMetadata(dir); err == nil && !retained(status, old) {
+		delta -= int64(old.ContentSize)
+	}
+	return s.checkQuota(bucket, delta)
+}
+
+// write stores the body and metadata of an object. If it fails, a new object
+// leaves no directory behind and an overwritten object keeps its previous
+// metadata and body.
+func (s *Storage) write(ctx context.Context, dir, key, versionID string, body []byte, opts PutOptions) (err error) {
+	if !exists(dir) {
+		if err := mkdirAll(dir, s.dirMode); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				os.RemoveAll(dir)
+			}
+		}()
+	} else if previous, readErr := os.ReadFile(dir + "/metadata.json"); readErr == nil {
+		// the body is moved into place after the metadata is written, so
+		// the metadata has to be reverted if that fails
+		defer func() {
+			if err != nil {
+				if err := writeFileAtomic(dir+"/metadata.json", previous, s.fileMode); err != nil {
+					log.Println("[ERROR] - " + logPrefix(ctx) + "could not restore metadata: " + err.Error())
+				}
+			} else if replaced, err := decodeMetadata(previous); err == nil && len(replaced.Blob) > 0 {
+				s.releaseBlobs(ctx, []string{replaced.Blob})
+			}
+		}()
 	}
 
-	return os.WriteFile(dir+"/body", body, 0644)
+	meta := &metadata{
+		ContentHash: Sha256Hash(body),
+		ContentSize: len(body),
+		ETag:        ETag(body),
+		OriginalKey: key,
+		VersionID:   versionID,
+	}
+	meta.setAttributes(opts)
+	meta.setModified(time.Now())
+	if s.deduplication && s.encryption == nil {
+		return s.writeBlob(ctx, dir, meta, body)
+	}
+	// the metadata always describes the uncompressed body
+	data := body
+	if s.compression {
+		var err error
+		if data, err = compress(body); err != nil {
+			return err
+		}
+		meta.Compressed = true
+	}
+	if s.encryption != nil {
+		var err error
+		if meta.Nonce, data, err = encrypt(s.encryption, data); err != nil {
+			return err
+		}
+	}
+
+	// the body goes into a temporary file with a unique name first, which is
+	// only moved into place once it is complete
+	tmp, err := writeTempCtx(ctx, dir, data, s.fileMode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	if err := s.writeMetadata(dir, meta); err != nil {
+		return err
+	}
+	if err := s.moveBody(tmp, dir+"/body"); err != nil {
+		return fmt.Errorf("could not move body into place: %w", err)
+	}
+	return nil
 }
 
+// Delete removes an object. Like in S3 deleting a key which does not exist
+// succeeds, only a missing bucket is an error.
 func (s *Storage) Delete(bucket, key string) error {
-	if !s.existPath(bucket) {
-		return &Error{
-			msg:    "requested bucket does not exist",
-			Status: http.StatusNotFound,
+	return s.DeleteCtx(context.Background(), bucket, key)
+}
+
+// DeleteCtx is like Delete but does not start deleting once ctx is done.
+func (s *Storage) DeleteCtx(ctx context.Context, bucket, key string) error {
+	return s.DeleteObject(ctx, bucket, key, DeleteOptions{})
+}
+
+// DeleteObject is like DeleteCtx but only deletes the object if it meets the
+// conditions of the options.
+func (s *Storage) DeleteObject(ctx context.Context, bucket, key string, opts DeleteOptions) (err error) {
+	defer func() { err = s.unwritable(ctx, err) }()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	dir, err := s.objectDir(bucket, key)
+	if err != nil {
+		return err
+	}
+	unlock := s.objects.lock(dir)
+	defer unlock()
+	if len(opts.IfMatch) > 0 {
+		meta, err := readMetadata(dir)
+		if !exists(dir) || (err == nil && meta.DeleteMarker) {
+			return errNoSuchKey
+		} else if err != nil {
+			return err
 		}
+		if !meta.matches(opts.IfMatch) {
+			return errPreconditionFailed
+		}
+	}
+	if !exists(dir) {
+		return nil
 	}
 
-	hash := Sha256Hash([]byte(key))
-	if !s.existPath(bucket + "/" + hash) {
-		return &Error{
-			msg:    "object under requested key does not exist",
-			Status: http.StatusNotFound,
+	status, err := s.versioning(bucket)
+	if err != nil {
+		return err
+	}
+	if current, err := readMetadata(dir); err == nil {
+		if err := checkLock(status, current, opts.BypassGovernance); err != nil {
+			return err
+		}
+	}
+	if status != "" {
+		live := newObject(dir) == 0
+		if err := s.deleteVersioned(ctx, bucket, dir, key, status); err != nil {
+			return err
+		}
+		if live {
+			return s.updateCount(bucket, -1, false)
+		}
+		return nil
+	}
+	return s.removeObject(ctx, bucket, dir)
+}
+
+// newObject returns 1 if an upload to the object directory adds an object to
+// the bucket, which is the case unless its latest version is an object. An
+// object with unreadable metadata still exists.
+func newObject(dir string) int64 {
+	current, _ := readMetadata(dir)
+	return addsObject(exists(dir), current)
+}
+
+// addsObject is newObject for an object directory whose metadata was already
+// read, current is nil if it could not be read.
+func addsObject(present bool, current *metadata) int64 {
+	if !present || (current != nil && current.DeleteMarker) {
+		return 1
+	}
+	return 0
+}
+
+// revertCount takes back the objects added to the count of a bucket by a
+// failed upload.
+func (s *Storage) revertCount(ctx context.Context, bucket string, added int64) {
+	if err := s.updateCount(bucket, -added, false); err != nil {
+		log.Println("[ERROR] - " + logPrefix(ctx) + "could not revert object count: " + err.Error())
+	}
+}
+
+// removeObject removes the directory of an object of an unversioned bucket,
+// or moves it into the trash. The caller must hold the lock of the object.
+func (s *Storage) removeObject(ctx context.Context, bucket, dir string) (err error) {
+	var size int64
+	removed := 1 - newObject(dir)
+	if meta, err := readMetadata(dir); err == nil {
+		size = int64(meta.ContentSize)
+	}
+	if s.trashRetention > 0 {
+		err = s.trash(bucket, dir)
+	} else {
+		blobs := blobsIn(dir)
+		if err = os.RemoveAll(dir); err == nil {
+			s.releaseBlobs(ctx, blobs)
 		}
 	}
+	if err != nil {
+		return err
+	}
 
-	return os.RemoveAll(s.path + "/" + bucket + "/" + hash)
+	if err := s.updateCount(bucket, -removed, false); err != nil {
+		return err
+	}
+	return s.updateUsage(bucket, -size, false)
 }
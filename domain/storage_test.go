@@ -1,6 +1,22 @@
 package domain
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
 
 func TestValidName(t *testing.T) {
 	// test cases taken from: https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html#bucket-names
@@ -59,11 +75,46 @@ func TestValidName(t *testing.T) {
 			"192.168.5.4",
 			false,
 		},
+		{
+			"begins with a period",
+			".example",
+			false,
+		},
+		{
+			"ends with a period",
+			"example.",
+			false,
+		},
+		{
+			"contains an empty label",
+			"a..b",
+			false,
+		},
+		{
+			"label ends with hyphen",
+			"example-.com",
+			false,
+		},
+		{
+			"label begins with hyphen",
+			"example.-com",
+			false,
+		},
+		{
+			"consecutive hyphens inside a label",
+			"my--bucket",
+			true,
+		},
+		{
+			"reserved multi-region access point suffix",
+			"example.mrap",
+			false,
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			got := validName(test.input) == nil
+			got := strictName(test.input) == nil
 			want := test.valid
 			if got != want {
 				t.Errorf("got valid: '%t', want valid: '%t'", got, want)
@@ -71,3 +122,1235 @@ func TestValidName(t *testing.T) {
 		})
 	}
 }
+
+func TestNamingPolicy(t *testing.T) {
+	var tests = []struct {
+		name    string
+		input   string
+		strict  bool
+		relaxed bool
+	}{
+		{"aws name", "my-bucket.example", true, true},
+		{"uppercase", "MyBucket", false, true},
+		{"underscore", "my_bucket", false, true},
+		{"short", "ab", false, true},
+		{"long", strings.Repeat("a", 64), false, true},
+		{"too long", strings.Repeat("a", 256), false, false},
+		{"empty", "", false, false},
+		{"slash", "a/b", false, false},
+		{"backslash", "a\\b", false, false},
+		{"parent directory", "..", false, false},
+		{"hidden directory", ".trash", false, false},
+		{"null byte", "bucket\x00", false, false},
+		{"non ascii", "bücket", false, false},
+	}
+
+	strict, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	relaxed, err := NewStorage(t.TempDir(), WithRelaxedNaming())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := strict.validName(test.input) == nil; got != test.strict {
+				t.Errorf("got strict valid: '%t', want strict valid: '%t'", got, test.strict)
+			}
+			if got := relaxed.validName(test.input) == nil; got != test.relaxed {
+				t.Errorf("got relaxed valid: '%t', want relaxed valid: '%t'", got, test.relaxed)
+			}
+		})
+	}
+
+	if err := relaxed.NewBucket("My_Bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	buckets, err := relaxed.ListBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 || buckets[0].Name != "My_Bucket" {
+		t.Errorf("got buckets: '%v', want bucket: 'My_Bucket'", buckets)
+	}
+
+	// names which are invalid under the policy are rejected before the
+	// bucket is looked up
+	if err := strict.Put("MyBucket", "key", []byte("hello")); !hasCode(err, "InvalidBucketName") {
+		t.Errorf("got error: '%v', want code: 'InvalidBucketName'", err)
+	}
+	if err := relaxed.Put("MyBucket", "key", []byte("hello")); !hasCode(err, "NoSuchBucket") {
+		t.Errorf("got error: '%v', want code: 'NoSuchBucket'", err)
+	}
+
+	// a bucket created under the relaxed policy stays reachable once the
+	// storage is strict again, only new buckets have to follow the policy
+	restarted, err := NewStorage(relaxed.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Put("My_Bucket", "key", []byte("hello")); err != nil {
+		t.Errorf("got error: '%v', want error: '<nil>'", err)
+	}
+	if buckets, err := restarted.ListBuckets(); err != nil || len(buckets) != 1 {
+		t.Errorf("got buckets: '%v', error: '%v', want bucket: 'My_Bucket'", buckets, err)
+	}
+	if err := restarted.NewBucket("My_Other", "test-access-key"); !hasCode(err, "InvalidBucketName") {
+		t.Errorf("got error: '%v', want code: 'InvalidBucketName'", err)
+	}
+}
+
+func TestBucketStats(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("stats", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	objects := map[string][]byte{
+		"a.txt": []byte("hello"),
+		"b.txt": []byte("hello world!"),
+		"c.txt": []byte("foo"),
+	}
+	for key, body := range objects {
+		if err := storage.Put("stats", key, body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.Delete("stats", "c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	// an object with unreadable metadata is left out instead of failing
+	if err := storage.Put("stats", "d.txt", []byte("corrupted")); err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := storage.objectDir("stats", "d.txt")
+	if err := os.WriteFile(dir+"/metadata.json", []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	count, size, err := storage.BucketStats("stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("got count: '%d', want count: '%d'", count, 2)
+	}
+	if size != 17 {
+		t.Errorf("got size: '%d', want size: '%d'", size, 17)
+	}
+}
+
+func TestBucketQuota(t *testing.T) {
+	var tests = []struct {
+		name  string
+		quota int64
+		valid bool
+	}{
+		{"under quota", 16, true},
+		{"at quota", 12, true},
+		{"over quota", 11, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage, err := NewStorage(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.NewBucket("quota", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.SetBucketQuota("quota", test.quota); err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.Put("quota", "a.txt", []byte("hello ")); err != nil {
+				t.Fatal(err)
+			}
+
+			err = storage.Put("quota", "b.txt", []byte("world!"))
+			got := err == nil
+			if got != test.valid {
+				t.Errorf("got valid: '%t', want valid: '%t'", got, test.valid)
+			}
+			if domErr, ok := err.(*Error); err != nil && (!ok || domErr.Status != 409) {
+				t.Errorf("got error: '%v', want status: '%d'", err, 409)
+			}
+		})
+	}
+}
+
+func TestBucketQuotaDelete(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("quota", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SetBucketQuota("quota", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("quota", "a.txt", []byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("quota", "a.txt", []byte("9876543210")); err != nil {
+		t.Errorf("overwrite of same size should fit the quota: %v", err)
+	}
+	if err := storage.Put("quota", "b.txt", []byte("x")); err == nil {
+		t.Error("put over the quota should fail")
+	}
+	if err := storage.Delete("quota", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("quota", "b.txt", []byte("x")); err != nil {
+		t.Errorf("delete should free up quota: %v", err)
+	}
+}
+
+func TestBucketMaxObjectSize(t *testing.T) {
+	var tests = []struct {
+		name  string
+		body  string
+		valid bool
+	}{
+		{"within limit", "hello", true},
+		{"at limit", "hello world!", true},
+		{"over limit", "hello world!!", false},
+	}
+
+	disk, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, storage := range map[string]backend{"filesystem": disk, "memory": NewMemStorage()} {
+		if err := storage.NewBucket("thumbnails", "test-access-key"); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.SetBucketMaxObjectSize("thumbnails", 12); err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range tests {
+			t.Run(name+"/"+test.name, func(t *testing.T) {
+				err := storage.Put("thumbnails", "key", []byte(test.body))
+				if got := err == nil; got != test.valid {
+					t.Errorf("got valid: '%t', want valid: '%t' (%v)", got, test.valid, err)
+				}
+				if err != nil && !hasCode(err, "EntityTooLarge") {
+					t.Errorf("got error: '%v', want code: 'EntityTooLarge'", err)
+				}
+			})
+		}
+	}
+}
+
+func TestBucketMaxObjects(t *testing.T) {
+	var tests = []struct {
+		name string
+		call func(s backend) error
+		code string
+	}{
+		{"second object", func(s backend) error {
+			return s.Put("bucket", "b", []byte("hello"))
+		}, ""},
+		{"over limit", func(s backend) error {
+			return s.Put("bucket", "c", []byte("hello"))
+		}, "TooManyObjects"},
+		{"overwrite", func(s backend) error {
+			return s.Put("bucket", "a", []byte("hello world!"))
+		}, ""},
+		{"delete frees capacity", func(s backend) error {
+			if err := s.Delete("bucket", "b"); err != nil {
+				return err
+			}
+			return s.Put("bucket", "c", []byte("hello"))
+		}, ""},
+		{"delete missing key", func(s backend) error {
+			if err := s.Delete("bucket", "missing"); err != nil {
+				return err
+			}
+			return s.Put("bucket", "d", []byte("hello"))
+		}, "TooManyObjects"},
+		{"delete marker frees capacity", func(s backend) error {
+			if err := s.SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+				return err
+			}
+			if err := s.Delete("bucket", "a"); err != nil {
+				return err
+			}
+			return s.Put("bucket", "d", []byte("hello"))
+		}, ""},
+		{"put over delete marker", func(s backend) error {
+			return s.Put("bucket", "a", []byte("hello"))
+		}, "TooManyObjects"},
+		{"new version", func(s backend) error {
+			return s.Put("bucket", "d", []byte("hello world!"))
+		}, ""},
+		{"raised limit", func(s backend) error {
+			if err := s.SetBucketMaxObjects("bucket", 3); err != nil {
+				return err
+			}
+			return s.Put("bucket", "a", []byte("hello"))
+		}, ""},
+		{"negative limit", func(s backend) error {
+			return s.SetBucketMaxObjects("bucket", -1)
+		}, "InvalidArgument"},
+	}
+
+	disk, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range map[string]backend{"filesystem": disk, "memory": NewMemStorage()} {
+		t.Run(name, func(t *testing.T) {
+			if err := s.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			// objects stored before the limit count towards it
+			if err := s.Put("bucket", "a", []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SetBucketMaxObjects("bucket", 2); err != nil {
+				t.Fatal(err)
+			}
+			// the steps build on each other, so they run in order
+			for _, test := range tests {
+				err := test.call(s)
+				if test.code == "" && err != nil {
+					t.Errorf("%s: got error: '%v', want error: '<nil>'", test.name, err)
+				} else if test.code != "" && !hasCode(err, test.code) {
+					t.Errorf("%s: got error: '%v', want code: '%s'", test.name, err, test.code)
+				}
+			}
+		})
+	}
+}
+
+// TestBucketMaxObjectsRecount checks that the limit holds for buckets whose
+// bucket.json did not keep track of the number of objects yet.
+func TestBucketMaxObjectsRecount(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err := storage.Put("bucket", key, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storage.mu.Lock()
+	config, err := storage.readBucketConfig("bucket")
+	if err == nil {
+		config.ObjectCount = 0
+		err = storage.writeBucketConfig("bucket", config)
+	}
+	storage.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.SetBucketMaxObjects("bucket", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("bucket", "c", []byte("hello")); !hasCode(err, "TooManyObjects") {
+		t.Errorf("got error: '%v', want code: 'TooManyObjects'", err)
+	}
+	if err := storage.CheckPut("bucket", "c", 5); !hasCode(err, "TooManyObjects") {
+		t.Errorf("got error: '%v', want code: 'TooManyObjects'", err)
+	}
+	if err := storage.CheckPut("bucket", "a", 5); err != nil {
+		t.Errorf("got error: '%v', want error: '<nil>'", err)
+	}
+}
+
+// TestLegacyBucketConfig checks that the totals of a bucket created before
+// bucket.json existed are only computed once.
+func TestLegacyBucketConfig(t *testing.T) {
+	path := t.TempDir()
+	storage, err := NewStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("bucket", "key", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path + "/bucket/bucket.json"); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path+"/bucket", created, created); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Put("bucket", "other", []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	config := &bucketConfig{}
+	if ok, err := storage.readBucketFile("bucket", "bucket.json", config); !ok || err != nil {
+		t.Fatalf("got ok: '%t', error: '%v', want bucket.json to be written", ok, err)
+	}
+	if config.ObjectCount != 2 || config.UsedBytes != 7 {
+		t.Errorf("got count: '%d', used: '%d', want count: '2', used: '7'", config.ObjectCount, config.UsedBytes)
+	}
+	info, err := storage.Bucket("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.CreatedAt.Equal(created) {
+		t.Errorf("got created at: '%v', want created at: '%v'", info.CreatedAt, created)
+	}
+}
+
+func TestDeleteMissing(t *testing.T) {
+	var tests = []struct {
+		name   string
+		bucket string
+		code   string
+	}{
+		{"missing key", "bucket", ""},
+		{"missing bucket", "missing", "NoSuchBucket"},
+	}
+
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := storage.Delete(test.bucket, "missing")
+			if len(test.code) < 1 {
+				if err != nil {
+					t.Errorf("got error: '%v', want error: '<nil>'", err)
+				}
+				return
+			}
+			if domErr, ok := err.(*Error); !ok || domErr.Code != test.code {
+				t.Errorf("got error: '%v', want code: '%s'", err, test.code)
+			}
+		})
+	}
+}
+
+func TestListBuckets(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"bucket-b", "bucket-a"} {
+		if err := storage.NewBucket(name, "test-access-key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buckets, err := storage.ListBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 2 {
+		t.Fatalf("got buckets: '%d', want buckets: '%d'", len(buckets), 2)
+	}
+	if buckets[0].Name != "bucket-a" || buckets[1].Name != "bucket-b" {
+		t.Errorf("got order: '%s, %s', want order: 'bucket-a, bucket-b'", buckets[0].Name, buckets[1].Name)
+	}
+
+	// a bucket which can not be read does not hide the others
+	dir, err := storage.bucketDir("bucket-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/bucket.json", []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	buckets, err = storage.ListBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 || buckets[0].Name != "bucket-b" {
+		t.Errorf("got buckets: '%v', want bucket: 'bucket-b'", buckets)
+	}
+}
+
+func TestPathTraversal(t *testing.T) {
+	var tests = []struct {
+		name   string
+		bucket string
+		key    string
+	}{
+		{"bucket with parent elements", "../../etc", "passwd"},
+		{"bucket with absolute path", "/etc", "passwd"},
+		{"bucket with null byte", "bucket\x00", "passwd"},
+		{"key with parent elements", "bucket", "../../etc/passwd"},
+		{"key with absolute path", "bucket", "/etc/passwd"},
+		{"key with null byte", "bucket", "passwd\x00"},
+		{"key with backslashes", "bucket", "..\\..\\etc\\passwd"},
+	}
+
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := []error{
+				storage.Put(test.bucket, test.key, []byte("hello world!")),
+				storage.Delete(test.bucket, test.key),
+			}
+			_, err := storage.Get(test.bucket, test.key)
+			errs = append(errs, err)
+
+			for _, err := range errs {
+				domErr, ok := err.(*Error)
+				if !ok || domErr.Status != 400 {
+					t.Errorf("got error: '%v', want status: '%d'", err, 400)
+				}
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	var tests = []struct {
+		name  string
+		elems []string
+		valid bool
+	}{
+		{"bucket", []string{"bucket"}, true},
+		{"nested", []string{"bucket", "object"}, true},
+		{"parent", []string{".."}, false},
+		{"parent in the middle", []string{"bucket", "..", ".."}, false},
+		{"absolute", []string{"/etc/passwd"}, false},
+		{"null byte", []string{"bucket\x00"}, false},
+	}
+
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := storage.resolve(test.elems...)
+			got := err == nil
+			if got != test.valid {
+				t.Errorf("got valid: '%t', want valid: '%t'", got, test.valid)
+			}
+		})
+	}
+}
+
+func TestCaseInsensitiveKeys(t *testing.T) {
+	var tests = []struct {
+		name  string
+		opts  []StorageOption
+		found bool
+	}{
+		{"case sensitive", nil, false},
+		{"case insensitive", []StorageOption{WithCaseInsensitiveKeys()}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage, err := NewStorage(t.TempDir(), test.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.Put("bucket", "Foo", []byte("hello world!")); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = storage.Get("bucket", "foo")
+			got := err == nil
+			if got != test.found {
+				t.Errorf("got found: '%t', want found: '%t'", got, test.found)
+			}
+		})
+	}
+}
+
+func TestNFCKeys(t *testing.T) {
+	nfc := "caf\u00e9.txt"
+	nfd := "cafe\u0301.txt"
+	var tests = []struct {
+		name  string
+		opts  []StorageOption
+		found bool
+	}{
+		{"not normalized", nil, false},
+		{"normalized", []StorageOption{WithNFCKeys()}, true},
+		{"normalized case insensitive", []StorageOption{WithNFCKeys(), WithCaseInsensitiveKeys()}, true},
+	}
+
+	for _, test := range tests {
+		disk, err := NewStorage(t.TempDir(), test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, storage := range map[string]backend{"filesystem": disk, "memory": NewMemStorage(test.opts...)} {
+			t.Run(test.name+"/"+name, func(t *testing.T) {
+				if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+					t.Fatal(err)
+				}
+				if err := storage.Put("bucket", nfd, []byte("hello world!")); err != nil {
+					t.Fatal(err)
+				}
+
+				_, err := storage.GetVersion("bucket", nfc, "")
+				if got := err == nil; got != test.found {
+					t.Errorf("got found: '%t', want found: '%t'", got, test.found)
+				}
+				if !test.found {
+					return
+				}
+				if err := storage.Delete("bucket", nfc); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := storage.GetVersion("bucket", nfd, ""); !hasCode(err, "NoSuchKey") {
+					t.Errorf("got error: '%v', want code: 'NoSuchKey'", err)
+				}
+			})
+		}
+	}
+}
+
+func TestNFCKeysInvalidUTF8(t *testing.T) {
+	storage, err := NewStorage(t.TempDir(), WithNFCKeys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("bucket", "caf\xe9", []byte("hello world!")); !hasCode(err, "InvalidArgument") {
+		t.Errorf("got error: '%v', want code: 'InvalidArgument'", err)
+	}
+}
+
+func TestCorruptedMetadata(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	body := []byte("hello world!")
+	if err := storage.Put("bucket", "key", body); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := storage.objectDir("bucket", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir+"/metadata.json", []byte(`{"content_sha256": "ab`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	_, err = storage.GetCtx(WithRequestID(context.Background(), "REQUEST-ID"), "bucket", "key")
+	domErr, ok := err.(*Error)
+	if !ok || domErr.Code != "CorruptedMetadata" {
+		t.Fatalf("got error: '%v', want code: 'CorruptedMetadata'", err)
+	}
+	// the error is logged with the ID of the request it happened in
+	if !strings.Contains(logs.String(), "request REQUEST-ID: corrupted metadata") {
+		t.Errorf("got log: '%s', want log with request id: 'REQUEST-ID'", logs.String())
+	}
+
+	if err := storage.Repair("bucket", "key"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := storage.Get("bucket", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(body) {
+		t.Errorf("got body: '%s', want body: '%s'", got, body)
+	}
+}
+
+func TestNewBucketConfig(t *testing.T) {
+	path := t.TempDir()
+	storage, err := NewStorage(path, WithRegion("eu-central-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().UTC()
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path + "/bucket/bucket.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &bucketConfig{}
+	if err := json.Unmarshal(b, config); err != nil {
+		t.Fatal(err)
+	}
+	if config.OwnerAccessKey != "test-access-key" {
+		t.Errorf("got owner: '%s', want owner: '%s'", config.OwnerAccessKey, "test-access-key")
+	}
+	if config.Region != "eu-central-1" {
+		t.Errorf("got region: '%s', want region: '%s'", config.Region, "eu-central-1")
+	}
+	if config.CreatedAt.Before(before.Truncate(time.Second)) || config.CreatedAt.After(time.Now()) {
+		t.Errorf("got created at: '%s', want a time after '%s'", config.CreatedAt, before)
+	}
+
+	info, err := storage.Bucket("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.CreatedAt.Equal(config.CreatedAt) {
+		t.Errorf("got created at: '%s', want created at: '%s'", info.CreatedAt, config.CreatedAt)
+	}
+}
+
+func TestNewBucketExisting(t *testing.T) {
+	var tests = []struct {
+		name  string
+		owner string
+		code  string
+	}{
+		{"same owner", "test-access-key", "BucketAlreadyOwnedByYou"},
+		{"different owner", "other-access-key", "BucketAlreadyExists"},
+	}
+
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := storage.NewBucket("bucket", test.owner)
+			domErr, ok := err.(*Error)
+			if !ok || domErr.Code != test.code || domErr.Status != 409 {
+				t.Errorf("got error: '%v', want code: '%s'", err, test.code)
+			}
+		})
+	}
+}
+
+func TestETag(t *testing.T) {
+	var tests = []struct {
+		name string
+		body []byte
+	}{
+		{"empty", []byte{}},
+		{"text", []byte("hello world")},
+		{"binary", []byte{0x00, 0xff, 0x10, 0x80}},
+	}
+
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sum := md5.Sum(test.body)
+			want := `"` + hex.EncodeToString(sum[:]) + `"`
+
+			if err := storage.Put("bucket", test.name, test.body); err != nil {
+				t.Fatal(err)
+			}
+			info, err := storage.Head("bucket", test.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.ETag != want {
+				t.Errorf("got head etag: '%s', want head etag: '%s'", info.ETag, want)
+			}
+			_, info, err = storage.GetVersionCtx(context.Background(), "bucket", test.name, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.ETag != want {
+				t.Errorf("got get etag: '%s', want get etag: '%s'", info.ETag, want)
+			}
+		})
+	}
+}
+
+func TestZeroByteObject(t *testing.T) {
+	var tests = []struct {
+		name string
+		opts []StorageOption
+	}{
+		{"plain", nil},
+		{"compression", []StorageOption{WithCompression()}},
+		{"encryption", []StorageOption{WithEncryption("test-master-key")}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage, err := NewStorage(t.TempDir(), test.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer storage.Close()
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.Put("bucket", "empty", []byte{}); err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := storage.Head("bucket", "empty")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// sha256 of the empty string
+			want := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+			if info.Size != 0 || info.ContentHash != want {
+				t.Errorf("got size: '%d' hash: '%s', want size: '0' hash: '%s'", info.Size, info.ContentHash, want)
+			}
+
+			body, err := storage.Get("bucket", "empty")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(body) != 0 {
+				t.Errorf("got body length: '%d', want body length: '0'", len(body))
+			}
+
+			if err := storage.Delete("bucket", "empty"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := storage.Head("bucket", "empty"); err == nil {
+				t.Error("got error: '<nil>', want error after delete")
+			}
+		})
+	}
+}
+
+func TestLastModifiedPrecision(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	times := []time.Time{}
+	for _, body := range []string{"first", "second"} {
+		if err := storage.Put("bucket", "key", []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+		info, err := storage.Head("bucket", "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		times = append(times, info.LastModified)
+	}
+	if !times[1].After(times[0]) {
+		t.Errorf("got modified times: '%v', want distinct increasing times", times)
+	}
+
+	// metadata written with second precision is still understood
+	dir, err := storage.objectDir("bucket", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := readMetadata(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.ModifiedAt = time.Time{}
+	meta.LastModified = 1700000000
+	if err := storage.writeMetadata(dir, meta); err != nil {
+		t.Fatal(err)
+	}
+	info, err := storage.Head("bucket", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Unix(1700000000, 0).UTC(); !info.LastModified.Equal(want) {
+		t.Errorf("got modified time: '%v', want modified time: '%v'", info.LastModified, want)
+	}
+}
+
+func TestPutIfAbsent(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := []string{"first", "second"}
+	errs := make([]error, len(bodies))
+	var wg sync.WaitGroup
+	for i, body := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = storage.PutIfAbsentCtx(context.Background(), "bucket", "key", []byte(body))
+		}()
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errs {
+		if err == nil {
+			winner = i
+			continue
+		}
+		domErr, ok := err.(*Error)
+		if !ok || domErr.Code != "PreconditionFailed" {
+			t.Errorf("got error: '%v', want code: 'PreconditionFailed'", err)
+		}
+	}
+	if winner < 0 || errs[1-winner] == nil {
+		t.Fatalf("got errors: '%v', want exactly one success", errs)
+	}
+	got, err := storage.Get("bucket", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != bodies[winner] {
+		t.Errorf("got body: '%s', want body: '%s'", got, bodies[winner])
+	}
+
+	// a deleted object frees the key again
+	if err := storage.Delete("bucket", "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.PutIfAbsentCtx(context.Background(), "bucket", "key", []byte("third")); err != nil {
+		t.Errorf("got error: '%v', want error: '<nil>'", err)
+	}
+}
+
+func TestUnwritable(t *testing.T) {
+	var tests = []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"read-only filesystem", &os.PathError{Op: "open", Path: "body", Err: syscall.EROFS}, http.StatusServiceUnavailable},
+		{"permission denied", &os.PathError{Op: "mkdir", Path: "bucket", Err: syscall.EACCES}, http.StatusServiceUnavailable},
+		{"other error", errors.New("disk on fire"), 0},
+		{"domain error", &Error{Code: "NoSuchKey", Status: http.StatusNotFound}, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status := 0
+			if domErr, ok := (&Storage{}).unwritable(context.Background(), test.err).(*Error); ok {
+				status = domErr.Status
+			}
+			if status != test.status {
+				t.Errorf("got status: '%d', want status: '%d'", status, test.status)
+			}
+		})
+	}
+}
+
+func TestUnwritableLog(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	storage := &Storage{}
+	erofs := &os.PathError{Op: "open", Path: "body", Err: syscall.EROFS}
+	// only the flips between writable and unwritable are logged
+	for _, err := range []error{erofs, erofs, errors.New("disk on fire"), erofs, nil, nil, erofs} {
+		storage.unwritable(context.Background(), err)
+	}
+	if got := strings.Count(logs.String(), "data directory is not writable"); got != 2 {
+		t.Errorf("got unwritable logs: '%d', want unwritable logs: '%d'", got, 2)
+	}
+	if got := strings.Count(logs.String(), "data directory is writable again"); got != 1 {
+		t.Errorf("got writable logs: '%d', want writable logs: '%d'", got, 1)
+	}
+}
+
+func TestReadOnlyStorage(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	path := t.TempDir()
+	storage, err := NewStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path+"/bucket", 0555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(path+"/bucket", 0755)
+
+	err = storage.Put("bucket", "key", []byte("hello world!"))
+	domErr, ok := err.(*Error)
+	if !ok || domErr.Status != http.StatusServiceUnavailable {
+		t.Errorf("got error: '%v', want status: '%d'", err, http.StatusServiceUnavailable)
+	}
+}
+
+func TestKeyLength(t *testing.T) {
+	var tests = []struct {
+		name string
+		opts []StorageOption
+		key  string
+		code string
+	}{
+		{"default limit", nil, strings.Repeat("a", 1024), ""},
+		{"over default limit", nil, strings.Repeat("a", 1025), "KeyTooLongError"},
+		// "ü" is two bytes in UTF-8, so 513 of them exceed the limit
+		{"multibyte at limit", nil, strings.Repeat("ü", 512), ""},
+		{"multibyte over limit", nil, strings.Repeat("ü", 513), "KeyTooLongError"},
+		{"configured limit", []StorageOption{WithMaxKeyLength(8)}, "12345678", ""},
+		{"over configured limit", []StorageOption{WithMaxKeyLength(8)}, "123456789", "KeyTooLongError"},
+	}
+
+	for _, test := range tests {
+		disk, err := NewStorage(t.TempDir(), test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, s := range map[string]backend{"filesystem": disk, "memory": NewMemStorage(test.opts...)} {
+			t.Run(test.name+"/"+name, func(t *testing.T) {
+				if err := s.NewBucket("bucket", "test-access-key"); err != nil {
+					t.Fatal(err)
+				}
+				calls := map[string]func() error{
+					"put": func() error { return s.Put("bucket", test.key, []byte("hello")) },
+					"get": func() error {
+						_, err := s.GetVersion("bucket", test.key, "")
+						return err
+					},
+					"delete": func() error { return s.Delete("bucket", test.key) },
+				}
+				for _, call := range []string{"put", "get", "delete"} {
+					err := calls[call]()
+					if len(test.code) < 1 && err != nil {
+						t.Errorf("%s: got error: '%v', want error: 'nil'", call, err)
+					} else if len(test.code) > 0 && !hasCode(err, test.code) {
+						t.Errorf("%s: got error: '%v', want code: '%s'", call, err, test.code)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestPutBodyFailure(t *testing.T) {
+	var tests = []struct {
+		name       string
+		versioning string
+		existing   string // body stored before the failing put, if any
+	}{
+		{"new object", "", ""},
+		{"overwrite", "", "before"},
+		{"overwrite versioned", VersioningEnabled, "before"},
+	}
+
+	errDisk := errors.New("disk failure")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage, err := NewStorage(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			if len(test.versioning) > 0 {
+				if err := storage.SetBucketVersioning("bucket", test.versioning); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(test.existing) > 0 {
+				if err := storage.Put("bucket", "key", []byte(test.existing)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			storage.moveBody = func(string, string) error { return errDisk }
+			if err := storage.Put("bucket", "key", []byte("after")); !errors.Is(err, errDisk) {
+				t.Errorf("got error: '%v', want error: '%v'", err, errDisk)
+			}
+			storage.moveBody = os.Rename
+
+			dir, err := storage.objectDir("bucket", "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(test.existing) < 1 {
+				if exists(dir) {
+					t.Error("failed put left the object directory behind")
+				}
+			} else {
+				got, err := storage.Get("bucket", "key")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != test.existing {
+					t.Errorf("got body: '%s', want body: '%s'", got, test.existing)
+				}
+			}
+
+			entries, err := os.ReadDir(filepath.Dir(dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				if strings.HasPrefix(entry.Name(), ".") {
+					t.Errorf("got leftover temporary file: '%s', want none", entry.Name())
+				}
+			}
+			_, size, err := storage.BucketStats("bucket")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != int64(len(test.existing)) {
+				t.Errorf("got size: '%d', want size: '%d'", size, len(test.existing))
+			}
+		})
+	}
+}
+
+func TestDeleteIfMatch(t *testing.T) {
+	body := []byte("hello world!")
+	var tests = []struct {
+		name    string
+		key     string
+		ifMatch string
+		code    string // empty if the delete succeeds
+		deleted bool
+	}{
+		{"matching etag", "key", ETag(body), "", true},
+		{"matching unquoted etag", "key", strings.Trim(ETag(body), `"`), "", true},
+		{"matching content hash", "key", `"` + Sha256Hash(body) + `"`, "", true},
+		{"matching one of many", "key", `"0123", ` + ETag(body), "", true},
+		{"wildcard", "key", "*", "", true},
+		{"not matching", "key", ETag([]byte("changed")), "PreconditionFailed", false},
+		{"missing object", "missing", ETag(body), "NoSuchKey", false},
+	}
+
+	type conditionalDeleter interface {
+		backend
+		DeleteObject(ctx context.Context, bucket, key string, opts DeleteOptions) error
+	}
+	for _, test := range tests {
+		disk, err := NewStorage(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, s := range map[string]conditionalDeleter{"filesystem": disk, "memory": NewMemStorage()} {
+			t.Run(test.name+"/"+name, func(t *testing.T) {
+				if err := s.NewBucket("bucket", "test-access-key"); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.Put("bucket", "key", body); err != nil {
+					t.Fatal(err)
+				}
+
+				err := s.DeleteObject(context.Background(), "bucket", test.key, DeleteOptions{IfMatch: test.ifMatch})
+				if len(test.code) < 1 && err != nil {
+					t.Errorf("got error: '%v', want error: 'nil'", err)
+				} else if len(test.code) > 0 && !hasCode(err, test.code) {
+					t.Errorf("got error: '%v', want code: '%s'", err, test.code)
+				}
+				_, err = s.GetVersion("bucket", "key", "")
+				if deleted := hasCode(err, "NoSuchKey"); deleted != test.deleted {
+					t.Errorf("got deleted: '%t', want deleted: '%t'", deleted, test.deleted)
+				}
+			})
+		}
+	}
+}
+
+func TestFileModes(t *testing.T) {
+	var tests = []struct {
+		name string
+		opts []StorageOption
+		file os.FileMode
+		dir  os.FileMode
+	}{
+		{"default", nil, 0644, 0755},
+		{"configured", []StorageOption{WithFileModes(0640, 0750)}, 0640, 0750},
+	}
+
+	// the umask is restrictive enough to strip the permissions of group and
+	// others from everything created without an explicit chmod
+	old := syscall.Umask(0077)
+	defer syscall.Umask(old)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := t.TempDir()
+			storage, err := NewStorage(path, append(test.opts, WithFanOut(2))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+				t.Fatal(err)
+			}
+			for _, body := range []string{"first", "second"} {
+				if err := storage.Put("bucket", "key", []byte(body)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+				if err != nil || p == path {
+					return err
+				}
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				want := test.file
+				if d.IsDir() {
+					want = test.dir
+				}
+				if got := info.Mode().Perm(); got != want {
+					t.Errorf("got mode: '%o' of '%s', want mode: '%o'", got, p, want)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
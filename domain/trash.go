@@ -0,0 +1,172 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	trashDir      = ".trash"
+	sweepInterval = time.Minute
+)
+
+// WithSoftDelete moves deleted objects into the trash of their bucket instead
+// of removing them. Trashed objects can be restored until they are older than
+// the retention, after which a background sweeper removes them permanently.
+func WithSoftDelete(retention time.Duration) StorageOption {
+	return func(s *Storage) {
+		s.trashRetention = retention
+	}
+}
+
+// trash moves an object directory into .trash/<timestamp>/ of its bucket.
+func (s *Storage) trash(bucket, dir string) error {
+	bucketDir, err := s.bucketDir(bucket)
+	if err != nil {
+		return err
+	}
+
+	dst := filepath.Join(bucketDir, trashDir, strconv.FormatInt(time.Now().UnixNano(), 10))
+	if err := mkdirAll(dst, s.dirMode); err != nil {
+		return err
+	}
+	if err := os.Rename(dir, filepath.Join(dst, filepath.Base(dir))); err != nil {
+		return fmt.Errorf("could not move object into trash: %w", err)
+	}
+	return nil
+}
+
+// Restore recovers the most recently deleted object under a key from the
+// trash of its bucket.
+func (s *Storage) Restore(bucket, key string) error {
+	dir, err := s.objectDir(bucket, key)
+	if err != nil {
+		return err
+	}
+	unlock := s.objects.lock(dir)
+	defer unlock()
+	if exists(dir) {
+		return &Error{
+			msg:    "an object under the requested key exists already",
+			Code:   "ObjectAlreadyExists",
+			Status: http.StatusConflict,
+		}
+	}
+
+	bucketDir, err := s.bucketDir(bucket)
+	if err != nil {
+		return err
+	}
+	trash := filepath.Join(bucketDir, trashDir)
+	entries, err := os.ReadDir(trash)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not read trash directory: %w", err)
+	}
+	// the timestamps have the same amount of digits, so the newest is last
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() > entries[j].Name()
+	})
+	for _, entry := range entries {
+		src := filepath.Join(trash, entry.Name(), filepath.Base(dir))
+		if !exists(src) {
+			continue
+		}
+
+		meta, err := readMetadata(src)
+		if err != nil {
+			return err
+		}
+		size := int64(meta.ContentSize)
+		if err := s.updateCount(bucket, 1, true); err != nil {
+			return err
+		}
+		if err := s.updateUsage(bucket, size, true); err != nil {
+			s.revertCount(context.Background(), bucket, 1)
+			return err
+		}
+		if err := s.moveFromTrash(src, dir); err != nil {
+			if err := s.updateUsage(bucket, -size, false); err != nil {
+				log.Println("[ERROR] - could not revert bucket usage: " + err.Error())
+			}
+			s.revertCount(context.Background(), bucket, 1)
+			return err
+		}
+		// only removes the timestamp directory if it is empty now
+		os.Remove(filepath.Join(trash, entry.Name()))
+		return nil
+	}
+
+	return &Error{
+		msg:    "no deleted object under the requested key in the trash",
+		Code:   "NoSuchKey",
+		Status: http.StatusNotFound,
+	}
+}
+
+// moveFromTrash moves a trashed object directory back to where it belongs.
+func (s *Storage) moveFromTrash(src, dir string) error {
+	if err := mkdirAll(filepath.Dir(dir), s.dirMode); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dir); err != nil {
+		return fmt.Errorf("could not restore object from trash: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) sweeper() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			if err := s.sweep(now); err != nil {
+				log.Println("[ERROR] - could not sweep trash: " + err.Error())
+			}
+		}
+	}
+}
+
+// sweep permanently removes everything from the trash of all buckets which
+// was deleted longer than the retention before now.
+func (s *Storage) sweep(now time.Time) error {
+	buckets, err := s.ListBuckets()
+	if err != nil {
+		return err
+	}
+
+	cutoff := now.Add(-s.trashRetention).UnixNano()
+	for _, b := range buckets {
+		trash := filepath.Join(s.path, b.Name, trashDir)
+		entries, err := os.ReadDir(trash)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("could not read trash directory: %w", err)
+		}
+
+		for _, entry := range entries {
+			deleted, err := strconv.ParseInt(entry.Name(), 10, 64)
+			if err != nil || deleted > cutoff {
+				continue
+			}
+			blobs := blobsIn(filepath.Join(trash, entry.Name()))
+			if err := os.RemoveAll(filepath.Join(trash, entry.Name())); err != nil {
+				return err
+			}
+			s.releaseBlobs(context.Background(), blobs)
+		}
+	}
+	return nil
+}
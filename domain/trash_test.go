@@ -0,0 +1,115 @@
+package domain
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSoftDeleteRestore(t *testing.T) {
+	storage, err := NewStorage(t.TempDir(), WithSoftDelete(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("bucket", "key", []byte("hello world!")); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete("bucket", "key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Get("bucket", "key"); err == nil {
+		t.Fatal("deleted object should not be readable")
+	}
+
+	if err := storage.Restore("bucket", "key"); err != nil {
+		t.Fatal(err)
+	}
+	body, err := storage.Get("bucket", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "hello world!" {
+		t.Errorf("got body: '%s', want body: '%s'", body, "hello world!")
+	}
+}
+
+func TestSoftDeleteExpire(t *testing.T) {
+	storage, err := NewStorage(t.TempDir(), WithSoftDelete(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("bucket", "key", []byte("hello world!")); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete("bucket", "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing is old enough to be swept yet
+	if err := storage.sweep(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	count, _, err := storage.BucketStats("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("got count: '%d', want count: '%d'", count, 0)
+	}
+
+	if err := storage.sweep(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	err = storage.Restore("bucket", "key")
+	if domErr, ok := err.(*Error); !ok || domErr.Code != "NoSuchKey" {
+		t.Errorf("got error: '%v', want code: 'NoSuchKey'", err)
+	}
+}
+
+func TestRestoreFailureRevertsTotals(t *testing.T) {
+	storage, err := NewStorage(t.TempDir(), WithSoftDelete(time.Hour), WithFanOut(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("bucket", "key", []byte("hello world!")); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete("bucket", "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file in place of the shard directory makes moving the object back fail
+	dir, _ := storage.objectDir("bucket", "key")
+	if err := os.RemoveAll(filepath.Dir(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Dir(dir), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Restore("bucket", "key"); err == nil {
+		t.Fatal("got error: '<nil>', want restore to fail")
+	}
+
+	storage.mu.Lock()
+	config, err := storage.readBucketConfig("bucket")
+	storage.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ObjectCount != 0 || config.UsedBytes != 0 {
+		t.Errorf("got count: '%d', used: '%d', want count: '0', used: '0'", config.ObjectCount, config.UsedBytes)
+	}
+}
@@ -0,0 +1,272 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	VersioningEnabled   = "Enabled"
+	VersioningSuspended = "Suspended"
+
+	// nullVersion is the version ID of objects written while versioning was
+	// never enabled or is suspended
+	nullVersion = "null"
+)
+
+func validVersioning(status string) error {
+	if status != VersioningEnabled && status != VersioningSuspended {
+		return &Error{
+			msg:    "versioning status must be either Enabled or Suspended",
+			Code:   "IllegalVersioningConfigurationException",
+			Status: http.StatusBadRequest,
+		}
+	}
+	return nil
+}
+
+// SetBucketVersioning enables or suspends versioning of a bucket. Once
+// enabled, versioning can only be suspended but never be turned off again.
+func (s *Storage) SetBucketVersioning(name, status string) error {
+	if err := validVersioning(status); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, err := s.readBucketConfig(name)
+	if err != nil {
+		return err
+	}
+	config.Versioning = status
+	return s.writeBucketConfig(name, config)
+}
+
+// BucketVersioning returns the versioning status of a bucket, which is empty
+// if versioning was never enabled.
+func (s *Storage) BucketVersioning(name string) (string, error) {
+	return s.versioning(name)
+}
+
+func (s *Storage) versioning(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, err := s.readBucketConfig(name)
+	if err != nil {
+		return "", err
+	}
+	return config.Versioning, nil
+}
+
+// newVersionID returns an ID which sorts lexically by creation time.
+func newVersionID() string {
+	nonce := make([]byte, 4)
+	rand.Read(nonce)
+	return fmt.Sprintf("%019d-%s", time.Now().UnixNano(), hex.EncodeToString(nonce))
+}
+
+// retained reports whether the current version of an object is kept when it
+// gets overwritten under the given versioning status.
+func retained(status string, current *metadata) bool {
+	switch status {
+	case VersioningEnabled:
+		return true
+	case VersioningSuspended:
+		return current.VersionID != "" && current.VersionID != nullVersion
+	}
+	return false
+}
+
+func versionDir(dir, versionID string) (string, error) {
+	if err := safePath(versionID); err != nil {
+		return "", err
+	}
+	// the latest version is not archived yet
+	if meta, err := readMetadata(dir); err == nil && versionOf(meta) == versionID {
+		return dir, nil
+	}
+
+	path := filepath.Join(dir, "versions", versionID)
+	if strings.ContainsAny(versionID, "/\\") || !exists(path) {
+		return "", errNoSuchVersion
+	}
+	return path, nil
+}
+
+func versionOf(meta *metadata) string {
+	if meta.VersionID == "" {
+		return nullVersion
+	}
+	return meta.VersionID
+}
+
+// nextVersion moves the current version of an object into the versions
+// directory if it has to be retained and returns the version ID for the
+// version which replaces it. current is the metadata of the current version,
+// nil if there is none or it can not be read.
+func (s *Storage) nextVersion(dir, status string, current *metadata) (string, error) {
+	if status == "" {
+		return "", nil
+	}
+
+	if current != nil && retained(status, current) {
+		archive := filepath.Join(dir, "versions", versionOf(current))
+		if err := mkdirAll(archive, s.dirMode); err != nil {
+			return "", err
+		}
+		if err := os.Rename(dir+"/metadata.json", archive+"/metadata.json"); err != nil {
+			return "", fmt.Errorf("could not archive metadata.json: %w", err)
+		}
+		// delete markers have no body
+		if err := os.Rename(dir+"/body", archive+"/body"); err != nil && !os.IsNotExist(err) {
+			// the current version stays in place as it was
+			if err := unarchive(dir, versionOf(current)); err != nil {
+				log.Println("[ERROR] - could not restore metadata.json: " + err.Error())
+			}
+			return "", fmt.Errorf("could not archive body: %w", err)
+		}
+	}
+
+	if status == VersioningSuspended {
+		return nullVersion, nil
+	}
+	return newVersionID(), nil
+}
+
+// unarchive moves an archived version back into place as the current version
+// of an object, it reverts nextVersion.
+func unarchive(dir, versionID string) error {
+	archive := filepath.Join(dir, "versions", versionID)
+	if err := os.Rename(archive+"/metadata.json", dir+"/metadata.json"); err != nil {
+		return fmt.Errorf("could not restore metadata.json: %w", err)
+	}
+	if err := os.Rename(archive+"/body", dir+"/body"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not restore body: %w", err)
+	}
+	return os.Remove(archive)
+}
+
+// deleteVersioned replaces the current version of an object with a delete
+// marker instead of removing any data. Only a current version which is not
+// retained, i.e. the null version of a suspended bucket, is dropped and no
+// longer counts towards the usage of the bucket.
+func (s *Storage) deleteVersioned(ctx context.Context, bucket, dir, key, status string) error {
+	current, _ := readMetadata(dir)
+	versionID, err := s.nextVersion(dir, status, current)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(dir + "/body"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// the current version is still in place unless it was archived
+	var replaced *metadata
+	if current != nil && !retained(status, current) {
+		replaced = current
+	}
+
+	marker := &metadata{
+		OriginalKey:  key,
+		VersionID:    versionID,
+		DeleteMarker: true,
+	}
+	marker.setModified(time.Now())
+	if err := s.writeMetadata(dir, marker); err != nil {
+		return err
+	}
+	if replaced == nil {
+		return nil
+	}
+	if len(replaced.Blob) > 0 {
+		s.releaseBlobs(ctx, []string{replaced.Blob})
+	}
+	return s.updateUsage(bucket, -int64(replaced.ContentSize), false)
+}
+
+type ObjectVersion struct {
+	Key          string
+	VersionID    string
+	IsLatest     bool
+	DeleteMarker bool
+	ContentHash  string
+	ETag         string
+	Size         int64
+	LastModified time.Time
+}
+
+// ListObjectVersions returns all versions of all objects in a bucket, sorted
+// by key and from the newest to the oldest version.
+func (s *Storage) ListObjectVersions(bucket string) ([]*ObjectVersion, error) {
+	dir, err := s.bucketDir(bucket)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := objectDirs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// objects and versions whose metadata can not be read are logged and
+	// skipped like in the listing of the objects
+	versions := []*ObjectVersion{}
+	for i, current := range s.readAllMetadata(bucket, dirs) {
+		// an object repaired without its key has no archived version either
+		if current == nil || len(current.OriginalKey) < 1 {
+			continue
+		}
+		objDir := dirs[i]
+
+		objVersions := []*ObjectVersion{newObjectVersion(current, true)}
+		archived, err := os.ReadDir(filepath.Join(objDir, "versions"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("could not read versions directory: %w", err)
+		}
+		for _, v := range archived {
+			meta, err := readMetadata(filepath.Join(objDir, "versions", v.Name()))
+			if err != nil {
+				log.Printf("[ERROR] - could not read metadata of bucket '%s' at '%s': %s", bucket, filepath.Join(objDir, "versions", v.Name()), err)
+				continue
+			}
+			objVersions = append(objVersions, newObjectVersion(meta, false))
+		}
+		// the null version has no timestamp in its ID, so archived versions
+		// are sorted by their modification time first
+		archive := objVersions[1:]
+		sort.SliceStable(archive, func(i, j int) bool {
+			if !archive[i].LastModified.Equal(archive[j].LastModified) {
+				return archive[i].LastModified.After(archive[j].LastModified)
+			}
+			return archive[i].VersionID > archive[j].VersionID
+		})
+		versions = append(versions, objVersions...)
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].Key < versions[j].Key
+	})
+	return versions, nil
+}
+
+func newObjectVersion(meta *metadata, latest bool) *ObjectVersion {
+	return &ObjectVersion{
+		Key:          meta.OriginalKey,
+		VersionID:    versionOf(meta),
+		IsLatest:     latest,
+		DeleteMarker: meta.DeleteMarker,
+		ContentHash:  meta.ContentHash,
+		ETag:         meta.etag(),
+		Size:         int64(meta.ContentSize),
+		LastModified: meta.modified(),
+	}
+}
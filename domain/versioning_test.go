@@ -0,0 +1,232 @@
+package domain
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVersioning(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Put("bucket", "key", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("bucket", "key", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := storage.ListObjectVersions("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("got versions: '%d', want versions: '%d'", len(versions), 2)
+	}
+	if !versions[0].IsLatest || versions[1].IsLatest {
+		t.Errorf("got latest: '%t, %t', want latest: 'true, false'", versions[0].IsLatest, versions[1].IsLatest)
+	}
+
+	var tests = []struct {
+		name      string
+		versionID string
+		body      string
+	}{
+		{"latest", "", "second"},
+		{"current version", versions[0].VersionID, "second"},
+		{"previous version", versions[1].VersionID, "first"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := storage.GetVersion("bucket", "key", test.versionID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != test.body {
+				t.Errorf("got body: '%s', want body: '%s'", body, test.body)
+			}
+		})
+	}
+}
+
+func TestVersioningDeleteMarker(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("bucket", "key", []byte("hello world!")); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete("bucket", "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = storage.Get("bucket", "key")
+	if domErr, ok := err.(*Error); !ok || domErr.Code != "NoSuchKey" {
+		t.Errorf("got error: '%v', want code: 'NoSuchKey'", err)
+	}
+
+	versions, err := storage.ListObjectVersions("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || !versions[0].DeleteMarker {
+		t.Fatalf("got versions: '%d', want a delete marker on top of '%d' versions", len(versions), 2)
+	}
+	body, err := storage.GetVersion("bucket", "key", versions[1].VersionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "hello world!" {
+		t.Errorf("got body: '%s', want body: '%s'", body, "hello world!")
+	}
+}
+
+func TestVersioningNullVersion(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	// written before versioning was enabled
+	if err := storage.Put("bucket", "key", []byte("unversioned")); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("bucket", "key", []byte("versioned")); err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := storage.GetVersion("bucket", "key", nullVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "unversioned" {
+		t.Errorf("got body: '%s', want body: '%s'", body, "unversioned")
+	}
+}
+
+func TestVersioningSuspendedDeleteQuota(t *testing.T) {
+	disk, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range map[string]backend{"filesystem": disk, "memory": NewMemStorage()} {
+		if err := s.NewBucket("bucket", "test-access-key"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetBucketQuota("bucket", 10); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetBucketVersioning("bucket", VersioningSuspended); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put("bucket", "key", []byte("12345678")); err != nil {
+			t.Fatal(err)
+		}
+		// the delete marker replaces the null version, its bytes are freed
+		if err := s.Delete("bucket", "key"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put("bucket", "other", []byte("12345678")); err != nil {
+			t.Errorf("%s: got error: '%v', want error: '<nil>'", name, err)
+		}
+	}
+}
+
+func TestListObjectVersionsCorrupted(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"key", "key", "corrupted"} {
+		if err := storage.Put("bucket", key, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.Put("bucket", "key", []byte("latest")); err != nil {
+		t.Fatal(err)
+	}
+
+	// one corrupted current and one corrupted archived version
+	corrupted, _ := storage.objectDir("bucket", "corrupted")
+	if err := os.WriteFile(corrupted+"/metadata.json", []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := storage.objectDir("bucket", "key")
+	archived, err := filepath.Glob(dir + "/versions/*/metadata.json")
+	if err != nil || len(archived) != 2 {
+		t.Fatalf("got archived: '%v', error: '%v', want two archived versions", archived, err)
+	}
+	if err := os.WriteFile(archived[0], []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := storage.ListObjectVersions("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Errorf("got versions: '%d', want versions: '%d'", len(versions), 2)
+	}
+}
+
+func TestVersioningArchiveFailure(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("bucket", "key", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a directory in place of the archived body makes archiving it fail
+	dir, _ := storage.objectDir("bucket", "key")
+	current, err := readMetadata(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "versions", current.VersionID, "body", "blocked"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("bucket", "key", []byte("second")); err == nil {
+		t.Fatal("got error: '<nil>', want archiving to fail")
+	}
+
+	body, err := storage.Get("bucket", "key")
+	if err != nil || string(body) != "first" {
+		t.Errorf("got body: '%s', error: '%v', want body: 'first'", body, err)
+	}
+}
@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"net/http"
+	"strings"
+)
+
+const websiteFile = "website.json"
+
+// WebsiteConfiguration lets a public-read bucket serve a static website.
+type WebsiteConfiguration struct {
+	// IndexDocument is appended to requests for the bucket root and for keys
+	// ending in "/" (e.g. "index.html").
+	IndexDocument string `json:"index_document"`
+	// ErrorDocument is the key of the object served when an object is missing.
+	ErrorDocument string `json:"error_document,omitempty"`
+}
+
+func validWebsite(config *WebsiteConfiguration) error {
+	if len(config.IndexDocument) < 1 || strings.Contains(config.IndexDocument, "/") {
+		return &Error{
+			msg:    "the index document suffix must not be empty and must not contain a slash",
+			Code:   "InvalidArgument",
+			Status: http.StatusBadRequest,
+		}
+	}
+	return nil
+}
+
+var errNoSuchWebsite = &Error{
+	msg:    "the specified bucket does not have a website configuration",
+	Code:   "NoSuchWebsiteConfiguration",
+	Status: http.StatusNotFound,
+}
+
+// SetBucketWebsite replaces the website configuration of a bucket.
+func (s *Storage) SetBucketWebsite(name string, config *WebsiteConfiguration) error {
+	if err := validWebsite(config); err != nil {
+		return err
+	}
+	return s.writeBucketFile(name, websiteFile, config)
+}
+
+// BucketWebsite returns the website configuration of a bucket.
+func (s *Storage) BucketWebsite(name string) (*WebsiteConfiguration, error) {
+	config := &WebsiteConfiguration{}
+	ok, err := s.readBucketFile(name, websiteFile, config)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errNoSuchWebsite
+	}
+	return config, nil
+}
+
+// DeleteBucketWebsite removes the website configuration of a bucket.
+// Deleting a missing configuration is not an error.
+func (s *Storage) DeleteBucketWebsite(name string) error {
+	return s.removeBucketFile(name, websiteFile)
+}
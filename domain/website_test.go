@@ -0,0 +1,40 @@
+package domain
+
+import "testing"
+
+func TestBucketWebsite(t *testing.T) {
+	storage, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, index := range []string{"", "docs/index.html"} {
+		err := storage.SetBucketWebsite("bucket", &WebsiteConfiguration{IndexDocument: index})
+		if domErr, ok := err.(*Error); !ok || domErr.Code != "InvalidArgument" {
+			t.Errorf("got error: '%v', want code: 'InvalidArgument' for index '%s'", err, index)
+		}
+	}
+
+	want := &WebsiteConfiguration{IndexDocument: "index.html", ErrorDocument: "error.html"}
+	if err := storage.SetBucketWebsite("bucket", want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := storage.BucketWebsite("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *want {
+		t.Errorf("got config: '%v', want config: '%v'", got, want)
+	}
+
+	if err := storage.DeleteBucketWebsite("bucket"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = storage.BucketWebsite("bucket")
+	if domErr, ok := err.(*Error); !ok || domErr.Code != "NoSuchWebsiteConfiguration" {
+		t.Errorf("got error: '%v', want code: 'NoSuchWebsiteConfiguration'", err)
+	}
+}
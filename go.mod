@@ -1,3 +1,5 @@
 module github.com/kfc-manager/bucket
 
 go 1.24.1
+
+require golang.org/x/text v0.31.0
@@ -1,26 +1,309 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/kfc-manager/bucket/domain"
 	"github.com/kfc-manager/bucket/server"
 )
 
 func main() {
-	auth := domain.NewAuth(
-		envOrPanic("ACCESS_KEY"),
-		envOrPanic("SECRET_KEY"),
-	)
-	storage, err := domain.NewStorage("./data")
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(verify(os.Args[2:], os.Stdout))
+	}
+
+	accessKey := envOrPanic("ACCESS_KEY")
+	auth := domain.NewAuth(accessKey, envOrPanic("SECRET_KEY"))
+	opts := storageOptions()
+	if retention, ok := envDuration("TRASH_RETENTION"); ok {
+		opts = append(opts, domain.WithSoftDelete(retention))
+	}
+	if interval, ok := envDuration("SCRUB_INTERVAL"); ok {
+		concurrency := 1
+		if n, ok := envInt("SCRUB_CONCURRENCY", 1); ok {
+			concurrency = n
+		}
+		opts = append(opts, domain.WithScrubber(interval, concurrency))
+	}
+	storage, closeStorage := newStorage(opts)
+
+	serverOpts := []server.Option{server.WithAdminKey(envOrDefault("ADMIN_ACCESS_KEY", accessKey))}
+	if d, ok := envDuration("READ_HEADER_TIMEOUT"); ok {
+		serverOpts = append(serverOpts, server.WithReadHeaderTimeout(d))
+	}
+	if d, ok := envDuration("READ_TIMEOUT"); ok {
+		serverOpts = append(serverOpts, server.WithReadTimeout(d))
+	}
+	if d, ok := envDuration("WRITE_TIMEOUT"); ok {
+		serverOpts = append(serverOpts, server.WithWriteTimeout(d))
+	}
+	if d, ok := envDuration("IDLE_TIMEOUT"); ok {
+		serverOpts = append(serverOpts, server.WithIdleTimeout(d))
+	}
+	if certFile := os.Getenv("TLS_CERT_FILE"); len(certFile) > 0 {
+		serverOpts = append(serverOpts, server.WithTLS(certFile, envOrPanic("TLS_KEY_FILE")))
+	}
+	if keepAlives := os.Getenv("KEEP_ALIVES"); len(keepAlives) > 0 {
+		serverOpts = append(serverOpts, server.WithKeepAlives(keepAlives == "true"))
+	}
+	if n, ok := envInt("MAX_CONNECTIONS", 1); ok {
+		serverOpts = append(serverOpts, server.WithMaxConnections(n))
+	}
+	if n, ok := envInt("MAX_HEADER_BYTES", 1); ok {
+		serverOpts = append(serverOpts, server.WithMaxHeaderBytes(n))
+	}
+	if os.Getenv("TRUST_PROXY") == "true" {
+		serverOpts = append(serverOpts, server.WithTrustedProxy())
+	}
+	if os.Getenv("DEBUG_SIGNATURES") == "true" {
+		serverOpts = append(serverOpts, server.WithSignatureDebug())
+	}
+	if port := os.Getenv("METRICS_PORT"); len(port) > 0 {
+		serverOpts = append(serverOpts, server.WithMetricsPort(port))
+	}
+	if port := os.Getenv("ADMIN_PORT"); len(port) > 0 {
+		serverOpts = append(serverOpts, server.WithAdminPort(port))
+	}
+	if origins := os.Getenv("CORS_ALLOWED_ORIGINS"); len(origins) > 0 {
+		serverOpts = append(serverOpts, server.WithCORS(
+			strings.Split(origins, ","),
+			strings.Split(envOrDefault("CORS_ALLOWED_METHODS", "GET,PUT,HEAD,DELETE"), ","),
+			strings.Split(envOrDefault("CORS_ALLOWED_HEADERS", "*"), ","),
+		))
+	}
+	if rate := os.Getenv("RATE_LIMIT"); len(rate) > 0 {
+		rps, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			panic(fmt.Errorf("environment variable 'RATE_LIMIT' is invalid: %w", err))
+		}
+		// the limiter divides by the rate to tell clients how long to wait
+		if rps <= 0 || math.IsNaN(rps) || math.IsInf(rps, 0) {
+			panic(fmt.Errorf("environment variable 'RATE_LIMIT' must be a positive number, got '%s'", rate))
+		}
+		burst, err := strconv.Atoi(envOrDefault("RATE_BURST", strconv.Itoa(int(math.Ceil(rps)))))
+		if err != nil {
+			panic(fmt.Errorf("environment variable 'RATE_BURST' is invalid: %w", err))
+		}
+		if burst < 1 {
+			panic(fmt.Errorf("environment variable 'RATE_BURST' must be at least 1, got '%d'", burst))
+		}
+		serverOpts = append(serverOpts, server.WithRateLimit(rps, burst))
+	}
+	s := server.New("8000", auth, storage, serverOpts...)
+
+	// the data volume may still be attaching, so the server answers with 503
+	// until the storage is ready instead of crashing
+	s.SetReady(false)
+	go func() {
+		waitReady(storage, time.Second)
+		// the keys file is kept on the data volume by default
+		if err := auth.LoadKeys(envOrDefault("KEYS_FILE", "./data/.keys.json")); err != nil {
+			panic(err)
+		}
+		if fs, ok := storage.(*domain.Storage); ok && os.Getenv("MIGRATE_LAYOUT") == "true" {
+			migrateLayout(fs)
+		}
+		if name := os.Getenv("DEFAULT_BUCKET"); len(name) > 0 {
+			if err := createDefaultBucket(storage, name, accessKey); err != nil {
+				panic(err)
+			}
+		}
+		s.SetReady(true)
+	}()
+
+	go func() {
+		if err := s.Listen(); err != nil {
+			panic(err)
+		}
+	}()
+
+	// finish active requests and the background work of the storage
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		log.Println("[ERROR] - could not shut down server: " + err.Error())
+	}
+	closeStorage()
+}
+
+// storageOptions returns the options of the storage which concern how objects
+// are named and stored, without its background work.
+func storageOptions() []domain.StorageOption {
+	opts := []domain.StorageOption{}
+	if region := os.Getenv("REGION"); len(region) > 0 {
+		opts = append(opts, domain.WithRegion(region))
+	}
+	if os.Getenv("CASE_INSENSITIVE_KEYS") == "true" {
+		opts = append(opts, domain.WithCaseInsensitiveKeys())
+	}
+	if os.Getenv("NFC_KEYS") == "true" {
+		opts = append(opts, domain.WithNFCKeys())
+	}
+	if os.Getenv("RELAXED_BUCKET_NAMES") == "true" {
+		opts = append(opts, domain.WithRelaxedNaming())
+	}
+	if levels, ok := envInt("FAN_OUT", 0); ok {
+		opts = append(opts, domain.WithFanOut(levels))
+	}
+	if minFree := os.Getenv("MIN_FREE_SPACE"); len(minFree) > 0 {
+		bytes, err := strconv.ParseUint(minFree, 10, 64)
+		if err != nil {
+			panic(fmt.Errorf("environment variable 'MIN_FREE_SPACE' is invalid: %w", err))
+		}
+		opts = append(opts, domain.WithMinFreeSpace(bytes))
+	}
+	if bytes, ok := envInt("MAX_KEY_LENGTH", 1); ok {
+		opts = append(opts, domain.WithMaxKeyLength(bytes))
+	}
+	if n, ok := envInt("LIST_CONCURRENCY", 1); ok {
+		opts = append(opts, domain.WithListConcurrency(n))
+	}
+	if len(os.Getenv("FILE_MODE")) > 0 || len(os.Getenv("DIR_MODE")) > 0 {
+		opts = append(opts, domain.WithFileModes(envMode("FILE_MODE", 0644), envMode("DIR_MODE", 0755)))
+	}
+	if os.Getenv("COMPACT_METADATA") == "true" {
+		opts = append(opts, domain.WithCompactMetadata())
+	}
+	if os.Getenv("COMPRESSION") == "true" {
+		opts = append(opts, domain.WithCompression())
+	}
+	if os.Getenv("DEDUPLICATION") == "true" {
+		opts = append(opts, domain.WithDeduplication())
+	}
+	if os.Getenv("ENCRYPTION") == "true" {
+		opts = append(opts, domain.WithEncryption(envOrPanic("ENCRYPTION_KEY")))
+	}
+	return opts
+}
+
+// newStorage returns the storage backend selected by the STORAGE variable
+// and the function which stops its background work.
+func newStorage(opts []domain.StorageOption) (server.Storage, func()) {
+	switch backend := envOrDefault("STORAGE", "filesystem"); backend {
+	case "memory":
+		storage := domain.NewMemStorage(opts...)
+		return storage, storage.Close
+	case "filesystem":
+		storage, err := domain.NewStorage("./data", append(opts, domain.WithPendingPath())...)
+		if err != nil {
+			panic(err)
+		}
+		return storage, storage.Close
+	default:
+		panic(fmt.Errorf("environment variable 'STORAGE' is invalid: '%s'", backend))
+	}
+}
+
+// waitReady blocks until the storage is ready, checking it at the interval.
+func waitReady(storage server.Storage, interval time.Duration) {
+	for {
+		err := storage.Ready()
+		if err == nil {
+			return
+		}
+		log.Printf("[INFO] - storage is not ready, retrying in %s: %s", interval, err)
+		time.Sleep(interval)
+	}
+}
+
+// createDefaultBucket creates the bucket for the owner unless it is already
+// present, so single bucket deployments need no signed request before their
+// first upload.
+func createDefaultBucket(storage server.Storage, name, owner string) error {
+	err := storage.NewBucket(name, owner)
+	var domErr *domain.Error
+	if errors.As(err, &domErr) && (domErr.Code == "BucketAlreadyOwnedByYou" || domErr.Code == "BucketAlreadyExists") {
+		log.Printf("[INFO] - default bucket '%s' is already present", name)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not create default bucket '%s': %w", name, err)
+	}
+	log.Printf("[INFO] - created default bucket '%s'", name)
+	return nil
+}
+
+// migrateLayout moves the objects of all buckets into the layout of the
+// configured fan-out.
+func migrateLayout(storage *domain.Storage) {
+	buckets, err := storage.ListBuckets()
 	if err != nil {
 		panic(err)
 	}
+	for _, b := range buckets {
+		moved, err := storage.MigrateLayout(b.Name)
+		if err != nil {
+			panic(fmt.Errorf("could not migrate bucket '%s': %w", b.Name, err))
+		}
+		log.Printf("[INFO] - migrated %d objects of bucket '%s'", moved, b.Name)
+	}
+}
 
-	if err := server.New("8000", auth, storage).Listen(); err != nil {
-		panic(err)
+// envDuration parses an optional environment variable as positive duration
+// (e.g. "30s").
+func envDuration(key string) (time.Duration, bool) {
+	value := os.Getenv(key)
+	if len(value) < 1 {
+		return 0, false
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		panic(fmt.Errorf("environment variable '%s' is invalid: %w", key, err))
+	}
+	if d <= 0 {
+		panic(fmt.Errorf("environment variable '%s' must be a positive duration, got '%s'", key, value))
+	}
+	return d, true
+}
+
+// envMode parses an optional environment variable as octal file mode (e.g.
+// "0640").
+func envMode(key string, fallback os.FileMode) os.FileMode {
+	value := os.Getenv(key)
+	if len(value) < 1 {
+		return fallback
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		panic(fmt.Errorf("environment variable '%s' is invalid: '%s'", key, value))
+	}
+	return os.FileMode(mode)
+}
+
+// envInt parses an optional environment variable as integer of at least
+// least, so a value like "0" fails at startup instead of breaking a limit.
+func envInt(key string, least int) (int, bool) {
+	value := os.Getenv(key)
+	if len(value) < 1 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		panic(fmt.Errorf("environment variable '%s' is invalid: %w", key, err))
+	}
+	if n < least {
+		panic(fmt.Errorf("environment variable '%s' must be at least %d, got '%d'", key, least, n))
+	}
+	return n, true
+}
+
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); len(value) > 0 {
+		return value
 	}
+	return fallback
 }
 
 func envOrPanic(key string) string {
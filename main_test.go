@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+func TestCreateDefaultBucket(t *testing.T) {
+	storage, err := domain.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := createDefaultBucket(storage, "bucket", "test-access-key"); err != nil {
+		t.Fatalf("got error: '%v', want error: '<nil>'", err)
+	}
+	info, err := storage.Bucket("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.OwnerAccessKey != "test-access-key" {
+		t.Errorf("got owner: '%s', want owner: '%s'", info.OwnerAccessKey, "test-access-key")
+	}
+
+	// a restart finds the bucket it created before
+	if err := createDefaultBucket(storage, "bucket", "test-access-key"); err != nil {
+		t.Errorf("got error: '%v', want error: '<nil>'", err)
+	}
+	if err := createDefaultBucket(storage, "Bucket", "test-access-key"); err == nil {
+		t.Error("got error: '<nil>', want error for invalid bucket name")
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	storage, err := domain.NewStorage(path, domain.WithPendingPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		waitReady(storage, 10*time.Millisecond)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("got ready, want waiting for the path")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("got waiting, want ready once the path exists")
+	}
+}
+
+func TestEnvValidation(t *testing.T) {
+	var tests = []struct {
+		name   string
+		value  string
+		parse  func(key string)
+		panics bool
+	}{
+		{"int", "8", func(key string) { envInt(key, 1) }, false},
+		{"int below minimum", "0", func(key string) { envInt(key, 1) }, true},
+		{"negative int", "-1", func(key string) { envInt(key, 0) }, true},
+		{"duration", "30s", func(key string) { envDuration(key) }, false},
+		{"zero duration", "0s", func(key string) { envDuration(key) }, true},
+		{"negative duration", "-1m", func(key string) { envDuration(key) }, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("TEST_VALUE", test.value)
+			defer func() {
+				if panicked := recover() != nil; panicked != test.panics {
+					t.Errorf("got panic: '%t', want panic: '%t'", panicked, test.panics)
+				}
+			}()
+			test.parse("TEST_VALUE")
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+// the admin API manages the access keys at runtime. It is not part of the S3
+// API and is only served on the loopback interface (see WithAdminPort).
+
+type adminKey struct {
+	AccessKey   string `json:"access_key"`
+	SecretKey   string `json:"secret_key,omitempty"`
+	Permissions string `json:"permissions"`
+	Stored      bool   `json:"stored"`
+}
+
+func permissionName(perm domain.Permission) string {
+	switch perm {
+	case domain.PermRead:
+		return "read"
+	case domain.PermWrite:
+		return "write"
+	}
+	return "full"
+}
+
+func parsePermission(name string) (domain.Permission, bool) {
+	switch strings.ToLower(name) {
+	case "read":
+		return domain.PermRead, true
+	case "write":
+		return domain.PermWrite, true
+	case "", "full":
+		return domain.PermFull, true
+	}
+	return 0, false
+}
+
+// adminHandler returns the routes of the admin API. Like the S3 API it
+// answers with 503 until the server is ready, the keys file is only loaded
+// then and a key changed before would be lost or come back.
+func (s *server) adminHandler() http.Handler {
+	mux := &http.ServeMux{}
+	mux.HandleFunc("GET /keys", s.listKeys)
+	mux.HandleFunc("POST /keys", s.addKey)
+	mux.HandleFunc("DELETE /keys/{accessKey}", s.revokeKey)
+	return headers(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.ready.Load() {
+			writeError(w, errNotReady)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func (s *server) listKeys(w http.ResponseWriter, r *http.Request) {
+	keys := []adminKey{}
+	for _, k := range s.auth.Keys() {
+		keys = append(keys, adminKey{
+			AccessKey:   k.AccessKey,
+			Permissions: permissionName(k.Permissions),
+			Stored:      k.Stored,
+		})
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// addKey stores a new key pair. Missing access and secret keys are generated,
+// the response is the only place the secret is ever returned. The body must be
+// sent as JSON, which a browser can not do cross-origin without a preflight.
+func (s *server) addKey(w http.ResponseWriter, r *http.Request) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		writeError(w, domain.NewError(http.StatusUnsupportedMediaType, "UnsupportedMediaType", "content type must be application/json"))
+		return
+	}
+	req := &adminKey{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, domain.NewError(http.StatusBadRequest, "MalformedJSON", "invalid key"))
+		return
+	}
+	perm, ok := parsePermission(req.Permissions)
+	if !ok {
+		writeError(w, domain.NewError(http.StatusBadRequest, "InvalidArgument", "permissions must be read, write or full"))
+		return
+	}
+	accessKey, secretKey, err := s.auth.StoreKey(req.AccessKey, req.SecretKey, perm)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, &adminKey{
+		AccessKey:   accessKey,
+		SecretKey:   secretKey,
+		Permissions: permissionName(perm),
+		Stored:      true,
+	})
+}
+
+func (s *server) revokeKey(w http.ResponseWriter, r *http.Request) {
+	if err := s.auth.RevokeKey(r.PathValue("accessKey")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
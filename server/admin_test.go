@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+func TestAdminKeys(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.auth.LoadKeys(filepath.Join(t.TempDir(), "keys.json")); err != nil {
+		t.Fatal(err)
+	}
+	admin := s.adminHandler()
+
+	addKey := func(body, contentType string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/keys", strings.NewReader(body))
+		if len(contentType) > 0 {
+			r.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, r)
+		return w
+	}
+
+	// add
+	w := addKey(`{"permissions": "read"}`, "application/json")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status: '%d', want status: '%d' (%s)", w.Code, http.StatusCreated, w.Body.String())
+	}
+	key := &adminKey{}
+	if err := json.Unmarshal(w.Body.Bytes(), key); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := addKey(`{"access_key": "`+key.AccessKey+`"}`, "application/json"); w.Code != http.StatusConflict {
+		t.Errorf("got status: '%d', want status: '%d'", w.Code, http.StatusConflict)
+	}
+	if w := addKey(`{"access_key": "test-access-key"}`, "application/json"); w.Code != http.StatusConflict {
+		t.Errorf("got status: '%d', want status: '%d'", w.Code, http.StatusConflict)
+	}
+	for _, contentType := range []string{"", "text/plain"} {
+		if w := addKey(`{"permissions": "read"}`, contentType); w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("got status: '%d', want status: '%d'", w.Code, http.StatusUnsupportedMediaType)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("GET", "/keys", nil))
+	if !strings.Contains(w.Body.String(), key.AccessKey) || strings.Contains(w.Body.String(), key.SecretKey) {
+		t.Errorf("got keys: '%s', want key '%s' without its secret", w.Body.String(), key.AccessKey)
+	}
+
+	// use
+	listBuckets := func() int {
+		r := httptest.NewRequest("GET", "/", nil)
+		domain.NewSigner(key.AccessKey, key.SecretKey, "us-east-1").Sign(r, nil)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := listBuckets(); code != http.StatusOK {
+		t.Errorf("got status: '%d', want status: '%d'", code, http.StatusOK)
+	}
+
+	// revoke
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("DELETE", "/keys/"+key.AccessKey, nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status: '%d', want status: '%d'", w.Code, http.StatusNoContent)
+	}
+
+	// reject
+	if code := listBuckets(); code != http.StatusForbidden {
+		t.Errorf("got status: '%d', want status: '%d'", code, http.StatusForbidden)
+	}
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("DELETE", "/keys/"+key.AccessKey, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status: '%d', want status: '%d'", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestAdminNotReady(t *testing.T) {
+	s := newTestServer(t)
+	s.SetReady(false)
+	admin := s.adminHandler()
+
+	r := httptest.NewRequest("POST", "/keys", strings.NewReader(`{"permissions": "read"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status: '%d', want status: '%d'", w.Code, http.StatusServiceUnavailable)
+	}
+	if keys := s.auth.Keys(); len(keys) != 1 {
+		t.Errorf("got keys: '%v', want only the configured key", keys)
+	}
+}
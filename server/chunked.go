@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+// streamingTrailer is the payload hash of aws-chunked uploads whose chunks
+// are not signed and which end with a trailing checksum header.
+const streamingTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+// crc64NVME is the reversed polynomial of the CRC-64/NVME checksum.
+const crc64NVME = 0x9a6c9329ac4bc9b5
+
+// checksums create the hash of every trailing checksum header S3 supports.
+var checksums = map[string]func() hash.Hash{
+	"x-amz-checksum-crc32":     func() hash.Hash { return crc32.NewIEEE() },
+	"x-amz-checksum-crc32c":    func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"x-amz-checksum-crc64nvme": func() hash.Hash { return crc64.New(crc64.MakeTable(crc64NVME)) },
+	"x-amz-checksum-sha1":      sha1.New,
+	"x-amz-checksum-sha256":    sha256.New,
+}
+
+func errMalformedChunk(msg string) *domain.Error {
+	return domain.NewError(http.StatusBadRequest, "IncompleteBody", msg)
+}
+
+// decodeTrailer removes the aws-chunked framing of a body and verifies the
+// checksum of the trailer header announced by x-amz-trailer against the
+// decoded payload. The framing is a sequence of chunks ("<hex size>\r\n
+// <data>\r\n") ending with an empty chunk, followed by the trailer headers
+// and an empty line.
+func decodeTrailer(body []byte, trailer string) ([]byte, error) {
+	trailer = strings.ToLower(strings.TrimSpace(trailer))
+	if _, ok := checksums[trailer]; !ok {
+		return nil, domain.NewError(http.StatusBadRequest, "InvalidRequest", "unsupported trailer: '"+trailer+"'")
+	}
+
+	r := bufio.NewReader(bytes.NewReader(body))
+	var payload bytes.Buffer
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, errMalformedChunk("chunk size line is incomplete")
+		}
+		// the size may be followed by chunk extensions
+		sizeHex, _, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size < 0 {
+			return nil, errMalformedChunk("invalid chunk size: '" + sizeHex + "'")
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&payload, r, size); err != nil {
+			return nil, errMalformedChunk("chunk is shorter than its size")
+		}
+		if crlf, err := r.ReadString('\n'); err != nil || crlf != "\r\n" {
+			return nil, errMalformedChunk("chunk is not terminated by CRLF")
+		}
+	}
+
+	headers := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) < 1 {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, domain.NewError(http.StatusBadRequest, "MalformedTrailerError", "malformed trailer header: '"+line+"'")
+		}
+		headers[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+		if err != nil {
+			break
+		}
+	}
+
+	want, ok := headers[trailer]
+	if !ok {
+		return nil, domain.NewError(http.StatusBadRequest, "MalformedTrailerError", "trailer header '"+trailer+"' is missing")
+	}
+	if checksum(trailer, payload.Bytes()) != want {
+		return nil, domain.NewError(http.StatusBadRequest, "BadDigest", "the "+trailer+" you specified did not match the calculated checksum")
+	}
+	return payload.Bytes(), nil
+}
+
+// checksum returns the base64 encoded checksum of the trailer header, in the
+// format clients send it in.
+func checksum(trailer string, data []byte) string {
+	h := checksums[trailer]()
+	h.Write(data)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+// frame encodes the payload with aws-chunked in chunks of the given size and
+// appends the trailer header.
+func frame(payload string, size int, trailer string) string {
+	var b strings.Builder
+	for len(payload) > 0 {
+		n := min(size, len(payload))
+		fmt.Fprintf(&b, "%x\r\n%s\r\n", n, payload[:n])
+		payload = payload[n:]
+	}
+	b.WriteString("0\r\n")
+	b.WriteString(trailer)
+	b.WriteString("\r\n\r\n")
+	return b.String()
+}
+
+func TestChecksums(t *testing.T) {
+	var tests = []struct {
+		trailer string
+		want    string
+	}{
+		// check values of the "123456789" test vector, base64 encoded
+		{"x-amz-checksum-crc32", "y/Q5Jg=="},
+		{"x-amz-checksum-crc32c", "4waSgw=="},
+		{"x-amz-checksum-crc64nvme", "rosUhgp5mIg="},
+	}
+
+	for _, test := range tests {
+		t.Run(test.trailer, func(t *testing.T) {
+			if got := checksum(test.trailer, []byte("123456789")); got != test.want {
+				t.Errorf("got checksum: '%s', want checksum: '%s'", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeTrailer(t *testing.T) {
+	payload := "hello world, this is a chunked upload!"
+	crc := "x-amz-checksum-crc32:" + checksum("x-amz-checksum-crc32", []byte(payload))
+	var tests = []struct {
+		name    string
+		body    string
+		trailer string
+		code    string // empty if the body is valid
+	}{
+		{"single chunk", frame(payload, 64, crc), "x-amz-checksum-crc32", ""},
+		{"many chunks", frame(payload, 5, crc), "x-amz-checksum-crc32", ""},
+		{"chunk extension", strings.Replace(frame(payload, 64, crc), "\r\n", ";ext=1\r\n", 1), "x-amz-checksum-crc32", ""},
+		{"sha256", frame(payload, 8, "x-amz-checksum-sha256:"+checksum("x-amz-checksum-sha256", []byte(payload))), "x-amz-checksum-sha256", ""},
+		{"checksum mismatch", frame(payload, 8, "x-amz-checksum-crc32:AAAAAA=="), "x-amz-checksum-crc32", "BadDigest"},
+		{"missing trailer", frame(payload, 8, ""), "x-amz-checksum-crc32", "MalformedTrailerError"},
+		{"other trailer", frame(payload, 8, crc), "x-amz-checksum-sha1", "MalformedTrailerError"},
+		{"unsupported trailer", frame(payload, 8, crc), "x-amz-checksum-md5", "InvalidRequest"},
+		{"invalid size", "zz\r\n" + payload, "x-amz-checksum-crc32", "IncompleteBody"},
+		{"truncated chunk", "ff\r\n" + payload, "x-amz-checksum-crc32", "IncompleteBody"},
+		{"missing end", "5\r\nhello\r\n", "x-amz-checksum-crc32", "IncompleteBody"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decodeTrailer([]byte(test.body), test.trailer)
+			if len(test.code) > 0 {
+				if domErr, ok := err.(*domain.Error); !ok || domErr.Code != test.code {
+					t.Errorf("got error: '%v', want code: '%s'", err, test.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != payload {
+				t.Errorf("got payload: '%s', want payload: '%s'", got, payload)
+			}
+		})
+	}
+}
+
+func TestTrailerUpload(t *testing.T) {
+	payload := "hello world!"
+	body := frame(payload, 4, "x-amz-checksum-crc32c:"+checksum("x-amz-checksum-crc32c", []byte(payload)))
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+
+	r := httptest.NewRequest("PUT", "/bucket/key", strings.NewReader(body))
+	r.Header.Set("Content-Encoding", "aws-chunked")
+	r.Header.Set("x-amz-trailer", "x-amz-checksum-crc32c")
+	r.Header.Set("x-amz-decoded-content-length", strconv.Itoa(len(payload)))
+	signer.SignPayload(r, streamingTrailer)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status: '%d', want status: '%d' (%s)", w.Code, http.StatusNoContent, w.Body.String())
+	}
+
+	r = httptest.NewRequest("GET", "/bucket/key", nil)
+	signer.Sign(r, nil)
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Body.String() != payload {
+		t.Errorf("got body: '%s', want body: '%s'", w.Body.String(), payload)
+	}
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("got content encoding: '%s', want no content encoding", got)
+	}
+}
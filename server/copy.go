@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+// metadata directives of a copy, COPY is the default
+const (
+	directiveCopy    = "COPY"
+	directiveReplace = "REPLACE"
+)
+
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyObjectResult"`
+	ETag         string   `xml:"ETag"`
+	LastModified string   `xml:"LastModified"`
+}
+
+// copySource parses the x-amz-copy-source header ("/{name}/{key}", URL
+// encoded, optionally followed by "?versionId=").
+func copySource(header string) (string, string, string, error) {
+	source, query, _ := strings.Cut(header, "?")
+	source, err := url.PathUnescape(source)
+	if err != nil {
+		return "", "", "", domain.NewError(http.StatusBadRequest, "InvalidArgument", "header x-amz-copy-source is not URL encoded")
+	}
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	if !ok || len(bucket) < 1 || len(key) < 1 {
+		return "", "", "", domain.NewError(http.StatusBadRequest, "InvalidArgument", "header x-amz-copy-source must name a bucket and key")
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", "", "", domain.NewError(http.StatusBadRequest, "InvalidArgument", "header x-amz-copy-source has an invalid version")
+	}
+	return bucket, key, values.Get("versionId"), nil
+}
+
+// copyObject stores a copy of the object named by the x-amz-copy-source
+// header under the key of the request. The x-amz-metadata-directive header
+// decides whether the stored headers are copied from the source (COPY) or
+// taken from the request (REPLACE).
+func (s *server) copyObject(w http.ResponseWriter, r *http.Request) {
+	directive := r.Header.Get("x-amz-metadata-directive")
+	if len(directive) < 1 {
+		directive = directiveCopy
+	}
+	if directive != directiveCopy && directive != directiveReplace {
+		writeError(w, domain.NewError(http.StatusBadRequest, "InvalidArgument", "unknown metadata directive: '"+directive+"'"))
+		return
+	}
+	bucket, key, versionID, err := copySource(r.Header.Get("x-amz-copy-source"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if bucket == r.PathValue("name") && key == r.PathValue("key") && directive == directiveCopy &&
+		len(r.Header.Get("x-amz-storage-class")) < 1 {
+		writeError(w, domain.NewError(http.StatusBadRequest, "InvalidRequest",
+			"an object can only be copied to itself if its metadata is replaced"))
+		return
+	}
+
+	// the key only had to be permitted to write the copy, reading the source
+	// needs its own permission
+	if !s.auth.Permitted(accessKey(r), http.MethodGet) {
+		writeError(w, domain.NewError(http.StatusForbidden, "AccessDenied", "access key is not permitted to read the copy source"))
+		return
+	}
+
+	data, info, err := s.storage.GetVersionCtx(r.Context(), bucket, key, versionID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	opts := domain.PutOptions{
+		ContentEncoding:  info.ContentEncoding,
+		ContentType:      info.ContentType,
+		CacheControl:     info.CacheControl,
+		Expires:          info.Expires,
+		StorageClass:     r.Header.Get("x-amz-storage-class"),
+		IfAbsent:         r.Header.Get("If-None-Match") == "*",
+		BypassGovernance: s.bypassGovernance(r),
+	}
+	if directive == directiveReplace {
+		opts.ContentEncoding = contentEncoding(r.Header.Get("Content-Encoding"))
+		opts.ContentType = r.Header.Get("Content-Type")
+		opts.CacheControl = r.Header.Get("Cache-Control")
+		opts.Expires = r.Header.Get("Expires")
+	}
+	if err := s.storage.PutObject(r.Context(), r.PathValue("name"), r.PathValue("key"), data, opts); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	result := &copyObjectResult{ETag: domain.ETag(data), LastModified: time.Now().UTC().Format(time.RFC3339)}
+	if copied, err := s.storage.Head(r.PathValue("name"), r.PathValue("key")); err == nil {
+		result.LastModified = copied.LastModified.UTC().Format(time.RFC3339)
+	}
+	body, err := xml.Marshal(result)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(info.VersionID) > 0 {
+		w.Header().Set("x-amz-copy-source-version-id", info.VersionID)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+// corsRule decides which cross-origin requests browsers are allowed to make.
+type corsRule struct {
+	origins       []string
+	methods       []string
+	headers       []string
+	exposeHeaders []string
+	maxAge        int // seconds, 0 if not set
+}
+
+// matchWildcard reports whether the value matches the pattern, which may
+// contain a single "*" wildcard (e.g. "https://*.example.com").
+func matchWildcard(pattern, value string) bool {
+	prefix, suffix, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return pattern == value
+	}
+	return len(value) >= len(prefix)+len(suffix) &&
+		strings.HasPrefix(value, prefix) &&
+		strings.HasSuffix(value, suffix)
+}
+
+func (c *corsRule) allowsOrigin(origin string) bool {
+	return slices.ContainsFunc(c.origins, func(p string) bool { return matchWildcard(p, origin) })
+}
+
+func (c *corsRule) allowsMethod(method string) bool {
+	return slices.Contains(c.methods, method)
+}
+
+func (c *corsRule) allowsHeaders(headers []string) bool {
+	for _, h := range headers {
+		if !slices.ContainsFunc(c.headers, func(p string) bool { return matchWildcard(strings.ToLower(p), h) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// allowOrigin returns the value of Access-Control-Allow-Origin for the origin.
+func (c *corsRule) allowOrigin(origin string) string {
+	if slices.Contains(c.origins, "*") {
+		return "*"
+	}
+	return origin
+}
+
+// requestHeaders splits the Access-Control-Request-Headers of a preflight.
+func requestHeaders(r *http.Request) []string {
+	headers := []string{}
+	for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); len(h) > 0 {
+			headers = append(headers, h)
+		}
+	}
+	return headers
+}
+
+// corsRules returns the CORS rules of the bucket addressed by the request.
+// Buckets without a CORS configuration fall back to the rule of the server.
+func (s *server) corsRules(r *http.Request) []*corsRule {
+	name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(name) > 0 {
+		if bucketRules, err := s.storage.BucketCORS(name); err == nil {
+			rules := make([]*corsRule, 0, len(bucketRules))
+			for _, rule := range bucketRules {
+				rules = append(rules, &corsRule{
+					origins:       rule.AllowedOrigins,
+					methods:       rule.AllowedMethods,
+					headers:       rule.AllowedHeaders,
+					exposeHeaders: rule.ExposeHeaders,
+					maxAge:        rule.MaxAgeSeconds,
+				})
+			}
+			return rules
+		}
+	}
+	if s.corsRule != nil {
+		return []*corsRule{s.corsRule}
+	}
+	return nil
+}
+
+// cors answers preflight requests and adds the CORS headers to responses of
+// requests from allowed origins. Without any rule requests pass through
+// unchanged.
+func (s *server) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(origin) < 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rules := s.corsRules(r)
+		if len(rules) < 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		if r.Method == http.MethodOptions {
+			method := r.Header.Get("Access-Control-Request-Method")
+			headers := requestHeaders(r)
+			i := slices.IndexFunc(rules, func(rule *corsRule) bool {
+				return rule.allowsOrigin(origin) && rule.allowsMethod(method) && rule.allowsHeaders(headers)
+			})
+			if i < 0 {
+				writeError(w, domain.NewError(http.StatusForbidden, "AccessForbidden", "CORSResponse: this CORS request is not allowed"))
+				return
+			}
+			rule := rules[i]
+			w.Header().Set("Access-Control-Allow-Origin", rule.allowOrigin(origin))
+			w.Header().Set("Access-Control-Allow-Methods", method)
+			if len(headers) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			}
+			if len(rule.exposeHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(rule.exposeHeaders, ", "))
+			}
+			if rule.maxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(rule.maxAge))
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		i := slices.IndexFunc(rules, func(rule *corsRule) bool {
+			return rule.allowsOrigin(origin) && rule.allowsMethod(r.Method)
+		})
+		if i >= 0 {
+			w.Header().Set("Access-Control-Allow-Origin", rules[i].allowOrigin(origin))
+			if len(rules[i].exposeHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(rules[i].exposeHeaders, ", "))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
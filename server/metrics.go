@@ -0,0 +1,252 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds (in seconds) of the request duration
+// histogram, the same defaults the Prometheus client libraries use.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestLabels struct {
+	method string
+	status int
+}
+
+type histogram struct {
+	counts []uint64 // one per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// metrics collects the request metrics which are exposed in the Prometheus
+// text exposition format.
+type metrics struct {
+	mu           sync.Mutex
+	requests     map[requestLabels]uint64
+	durations    map[string]*histogram
+	bytesRead    uint64
+	bytesWritten uint64
+	// conns are the open connections of the API by their current state
+	conns map[net.Conn]http.ConnState
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:  make(map[requestLabels]uint64),
+		durations: make(map[string]*histogram),
+		conns:     make(map[net.Conn]http.ConnState),
+	}
+}
+
+// trackConn follows the state of every connection, it is the ConnState hook
+// of the API server. Go closes connections whose request asked for
+// "Connection: close" after the response, which drops them from the gauge.
+func (m *metrics) trackConn(conn net.Conn, state http.ConnState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		delete(m.conns, conn)
+	default:
+		m.conns[conn] = state
+	}
+}
+
+func (m *metrics) observe(method string, status int, d time.Duration, read, written uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestLabels{method, status}]++
+	h, ok := m.durations[method]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m.durations[method] = h
+	}
+	seconds := d.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+	m.bytesRead += read
+	m.bytesWritten += written
+}
+
+// countingBody counts the bytes read from the request body.
+type countingBody struct {
+	io.ReadCloser
+	n uint64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += uint64(n)
+	return n, err
+}
+
+// statusWriter records the status code and the bytes written to the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	n      uint64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += uint64(n)
+	return n, err
+}
+
+// Flush passes through to the wrapped writer, so handlers which stream their
+// response still can flush it.
+func (w *statusWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the wrapped writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// instrument records the metrics of every request passed to next.
+func (m *metrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		body := &countingBody{ReadCloser: r.Body}
+		r.Body = body
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		m.observe(r.Method, sw.status, time.Since(start), body.n, sw.n)
+	})
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// write renders the collected metrics in the Prometheus text format.
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	labels := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].method != labels[j].method {
+			return labels[i].method < labels[j].method
+		}
+		return labels[i].status < labels[j].status
+	})
+	fmt.Fprintln(w, "# HELP bucket_requests_total Number of HTTP requests by method and status.")
+	fmt.Fprintln(w, "# TYPE bucket_requests_total counter")
+	for _, l := range labels {
+		fmt.Fprintf(w, "bucket_requests_total{method=%q,status=\"%d\"} %d\n", l.method, l.status, m.requests[l])
+	}
+
+	methods := make([]string, 0, len(m.durations))
+	for method := range m.durations {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	fmt.Fprintln(w, "# HELP bucket_request_duration_seconds Duration of HTTP requests by method.")
+	fmt.Fprintln(w, "# TYPE bucket_request_duration_seconds histogram")
+	for _, method := range methods {
+		h := m.durations[method]
+		var cumulative uint64
+		for i, bound := range durationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "bucket_request_duration_seconds_bucket{method=%q,le=%q} %d\n", method, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(w, "bucket_request_duration_seconds_bucket{method=%q,le=\"+Inf\"} %d\n", method, h.count)
+		fmt.Fprintf(w, "bucket_request_duration_seconds_sum{method=%q} %s\n", method, formatFloat(h.sum))
+		fmt.Fprintf(w, "bucket_request_duration_seconds_count{method=%q} %d\n", method, h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP bucket_read_bytes_total Bytes read from request bodies.")
+	fmt.Fprintln(w, "# TYPE bucket_read_bytes_total counter")
+	fmt.Fprintf(w, "bucket_read_bytes_total %d\n", m.bytesRead)
+	fmt.Fprintln(w, "# HELP bucket_written_bytes_total Bytes written to response bodies.")
+	fmt.Fprintln(w, "# TYPE bucket_written_bytes_total counter")
+	fmt.Fprintf(w, "bucket_written_bytes_total %d\n", m.bytesWritten)
+
+	states := map[http.ConnState]int{}
+	for _, state := range m.conns {
+		states[state]++
+	}
+	fmt.Fprintln(w, "# HELP bucket_connections Number of open connections by state.")
+	fmt.Fprintln(w, "# TYPE bucket_connections gauge")
+	for _, state := range []http.ConnState{http.StateActive, http.StateIdle, http.StateNew} {
+		fmt.Fprintf(w, "bucket_connections{state=%q} %d\n", state, states[state])
+	}
+}
+
+// serveMetrics exposes the request metrics together with the current number
+// of buckets, objects and used bytes. The totals are the ones the storage
+// tracks per bucket, so a scrape does not walk the objects. It is not
+// protected by SigV4, use WithMetricsPort to serve it on a separate port.
+func (s *server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	buckets, err := s.storage.ListBuckets()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var objects, used int64
+	for _, b := range buckets {
+		count, size, err := s.storage.BucketUsage(b.Name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		objects += count
+		used += size
+	}
+
+	var body strings.Builder
+	s.metrics.write(&body)
+	fmt.Fprintln(&body, "# HELP bucket_buckets Number of buckets.")
+	fmt.Fprintln(&body, "# TYPE bucket_buckets gauge")
+	fmt.Fprintf(&body, "bucket_buckets %d\n", len(buckets))
+	fmt.Fprintln(&body, "# HELP bucket_objects Number of objects over all buckets.")
+	fmt.Fprintln(&body, "# TYPE bucket_objects gauge")
+	fmt.Fprintf(&body, "bucket_objects %d\n", objects)
+	fmt.Fprintln(&body, "# HELP bucket_used_bytes Bytes used by all object versions over all buckets.")
+	fmt.Fprintln(&body, "# TYPE bucket_used_bytes gauge")
+	fmt.Fprintf(&body, "bucket_used_bytes %d\n", used)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, body.String())
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadId string   `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+type listMultipartUploadsResult struct {
+	XMLName     xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListMultipartUploadsResult"`
+	Bucket      string         `xml:"Bucket"`
+	IsTruncated bool           `xml:"IsTruncated"`
+	Uploads     []uploadResult `xml:"Upload"`
+}
+
+type uploadResult struct {
+	Key          string `xml:"Key"`
+	UploadId     string `xml:"UploadId"`
+	Initiated    string `xml:"Initiated"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// createMultipartUpload starts a multipart upload. The headers stored with
+// the object are taken from this request, like those of a PUT.
+func (s *server) createMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	opts := domain.PutOptions{
+		ContentEncoding: contentEncoding(r.Header.Get("Content-Encoding")),
+		ContentType:     r.Header.Get("Content-Type"),
+		CacheControl:    r.Header.Get("Cache-Control"),
+		Expires:         r.Header.Get("Expires"),
+		StorageClass:    r.Header.Get("x-amz-storage-class"),
+	}
+	id, err := s.storage.CreateMultipartUpload(r.PathValue("name"), r.PathValue("key"), opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeXML(w, &initiateMultipartUploadResult{
+		Bucket:   r.PathValue("name"),
+		Key:      r.PathValue("key"),
+		UploadId: id,
+	})
+}
+
+func (s *server) uploadPart(w http.ResponseWriter, r *http.Request) {
+	if len(r.Header.Get("x-amz-copy-source")) > 0 {
+		writeError(w, domain.NewError(http.StatusNotImplemented, "NotImplemented", "parts can not be copied from another object"))
+		return
+	}
+	part, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil {
+		writeError(w, domain.NewError(http.StatusBadRequest, "InvalidArgument", "partNumber must be an integer"))
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, domain.NewError(http.StatusBadRequest, "IncompleteBody", "could not read request body"))
+		return
+	}
+	defer r.Body.Close()
+
+	etag, err := s.storage.UploadPart(r.Context(), r.PathValue("name"), r.PathValue("key"), r.URL.Query().Get("uploadId"), part, body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+// completeMultipartUpload stores the listed parts as the object. The ETag is
+// the one of the whole body, not the one S3 derives from the parts.
+func (s *server) completeMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	complete := &completeMultipartUpload{}
+	if err := xml.NewDecoder(r.Body).Decode(complete); err != nil {
+		writeError(w, domain.NewError(http.StatusBadRequest, "MalformedXML", "malformed list of parts"))
+		return
+	}
+	defer r.Body.Close()
+
+	parts := []domain.CompletedPart{}
+	for _, part := range complete.Parts {
+		parts = append(parts, domain.CompletedPart{PartNumber: part.PartNumber, ETag: part.ETag})
+	}
+	etag, err := s.storage.CompleteMultipartUpload(r.Context(), r.PathValue("name"), r.PathValue("key"), r.URL.Query().Get("uploadId"), parts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeXML(w, &completeMultipartUploadResult{
+		Bucket: r.PathValue("name"),
+		Key:    r.PathValue("key"),
+		ETag:   etag,
+	})
+}
+
+func (s *server) abortMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	if err := s.storage.AbortMultipartUpload(r.PathValue("name"), r.PathValue("key"), r.URL.Query().Get("uploadId")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) listMultipartUploads(w http.ResponseWriter, r *http.Request) {
+	uploads, err := s.storage.ListMultipartUploads(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	result := &listMultipartUploadsResult{Bucket: r.PathValue("name")}
+	for _, upload := range uploads {
+		result.Uploads = append(result.Uploads, uploadResult{
+			Key:          upload.Key,
+			UploadId:     upload.UploadID,
+			Initiated:    upload.Initiated.Format(time.RFC3339),
+			StorageClass: upload.StorageClass,
+		})
+	}
+	writeXML(w, result)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+type objectRetention struct {
+	XMLName         xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ Retention"`
+	Mode            string   `xml:"Mode,omitempty"`
+	RetainUntilDate string   `xml:"RetainUntilDate,omitempty"`
+}
+
+type objectLegalHold struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LegalHold"`
+	Status  string   `xml:"Status"`
+}
+
+// bypassGovernance reports whether the request asks to bypass governance
+// retention, which only the admin key may do.
+func (s *server) bypassGovernance(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("x-amz-bypass-governance-retention"), "true") && s.admin(r)
+}
+
+// lockHeaders sets the object lock headers S3 returns on GET and HEAD.
+func lockHeaders(w http.ResponseWriter, lock domain.ObjectLock) {
+	if len(lock.Mode) > 0 {
+		w.Header().Set("x-amz-object-lock-mode", lock.Mode)
+		w.Header().Set("x-amz-object-lock-retain-until-date", lock.RetainUntil.UTC().Format(time.RFC3339))
+	}
+	if lock.LegalHold {
+		w.Header().Set("x-amz-object-lock-legal-hold", "ON")
+	}
+}
+
+func (s *server) getObjectRetention(w http.ResponseWriter, r *http.Request) {
+	lock, err := s.storage.ObjectLock(r.PathValue("name"), r.PathValue("key"), r.URL.Query().Get("versionId"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(lock.Mode) < 1 {
+		writeError(w, domain.NewError(http.StatusNotFound, "NoSuchObjectLockConfiguration", "the object has no retention"))
+		return
+	}
+	body, err := xml.Marshal(&objectRetention{
+		Mode:            lock.Mode,
+		RetainUntilDate: lock.RetainUntil.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// putObjectRetention replaces the retention of an object. A retention without
+// mode and date removes it, as far as the current retention allows.
+func (s *server) putObjectRetention(w http.ResponseWriter, r *http.Request) {
+	retention := &objectRetention{}
+	if err := xml.NewDecoder(r.Body).Decode(retention); err != nil {
+		writeError(w, domain.NewError(http.StatusBadRequest, "MalformedXML", "malformed retention"))
+		return
+	}
+	defer r.Body.Close()
+
+	lock := domain.ObjectLock{Mode: retention.Mode}
+	if len(retention.RetainUntilDate) > 0 {
+		until, err := time.Parse(time.RFC3339, retention.RetainUntilDate)
+		if err != nil {
+			writeError(w, domain.NewError(http.StatusBadRequest, "MalformedXML", "retain until date must be in ISO 8601 format"))
+			return
+		}
+		lock.RetainUntil = until
+	}
+	err := s.storage.SetObjectRetention(r.PathValue("name"), r.PathValue("key"), r.URL.Query().Get("versionId"),
+		lock, s.bypassGovernance(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) getObjectLegalHold(w http.ResponseWriter, r *http.Request) {
+	lock, err := s.storage.ObjectLock(r.PathValue("name"), r.PathValue("key"), r.URL.Query().Get("versionId"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	hold := &objectLegalHold{Status: "OFF"}
+	if lock.LegalHold {
+		hold.Status = "ON"
+	}
+	body, err := xml.Marshal(hold)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+func (s *server) putObjectLegalHold(w http.ResponseWriter, r *http.Request) {
+	hold := &objectLegalHold{}
+	if err := xml.NewDecoder(r.Body).Decode(hold); err != nil {
+		writeError(w, domain.NewError(http.StatusBadRequest, "MalformedXML", "malformed legal hold"))
+		return
+	}
+	defer r.Body.Close()
+	if hold.Status != "ON" && hold.Status != "OFF" {
+		writeError(w, domain.NewError(http.StatusBadRequest, "MalformedXML", "legal hold status must be either ON or OFF"))
+		return
+	}
+
+	err := s.storage.SetObjectLegalHold(r.PathValue("name"), r.PathValue("key"), r.URL.Query().Get("versionId"),
+		hold.Status == "ON")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type Option func(*server)
+
+// WithReadHeaderTimeout limits the time a client may take to send the request
+// headers, which protects against slow-loris clients.
+func WithReadHeaderTimeout(d time.Duration) Option {
+	return func(s *server) {
+		s.httpServer.ReadHeaderTimeout = d
+	}
+}
+
+// WithReadTimeout limits the time to read the whole request including the
+// body. It has to be long enough for the largest expected upload.
+func WithReadTimeout(d time.Duration) Option {
+	return func(s *server) {
+		s.httpServer.ReadTimeout = d
+	}
+}
+
+// WithWriteTimeout limits the time from the end of the request headers until
+// the response is written.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(s *server) {
+		s.httpServer.WriteTimeout = d
+	}
+}
+
+// WithIdleTimeout limits how long a keep-alive connection may wait for the
+// next request.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *server) {
+		s.httpServer.IdleTimeout = d
+	}
+}
+
+// WithMetricsPort serves the /metrics endpoint on its own port instead of
+// the port of the S3 API, so it can be kept private to the cluster.
+func WithMetricsPort(port string) Option {
+	return func(s *server) {
+		s.metricsServer = &http.Server{
+			Addr:              fmt.Sprintf(":%s", port),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+}
+
+// WithCORS allows browsers from the given origins to use the listed methods
+// and request headers. Origins and headers may contain a "*" wildcard.
+func WithCORS(origins, methods, headers []string) Option {
+	return func(s *server) {
+		s.corsRule = &corsRule{origins: origins, methods: methods, headers: headers}
+	}
+}
+
+// WithAdminKey lets the access key see the buckets of all keys when listing
+// buckets, including buckets created before their owner was recorded. Every
+// other key only sees the buckets it owns. The admin key is also the only one
+// which may bypass governance retention and change the limits of a bucket.
+func WithAdminKey(accessKey string) Option {
+	return func(s *server) {
+		s.adminKey = accessKey
+	}
+}
+
+// WithRateLimit limits every access key to rate requests per second on
+// average with bursts of up to burst requests. Requests over the limit are
+// rejected with 429 SlowDown.
+func WithRateLimit(rate float64, burst int) Option {
+	return func(s *server) {
+		s.limiter = newRateLimiter(rate, burst)
+	}
+}
+
+// WithKeyRateLimit overrides the rate limit for a single access key. It has
+// no effect unless WithRateLimit is given as well, before it.
+func WithKeyRateLimit(accessKey string, rate float64, burst int) Option {
+	return func(s *server) {
+		if s.limiter != nil {
+			s.limiter.overrides[accessKey] = limit{rate: rate, burst: burst}
+		}
+	}
+}
+
+// WithAdminPort serves the admin API, which lists, adds and revokes access
+// keys, on the port. It only listens on the loopback interface and requires
+// no authentication, so it must not be exposed.
+func WithAdminPort(port string) Option {
+	return func(s *server) {
+		s.adminServer = &http.Server{
+			Addr:              fmt.Sprintf("127.0.0.1:%s", port),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+}
+
+// WithTLS serves the API over TLS with the certificate and key of the PEM
+// encoded files. Clients may then use HTTP/2 as well as HTTP/1.1.
+func WithTLS(certFile, keyFile string) Option {
+	return func(s *server) {
+		s.certFile = certFile
+		s.keyFile = keyFile
+		s.httpServer.Protocols = &http.Protocols{}
+		s.httpServer.Protocols.SetHTTP1(true)
+		s.httpServer.Protocols.SetHTTP2(true)
+	}
+}
+
+// WithKeepAlives enables or disables keep-alive connections. Without them
+// every HTTP/1.1 request needs its own connection.
+func WithKeepAlives(enabled bool) Option {
+	return func(s *server) {
+		s.httpServer.SetKeepAlivesEnabled(enabled)
+	}
+}
+
+// WithMaxConnections limits the number of connections open at once. Further
+// clients wait until a connection is closed. An HTTP/2 connection counts once
+// no matter how many requests it multiplexes.
+func WithMaxConnections(n int) Option {
+	return func(s *server) {
+		s.maxConns = n
+	}
+}
+
+// WithMaxHeaderBytes limits the size of the request headers, it does not
+// limit the body.
+func WithMaxHeaderBytes(n int) Option {
+	return func(s *server) {
+		s.httpServer.MaxHeaderBytes = n
+	}
+}
+
+// WithSignatureDebug adds the canonical request and the string to sign the
+// server computed to SignatureDoesNotMatch errors, like S3 does. It helps to
+// debug clients but reveals how requests are signed, so it is off by default.
+func WithSignatureDebug() Option {
+	return func(s *server) {
+		s.debugSignatures = true
+	}
+}
+
+// WithTrustedProxy verifies signatures against the host of X-Forwarded-Host
+// and X-Forwarded-Proto instead of the Host header, for reverse proxies which
+// rewrite it. Only enable it if every request passes the proxy, otherwise
+// clients could pick the host themselves.
+func WithTrustedProxy() Option {
+	return func(s *server) {
+		s.trustProxy = true
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+// byteRange is an inclusive range of bytes of an object.
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+func (b *byteRange) length() int64 {
+	return b.end - b.start + 1
+}
+
+func (b *byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", b.start, b.end, size)
+}
+
+// requestedRange returns the Range header of a request for the object. If the
+// request carries an If-Range header which names an ETag or modification date
+// other than the one of the object, the object changed since the client got
+// its first part and the range is ignored, so the whole object is returned.
+func requestedRange(r *http.Request, info *domain.ObjectInfo) string {
+	condition := r.Header.Get("If-Range")
+	if len(condition) < 1 {
+		return r.Header.Get("Range")
+	}
+	if strings.HasPrefix(condition, `"`) {
+		// only strong ETags match, weak ones start with W/
+		if condition != info.ETag {
+			return ""
+		}
+		return r.Header.Get("Range")
+	}
+	date, err := http.ParseTime(condition)
+	if err != nil || !date.Equal(info.LastModified.Truncate(time.Second)) {
+		return ""
+	}
+	return r.Header.Get("Range")
+}
+
+// parseRange parses the Range header of a request for an object of the given
+// size. Like S3 only a single range is supported, headers which can not be
+// parsed are ignored and result in nil. Ranges which do not overlap the object
+// result in an InvalidRange error.
+func parseRange(header string, size int64) (*byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, nil
+	}
+	unsatisfiable := domain.NewError(
+		http.StatusRequestedRangeNotSatisfiable,
+		"InvalidRange",
+		"the requested range is not satisfiable",
+	)
+
+	// suffix range with the last n bytes
+	if len(first) < 1 {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return nil, nil
+		}
+		if n == 0 || size == 0 {
+			return nil, unsatisfiable
+		}
+		return &byteRange{start: max(size-n, 0), end: size - 1}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+	end := size - 1
+	if len(last) > 0 {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, nil
+		}
+	}
+	if start >= size {
+		return nil, unsatisfiable
+	}
+	return &byteRange{start: start, end: min(end, size-1)}, nil
+}
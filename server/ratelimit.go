@@ -0,0 +1,87 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// limit allows rate requests per second on average and bursts of up to burst
+// requests.
+type limit struct {
+	rate  float64
+	burst int
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per access key. Buckets which have filled
+// up again behave like new ones and are evicted, so only keys which were
+// active recently take up memory.
+type rateLimiter struct {
+	mu        sync.Mutex
+	def       limit
+	overrides map[string]limit
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		def:       limit{rate: rate, burst: burst},
+		overrides: map[string]limit{},
+		buckets:   map[string]*tokenBucket{},
+		now:       time.Now,
+	}
+}
+
+func (l *rateLimiter) limit(accessKey string) limit {
+	if override, ok := l.overrides[accessKey]; ok {
+		return override
+	}
+	return l.def
+}
+
+// allow takes a token from the bucket of the access key. If it is empty, it
+// returns how long the client has to wait for the next token.
+func (l *rateLimiter) allow(accessKey string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) > time.Minute {
+		l.sweep(now)
+		l.lastSweep = now
+	}
+
+	lim := l.limit(accessKey)
+	b, ok := l.buckets[accessKey]
+	if !ok {
+		b = &tokenBucket{tokens: float64(lim.burst), last: now}
+		l.buckets[accessKey] = b
+	}
+	b.tokens = math.Min(float64(lim.burst), b.tokens+now.Sub(b.last).Seconds()*lim.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / lim.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep evicts the buckets which are full again at now. It must be called
+// while holding l.mu.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		lim := l.limit(key)
+		if b.tokens+now.Sub(b.last).Seconds()*lim.rate >= float64(lim.burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newRateLimiter(2, 3)
+	l.overrides["fast-key"] = limit{rate: 100, burst: 10}
+	l.now = func() time.Time { return now }
+
+	// the burst is available right away, then the bucket is empty
+	for i := range 3 {
+		if ok, _ := l.allow("key"); !ok {
+			t.Fatalf("request %d: got allowed: 'false', want allowed: 'true'", i)
+		}
+	}
+	ok, wait := l.allow("key")
+	if ok {
+		t.Fatal("got allowed: 'true', want allowed: 'false'")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("got wait: '%v', want wait: '500ms'", wait)
+	}
+	// other keys have their own bucket
+	for i := range 10 {
+		if ok, _ := l.allow("fast-key"); !ok {
+			t.Fatalf("override request %d: got allowed: 'false', want allowed: 'true'", i)
+		}
+	}
+
+	now = now.Add(wait)
+	if ok, _ := l.allow("key"); !ok {
+		t.Error("got allowed: 'false' after waiting, want allowed: 'true'")
+	}
+
+	// idle keys are evicted once their bucket is full again
+	now = now.Add(2 * time.Minute)
+	l.allow("other-key")
+	if _, ok := l.buckets["key"]; ok {
+		t.Error("idle key should have been evicted")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	s := newTestServer(t, WithRateLimit(1, 2))
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+
+	codes := []int{}
+	for range 3 {
+		r := httptest.NewRequest("GET", "/", nil)
+		signer.Sign(r, nil)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		codes = append(codes, w.Code)
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1" {
+			t.Errorf("got retry after: '%s', want retry after: '1'", w.Header().Get("Retry-After"))
+		}
+	}
+	want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Errorf("got status: '%v', want status: '%v'", codes, want)
+			break
+		}
+	}
+}
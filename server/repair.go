@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+type repairResult struct {
+	XMLName  xml.Name         `xml:"RepairResult"`
+	Repaired []repairedObject `xml:"Repaired"`
+	Failed   *repairFailed    `xml:"Failed,omitempty"`
+}
+
+type repairFailed struct {
+	Directories []string `xml:"Directory"`
+}
+
+type repairedObject struct {
+	Key       string `xml:"Key"`
+	Directory string `xml:"Directory"`
+	Size      int64  `xml:"Size"`
+}
+
+// repairBucket rebuilds the metadata of every object of the bucket whose
+// metadata is missing or corrupted. Only the admin key may do so, it is
+// meant for operators restoring a bucket.
+func (s *server) repairBucket(w http.ResponseWriter, r *http.Request) {
+	if !s.admin(r) {
+		writeError(w, domain.NewError(http.StatusForbidden, "AccessDenied", "only the admin key may repair a bucket"))
+		return
+	}
+	repaired, err := s.storage.RepairBucket(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	result := &repairResult{}
+	if len(repaired.Failed) > 0 {
+		result.Failed = &repairFailed{Directories: repaired.Failed}
+	}
+	for _, object := range repaired.Repaired {
+		result.Repaired = append(result.Repaired, repairedObject{
+			Key:       object.Key,
+			Directory: object.Directory,
+			Size:      object.Size,
+		})
+	}
+	body, err := xml.Marshal(result)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
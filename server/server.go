@@ -2,143 +2,1427 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/kfc-manager/bucket/domain"
 )
 
+type contextKey int
+
+const (
+	accessKeyCtx contextKey = iota
+)
+
+// uploadSize returns the size of the object a PUT request uploads, as far as
+// it is known from the headers.
+func uploadSize(r *http.Request) (int64, bool) {
+	if decoded := r.Header.Get("x-amz-decoded-content-length"); len(decoded) > 0 {
+		size, err := strconv.ParseInt(decoded, 10, 64)
+		return size, err == nil && size >= 0
+	}
+	if strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		return 0, false
+	}
+	return r.ContentLength, r.ContentLength >= 0
+}
+
+// requestHost returns the host the client addressed, which is part of the
+// signature. A trusted proxy may rewrite the Host header, so then the first
+// entry of X-Forwarded-Host is taken. Clients leave out the default port of
+// the scheme when signing, which X-Forwarded-Proto tells.
+func (s *server) requestHost(r *http.Request) string {
+	if !s.trustProxy {
+		return r.Host
+	}
+	forwarded, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Host"), ",")
+	forwarded = strings.TrimSpace(forwarded)
+	if len(forwarded) < 1 {
+		return r.Host
+	}
+	proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+	switch strings.ToLower(strings.TrimSpace(proto)) {
+	case "https":
+		forwarded = strings.TrimSuffix(forwarded, ":443")
+	case "http":
+		forwarded = strings.TrimSuffix(forwarded, ":80")
+	}
+	return forwarded
+}
+
+// requestID returns the ID the request is logged with, which is echoed back
+// in the x-amz-request-id header. The storage logs with it as well.
+func requestID(r *http.Request) string {
+	return domain.RequestID(r.Context())
+}
+
+// accessKey returns the access key the request was signed with.
+func accessKey(r *http.Request) string {
+	key, _ := r.Context().Value(accessKeyCtx).(string)
+	return key
+}
+
+// admin reports whether the request is signed with the admin key.
+func (s *server) admin(r *http.Request) bool {
+	return len(s.adminKey) > 0 && accessKey(r) == s.adminKey
+}
+
 type server struct {
-	router  *http.ServeMux
-	port    string
+	router        *http.ServeMux
+	httpServer    *http.Server
+	metricsServer *http.Server // only set if metrics are served on their own port
+	adminServer   *http.Server // only set if the admin API is enabled
+	metrics       *metrics
+	corsRule      *corsRule    // nil if CORS is disabled
+	adminKey      string       // sees all buckets in the listing
+	limiter       *rateLimiter // nil if requests are not rate limited
+	certFile      string       // serves TLS if set, together with keyFile
+	keyFile       string
+	maxConns      int // 0 means unlimited
+	// debugSignatures returns the canonical request and string to sign on
+	// a signature mismatch
+	debugSignatures bool
+	// trustProxy takes the host the client signed from X-Forwarded-Host
+	trustProxy bool
+	// ready is unset while the storage can not be used yet, requests are
+	// answered with 503 until then
+	ready   atomic.Bool
 	auth    *domain.Auth
-	storage *domain.Storage
+	storage Storage
+}
+
+// route returns the key of the handler for the request. A route can register
+// handlers for subresources (e.g. "GET?stats"), which take precedence over the
+// plain method whenever the query carries that parameter.
+func route(methods map[string]http.HandlerFunc, r *http.Request) string {
+	query := r.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if methods[r.Method+"?"+k] != nil {
+			return r.Method + "?" + k
+		}
+	}
+	return r.Method
+}
+
+// subresources are the S3 subresources the server recognizes. A request for
+// one without a registered handler must not fall through to the plain method,
+// e.g. GET /{name}?lifecycle would otherwise list the objects of the bucket.
+var subresources = []string{
+	"accelerate", "acl", "analytics", "attributes", "cors", "delete", "encryption",
+	"intelligent-tiering", "inventory", "legal-hold", "lifecycle", "location",
+	"logging", "maxObjectSize", "maxObjects", "metadata", "metrics", "notification", "object-lock",
+	"ownershipControls", "policy", "policyStatus", "publicAccessBlock", "quota", "renameObject",
+	"repair", "replication", "requestPayment", "restore", "retention", "select", "stats", "tagging",
+	"torrent", "uploadId", "uploads", "versioning", "versions", "website",
+}
+
+// unimplemented returns the first recognized subresource of the request if
+// the route has no handler for it.
+func unimplemented(key string, r *http.Request) string {
+	if strings.Contains(key, "?") {
+		return ""
+	}
+	query := r.URL.Query()
+	for _, sub := range subresources {
+		if query.Has(sub) {
+			return sub
+		}
+	}
+	return ""
+}
+
+// allow returns the value of the Allow header for a route, which lists every
+// method a handler is registered for.
+func allow(methods map[string]http.HandlerFunc) string {
+	list := []string{}
+	for key := range methods {
+		method, _, _ := strings.Cut(key, "?")
+		if !slices.Contains(list, method) {
+			list = append(list, method)
+		}
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
+// public reports whether anonymous requests may read from the bucket.
+func (s *server) public(bucket string) bool {
+	acl, err := s.storage.BucketACL(bucket)
+	return err == nil && acl == domain.ACLPublicRead
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+// slashes collapses repeated slashes in the path, so "/bucket/a//b" addresses
+// the key "a/b" instead of being redirected by the router. The signature is
+// still verified against the path as it was sent.
+func slashes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "//") {
+			r = r.Clone(r.Context())
+			r.URL.Path = collapseSlashes(r.URL.Path)
+			r.URL.RawPath = collapseSlashes(r.URL.RawPath)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func collapseSlashes(path string) string {
+	for strings.Contains(path, "//") {
+		path = strings.ReplaceAll(path, "//", "/")
+	}
+	return path
+}
+
+// headers sets the headers every response carries, including errors.
+func headers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "bucket")
+		w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		id := newRequestID()
+		w.Header().Set("x-amz-request-id", id)
+		next.ServeHTTP(w, r.WithContext(domain.WithRequestID(r.Context(), id)))
+	})
+}
+
+// hasLength reports whether the size of the body is known up front. Chunked
+// uploads announce their size as they go and are exempt.
+func hasLength(r *http.Request) bool {
+	if slices.Contains(r.TransferEncoding, "chunked") ||
+		strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		return true
+	}
+	// go leaves the header in place, requests built in process only carry
+	// the parsed length
+	return len(r.Header.Get("Content-Length")) > 0 || r.ContentLength > 0
+}
+
+// bodiless reports whether requests of the method must not carry a body.
+func bodiless(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodDelete
 }
 
 func (s *server) middleware(methods map[string]http.HandlerFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if methods[r.Method] == nil {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		if !s.ready.Load() {
+			writeError(w, errNotReady)
+			return
+		}
+		key := route(methods, r)
+		next := methods[key]
+		if next == nil {
+			w.Header().Set("Allow", allow(methods))
+			writeError(w, domain.NewError(http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed"))
+			return
+		}
+		if sub := unimplemented(key, r); len(sub) > 0 {
+			writeError(w, domain.NewError(http.StatusNotImplemented, "NotImplemented", "subresource '"+sub+"' is not implemented"))
+			return
+		}
+		// a body is meaningless for these methods, so their payload hash is
+		// always the one of the empty string
+		if bodiless(r.Method) && r.ContentLength != 0 {
+			writeError(w, domain.NewError(http.StatusBadRequest, "InvalidRequest", r.Method+" requests must not have a body"))
 			return
 		}
 
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			http.Error(w, "could not read request body", http.StatusBadRequest)
+		// go moves the host header from the header map into the request
+		headers := r.Header.Clone()
+		headers.Set("host", s.requestHost(r))
+
+		// presigned URLs carry the signature in the query and leave the
+		// payload unsigned, all other S3 requests must have this header
+		presigned := r.URL.Query().Has("X-Amz-Signature")
+
+		// unsigned reads of objects and listings are allowed on public buckets
+		// and denied on private ones, subresources and every other method
+		// still require a signature
+		if !presigned && len(headers.Get("authorization")) < 1 &&
+			(key == http.MethodGet || key == http.MethodHead) &&
+			len(r.PathValue("name")) > 0 {
+			if !s.public(r.PathValue("name")) {
+				writeError(w, domain.NewError(http.StatusForbidden, "AccessDenied", "access denied"))
+				return
+			}
+			if config, err := s.storage.BucketWebsite(r.PathValue("name")); err == nil && r.Method == http.MethodGet {
+				s.serveWebsite(w, r, config)
+				return
+			}
+			next.ServeHTTP(w, r)
 			return
 		}
-		defer r.Body.Close()
-		r.Body = io.NopCloser(bytes.NewReader(body)) // make the body re-readable
 
-		headers := make(map[string]string)
-		// go removes this header field for some reason from requests
-		headers["host"] = r.Host
-		for k := range r.Header {
-			headers[strings.ToLower(k)] = r.Header.Get(k)
+		if !presigned && len(headers.Get("x-amz-content-sha256")) < 1 {
+			writeError(w, domain.NewError(http.StatusBadRequest, "InvalidRequest", "header x-amz-content-sha256 is missing"))
+			return
+		}
+
+		// the signature covers the claimed content hash, so the request can be
+		// rejected before its body is transferred (e.g. with Expect: 100-continue)
+		var accessKey string
+		var err error
+		if presigned {
+			accessKey, err = s.auth.ValidatePresigned(r.Method, r.RequestURI, headers, time.Now())
+		} else {
+			accessKey, err = s.auth.Validate(r.Method, r.RequestURI, headers, headers.Get("x-amz-content-sha256"))
+		}
+		if err != nil {
+			if domErr, ok := err.(*domain.Error); !ok {
+				err = domain.NewError(http.StatusUnauthorized, "AccessDenied", "unauthorized")
+			} else if !s.debugSignatures {
+				// the computed signing input is only revealed in debug mode
+				err = domain.NewError(domErr.Status, domErr.Code, domErr.Error())
+			}
+			writeError(w, err)
+			return
+		}
+		if !s.auth.Permitted(accessKey, r.Method) {
+			writeError(w, domain.NewError(http.StatusForbidden, "AccessDenied", "access denied"))
+			return
+		}
+		if s.limiter != nil {
+			if ok, wait := s.limiter.allow(accessKey); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeError(w, domain.NewError(http.StatusTooManyRequests, "SlowDown", "please reduce your request rate"))
+				return
+			}
 		}
 
-		// for all S3 request this header must be present
-		if len(headers["x-amz-content-sha256"]) < 1 {
-			http.Error(w, "header x-amz-content-sha256 is missing", http.StatusBadRequest)
+		// only uploads need their size up front, other PUTs such as
+		// CreateBucket, ?acl or copies commonly come without a body
+		upload := (key == http.MethodPut || key == "PUT?uploadId") &&
+			len(r.PathValue("key")) > 0 && len(r.Header.Get("x-amz-copy-source")) < 1
+		if upload && !hasLength(r) {
+			writeError(w, domain.NewError(http.StatusLengthRequired, "MissingContentLength", "header Content-Length is missing"))
 			return
 		}
-		bodyHash := domain.Sha256Hash(body)
-		if headers["x-amz-content-sha256"] != bodyHash {
-			http.Error(w, "content hash mismatch", http.StatusBadRequest)
+		// an upload which can not be stored is rejected before its body is
+		// read, so Go withholds 100 Continue and the client does not send it
+		if key == http.MethodPut && len(r.PathValue("key")) > 0 {
+			if size, ok := uploadSize(r); ok {
+				if err := s.storage.CheckPut(r.PathValue("name"), r.PathValue("key"), int(size)); err != nil {
+					writeError(w, err)
+					return
+				}
+			}
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, domain.NewError(http.StatusBadRequest, "IncompleteBody", "could not read request body"))
 			return
 		}
+		defer r.Body.Close()
 
-		if err := s.auth.Validate(r.Method, r.RequestURI, headers, bodyHash); err != nil {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		if !presigned && headers.Get("x-amz-content-sha256") == streamingTrailer {
+			// the payload is only protected by the checksum of the trailer
+			if body, err = decodeTrailer(body, headers.Get("x-amz-trailer")); err != nil {
+				writeError(w, err)
+				return
+			}
+			if decoded := headers.Get("x-amz-decoded-content-length"); len(decoded) > 0 && decoded != strconv.Itoa(len(body)) {
+				writeError(w, domain.NewError(http.StatusBadRequest, "IncompleteBody", "decoded body does not match x-amz-decoded-content-length"))
+				return
+			}
+			r.ContentLength = int64(len(body))
+		} else if !presigned && headers.Get("x-amz-content-sha256") != domain.Sha256Hash(body) {
+			writeError(w, domain.NewError(http.StatusBadRequest, "XAmzContentSHA256Mismatch", "content hash mismatch"))
 			return
 		}
+		r.Body = io.NopCloser(bytes.NewReader(body)) // make the body re-readable
 
 		// route to the correct handler for the method
 		// (we checked at the start of the function if it exists)
-		methods[r.Method].ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), accessKeyCtx, accessKey)))
 	})
 }
 
-func New(port string, auth *domain.Auth, storage *domain.Storage) *server {
-	s := &server{router: &http.ServeMux{}, port: port, auth: auth, storage: storage}
+func New(port string, auth *domain.Auth, storage Storage, opts ...Option) *server {
+	s := &server{router: &http.ServeMux{}, metrics: newMetrics(), auth: auth, storage: storage}
+	s.httpServer = &http.Server{
+		Addr:              fmt.Sprintf(":%s", port),
+		Handler:           headers(s.metrics.instrument(s.cors(slashes(s.router)))),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       5 * time.Minute,
+		WriteTimeout:      5 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+		ConnState:         s.metrics.trackConn,
+	}
+	s.ready.Store(true)
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	bucketRoute := map[string]http.HandlerFunc{
+		"PUT":               s.createBucket,
+		"HEAD":              s.headBucket,
+		"GET?stats":         s.bucketStats,
+		"DELETE?prefix":     s.deletePrefix,
+		"POST?repair":       s.repairBucket,
+		"PUT?quota":         s.putBucketQuota,
+		"PUT?maxObjectSize": s.putBucketMaxObjectSize,
+		"PUT?maxObjects":    s.putBucketMaxObjects,
+		"GET?versioning":    s.getBucketVersioning,
+		"PUT?versioning":    s.putBucketVersioning,
+		"GET?versions":      s.listObjectVersions,
+		"GET?uploads":       s.listMultipartUploads,
+		"GET?acl":           s.getBucketACL,
+		"PUT?acl":           s.putBucketACL,
+		"GET?website":       s.getBucketWebsite,
+		"PUT?website":       s.putBucketWebsite,
+		"DELETE?website":    s.deleteBucketWebsite,
+		"GET?cors":          s.getBucketCORS,
+		"PUT?cors":          s.putBucketCORS,
+		"DELETE?cors":       s.deleteBucketCORS,
+		"GET":               s.listObjects,
+	}
 	routes := map[string]map[string]http.HandlerFunc{
-		"/{name}": {
-			"PUT": s.createBucket,
-			// "GET": s.listBucket, TODO implement
+		"/{$}": {
+			"GET": s.listBuckets,
 		},
-		"/{name}/{key}": {
-			"GET":    s.getObject,
-			"PUT":    s.putObject,
-			"DELETE": s.deleteObject,
+		"/{name}": bucketRoute,
+		// an empty key addresses the bucket itself
+		"/{name}/{$}": bucketRoute,
+		// keys may contain slashes, so the wildcard matches the rest of the
+		// path and no path below a bucket is left unmatched
+		"/{name}/{key...}": {
+			"GET":              s.getObject,
+			"GET?metadata":     s.getObjectMetadata,
+			"HEAD":             s.headObject,
+			"PUT":              s.putObject,
+			"PUT?renameObject": s.renameObject,
+			"GET?retention":    s.getObjectRetention,
+			"PUT?retention":    s.putObjectRetention,
+			"GET?legal-hold":   s.getObjectLegalHold,
+			"PUT?legal-hold":   s.putObjectLegalHold,
+			"DELETE":           s.deleteObject,
+			"POST?uploads":     s.createMultipartUpload,
+			"PUT?uploadId":     s.uploadPart,
+			"POST?uploadId":    s.completeMultipartUpload,
+			"DELETE?uploadId":  s.abortMultipartUpload,
 		},
 	}
-	s.router.HandleFunc("/", s.health)
+	// the storage reserves these names, so they never shadow a bucket
+	s.router.HandleFunc("/healthz", s.health)
+	s.router.HandleFunc("/readyz", s.readiness)
+	s.router.HandleFunc("GET /version", s.serveVersion)
+	if s.metricsServer != nil {
+		mux := &http.ServeMux{}
+		mux.HandleFunc("GET /metrics", s.serveMetrics)
+		s.metricsServer.Handler = mux
+	} else {
+		s.router.HandleFunc("GET /metrics", s.serveMetrics)
+	}
 	for path, route := range routes {
 		s.router.Handle(path, s.middleware(route))
 	}
+	if s.adminServer != nil {
+		s.adminServer.Handler = s.adminHandler()
+	}
 
 	return s
 }
 
+// Handler returns the handler of the API, e.g. to serve it with httptest.
+func (s *server) Handler() http.Handler {
+	return s.httpServer.Handler
+}
+
+// Listen serves requests until the server is shut down, in which case it
+// returns nil.
 func (s *server) Listen() error {
-	return http.ListenAndServe(fmt.Sprintf(":%s", s.port), s.router)
+	if s.metricsServer != nil {
+		go func() {
+			if err := s.metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+				log.Println("[ERROR] - metrics server: " + err.Error())
+			}
+		}()
+	}
+	if s.adminServer != nil {
+		go func() {
+			if err := s.adminServer.ListenAndServe(); err != http.ErrServerClosed {
+				log.Println("[ERROR] - admin server: " + err.Error())
+			}
+		}()
+	}
+	ln, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	return s.serve(ln)
 }
 
-func writeError(w http.ResponseWriter, err error) {
-	if domErr, ok := err.(*domain.Error); ok {
-		http.Error(w, err.Error(), domErr.Status)
+// serve accepts the connections of the API on the listener until the server
+// is shut down.
+func (s *server) serve(ln net.Listener) error {
+	if s.maxConns > 0 {
+		ln = newLimitListener(ln, s.maxConns)
+	}
+	var err error
+	if len(s.certFile) > 0 {
+		err = s.httpServer.ServeTLS(ln, s.certFile, s.keyFile)
 	} else {
-		log.Println("[ERROR] - " + err.Error())
+		err = s.httpServer.Serve(ln)
+	}
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections and waits for active requests to
+// finish or the context to expire.
+func (s *server) Shutdown(ctx context.Context) error {
+	if s.metricsServer != nil {
+		if err := s.metricsServer.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	if s.adminServer != nil {
+		if err := s.adminServer.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	return s.httpServer.Shutdown(ctx)
+}
+
+type errorResponse struct {
+	XMLName          xml.Name `xml:"Error"`
+	Code             string   `xml:"Code"`
+	Message          string   `xml:"Message"`
+	CanonicalRequest string   `xml:"CanonicalRequest,omitempty"`
+	StringToSign     string   `xml:"StringToSign,omitempty"`
+	RequestID        string   `xml:"RequestId,omitempty"`
+}
+
+// writeError renders the error in the S3 error format. Errors which are not
+// a domain error are logged and hidden behind a generic internal error.
+func writeError(w http.ResponseWriter, err error) {
+	// the headers middleware has already set the ID of the request
+	id := w.Header().Get("x-amz-request-id")
+	domErr, ok := err.(*domain.Error)
+	if !ok {
+		log.Println("[ERROR] - " + logPrefix(id) + err.Error())
+		domErr = domain.NewError(http.StatusInternalServerError, "InternalError", "internal server error")
+	}
+
+	body, err := xml.Marshal(&errorResponse{
+		Code:             domErr.Code,
+		Message:          domErr.Error(),
+		CanonicalRequest: domErr.CanonicalRequest,
+		StringToSign:     domErr.StringToSign,
+		RequestID:        id,
+	})
+	if err != nil {
+		log.Println("[ERROR] - " + logPrefix(id) + err.Error())
 		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
 	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(domErr.Status)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// logPrefix tags a log line with the request ID, so it can be found from the
+// x-amz-request-id a client reports.
+func logPrefix(id string) string {
+	if len(id) < 1 {
+		return ""
+	}
+	return "request " + id + ": "
 }
 
 func (s *server) health(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("healthy"))
 }
 
+var errNotReady = domain.NewError(http.StatusServiceUnavailable, "ServiceUnavailable", "storage is not ready yet")
+
+// SetReady marks whether the storage can be used. Unlike /healthz, which only
+// tells that the process is alive, /readyz and every API request fail with
+// 503 while the server is not ready.
+func (s *server) SetReady(ready bool) {
+	s.ready.Store(ready)
+}
+
+func (s *server) readiness(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		writeError(w, errNotReady)
+		return
+	}
+	w.Write([]byte("ready"))
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   owner          `xml:"Owner"`
+	Buckets []bucketResult `xml:"Buckets>Bucket"`
+}
+
+type bucketResult struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+func (s *server) listBuckets(w http.ResponseWriter, r *http.Request) {
+	buckets, err := s.storage.ListBuckets()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// like in S3 every key only sees its own buckets, except for the admin
+	key := accessKey(r)
+	result := &listAllMyBucketsResult{Owner: owner{ID: key}, Buckets: []bucketResult{}}
+	for _, b := range buckets {
+		if b.OwnerAccessKey != key && (len(s.adminKey) < 1 || key != s.adminKey) {
+			continue
+		}
+		result.Buckets = append(result.Buckets, bucketResult{
+			Name:         b.Name,
+			CreationDate: b.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	body, err := xml.Marshal(result)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+type createBucketConfiguration struct {
+	XMLName            xml.Name `xml:"CreateBucketConfiguration"`
+	LocationConstraint string   `xml:"LocationConstraint"`
+}
+
 func (s *server) createBucket(w http.ResponseWriter, r *http.Request) {
-	err := s.storage.NewBucket(r.PathValue("name"))
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, domain.NewError(http.StatusBadRequest, "IncompleteBody", "could not read request body"))
+		return
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		config := &createBucketConfiguration{}
+		if err := xml.Unmarshal(body, config); err != nil {
+			writeError(w, domain.NewError(http.StatusBadRequest, "MalformedXML", "invalid bucket configuration"))
+			return
+		}
+		if len(config.LocationConstraint) > 0 && config.LocationConstraint != s.storage.Region() {
+			writeError(w, domain.NewError(
+				http.StatusBadRequest,
+				"InvalidLocationConstraint",
+				fmt.Sprintf("the location constraint must be '%s'", s.storage.Region()),
+			))
+			return
+		}
+	}
+
+	// the bucket is stored with the region of the storage
+	if err := s.storage.NewBucket(r.PathValue("name"), accessKey(r)); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/"+r.PathValue("name"))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) headBucket(w http.ResponseWriter, r *http.Request) {
+	info, err := s.storage.Bucket(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("x-amz-bucket-region", info.Region)
+	w.WriteHeader(http.StatusOK)
+}
+
+type bucketStats struct {
+	XMLName     xml.Name `xml:"BucketStats"`
+	ObjectCount int      `xml:"ObjectCount"`
+	TotalBytes  int64    `xml:"TotalBytes"`
+	// ChecksumMismatches is the number of corrupted objects found by the
+	// last scrub
+	ChecksumMismatches int `xml:"ChecksumMismatches"`
+}
+
+func (s *server) bucketStats(w http.ResponseWriter, r *http.Request) {
+	count, size, err := s.storage.BucketStats(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	body, err := xml.Marshal(&bucketStats{
+		ObjectCount:        count,
+		TotalBytes:         size,
+		ChecksumMismatches: s.storage.ScrubMismatches(r.PathValue("name")),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+type deletePrefixResult struct {
+	XMLName xml.Name `xml:"DeletePrefixResult"`
+	Deleted int      `xml:"Deleted"`
+}
+
+func (s *server) deletePrefix(w http.ResponseWriter, r *http.Request) {
+	deleted, err := s.storage.DeletePrefix(r.PathValue("name"), r.URL.Query().Get("prefix"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	body, err := xml.Marshal(&deletePrefixResult{Deleted: deleted})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+type bucketQuota struct {
+	XMLName xml.Name `xml:"BucketQuota"`
+	Bytes   int64    `xml:"Bytes"`
+}
+
+// putBucketQuota sets the quota of a bucket. Only the admin key may change the
+// limits of a bucket, they are meant to constrain the keys writing into it.
+func (s *server) putBucketQuota(w http.ResponseWriter, r *http.Request) {
+	if !s.admin(r) {
+		writeError(w, domain.NewError(http.StatusForbidden, "AccessDenied", "only the admin key may set the quota of a bucket"))
+		return
+	}
+	quota := &bucketQuota{}
+	if err := xml.NewDecoder(r.Body).Decode(quota); err != nil {
+		writeError(w, domain.NewError(http.StatusBadRequest, "MalformedXML", "malformed bucket quota"))
+		return
+	}
+	defer r.Body.Close()
+
+	if err := s.storage.SetBucketQuota(r.PathValue("name"), quota.Bytes); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type bucketMaxObjectSize struct {
+	XMLName xml.Name `xml:"MaxObjectSize"`
+	Bytes   int64    `xml:"Bytes"`
+}
+
+// putBucketMaxObjectSize is restricted to the admin key like putBucketQuota.
+func (s *server) putBucketMaxObjectSize(w http.ResponseWriter, r *http.Request) {
+	if !s.admin(r) {
+		writeError(w, domain.NewError(http.StatusForbidden, "AccessDenied", "only the admin key may set the maximum object size of a bucket"))
+		return
+	}
+	size := &bucketMaxObjectSize{}
+	if err := xml.NewDecoder(r.Body).Decode(size); err != nil {
+		writeError(w, domain.NewError(http.StatusBadRequest, "MalformedXML", "malformed maximum object size"))
+		return
+	}
+	defer r.Body.Close()
+
+	if err := s.storage.SetBucketMaxObjectSize(r.PathValue("name"), size.Bytes); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type bucketMaxObjects struct {
+	XMLName xml.Name `xml:"MaxObjects"`
+	Count   int64    `xml:"Count"`
+}
+
+// putBucketMaxObjects is restricted to the admin key like putBucketQuota.
+func (s *server) putBucketMaxObjects(w http.ResponseWriter, r *http.Request) {
+	if !s.admin(r) {
+		writeError(w, domain.NewError(http.StatusForbidden, "AccessDenied", "only the admin key may set the maximum number of objects of a bucket"))
+		return
+	}
+	count := &bucketMaxObjects{}
+	if err := xml.NewDecoder(r.Body).Decode(count); err != nil {
+		writeError(w, domain.NewError(http.StatusBadRequest, "MalformedXML", "malformed maximum number of objects"))
+		return
+	}
+	defer r.Body.Close()
+
+	if err := s.storage.SetBucketMaxObjects(r.PathValue("name"), count.Count); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type versioningConfiguration struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ VersioningConfiguration"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+func (s *server) getBucketVersioning(w http.ResponseWriter, r *http.Request) {
+	status, err := s.storage.BucketVersioning(r.PathValue("name"))
 	if err != nil {
 		writeError(w, err)
 		return
 	}
-	w.WriteHeader(201)
-	w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
-	<CreateBucketConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
-	</CreateBucketConfiguration>`))
+	body, err := xml.Marshal(&versioningConfiguration{Status: status})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+func (s *server) putBucketVersioning(w http.ResponseWriter, r *http.Request) {
+	config := &versioningConfiguration{}
+	if err := xml.NewDecoder(r.Body).Decode(config); err != nil {
+		writeError(w, domain.NewError(http.StatusBadRequest, "MalformedXML", "malformed versioning configuration"))
+		return
+	}
+	defer r.Body.Close()
+
+	if err := s.storage.SetBucketVersioning(r.PathValue("name"), config.Status); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name        `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string          `xml:"Name"`
+	Prefix                string          `xml:"Prefix"`
+	Delimiter             string          `xml:"Delimiter,omitempty"`
+	KeyCount              int             `xml:"KeyCount"`
+	MaxKeys               int             `xml:"MaxKeys"`
+	IsTruncated           bool            `xml:"IsTruncated"`
+	ContinuationToken     string          `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string          `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string          `xml:"StartAfter,omitempty"`
+	Contents              []objectContent `xml:"Contents"`
+	CommonPrefixes        []commonPrefix  `xml:"CommonPrefixes"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type objectContent struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+	Owner        *owner `xml:"Owner,omitempty"`
+}
+
+// listObjects implements ListObjectsV2.
+func (s *server) listObjects(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	maxKeys := 1000
+	if v := query.Get("max-keys"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, domain.NewError(http.StatusBadRequest, "InvalidArgument", "max-keys must be a non-negative integer"))
+			return
+		}
+		maxKeys = min(n, 1000)
+	}
+
+	result := &listBucketResult{
+		Name:              r.PathValue("name"),
+		Prefix:            query.Get("prefix"),
+		Delimiter:         query.Get("delimiter"),
+		MaxKeys:           maxKeys,
+		ContinuationToken: query.Get("continuation-token"),
+		StartAfter:        query.Get("start-after"),
+	}
+	if maxKeys > 0 {
+		page, err := s.storage.List(r.PathValue("name"), domain.ListOptions{
+			Prefix:            result.Prefix,
+			Delimiter:         result.Delimiter,
+			StartAfter:        result.StartAfter,
+			ContinuationToken: result.ContinuationToken,
+			MaxKeys:           maxKeys,
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		// objects are owned by the owner of their bucket
+		var objOwner *owner
+		if query.Get("fetch-owner") == "true" {
+			info, err := s.storage.Bucket(r.PathValue("name"))
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			objOwner = &owner{ID: info.OwnerAccessKey}
+		}
+		result.IsTruncated = page.IsTruncated
+		result.NextContinuationToken = page.NextContinuationToken
+		for _, o := range page.Objects {
+			result.Contents = append(result.Contents, objectContent{
+				Key:          o.Key,
+				LastModified: o.LastModified.Format(time.RFC3339),
+				ETag:         o.ETag,
+				Size:         o.Size,
+				StorageClass: o.StorageClass,
+				Owner:        objOwner,
+			})
+		}
+		for _, prefix := range page.CommonPrefixes {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: prefix})
+		}
+		result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+	}
+
+	body, err := xml.Marshal(result)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+type accessControlPolicy struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ AccessControlPolicy"`
+	Owner   owner    `xml:"Owner"`
+	Grants  []grant  `xml:"AccessControlList>Grant"`
+}
+
+type owner struct {
+	ID string `xml:"ID"`
+}
+
+type grant struct {
+	Grantee    grantee `xml:"Grantee"`
+	Permission string  `xml:"Permission"`
+}
+
+type grantee struct {
+	XMLNSXSI string `xml:"xmlns:xsi,attr"`
+	Type     string `xml:"xsi:type,attr"`
+	ID       string `xml:"ID,omitempty"`
+	URI      string `xml:"URI,omitempty"`
+}
+
+func (s *server) getBucketACL(w http.ResponseWriter, r *http.Request) {
+	info, err := s.storage.Bucket(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	acl, err := s.storage.BucketACL(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	xsi := "http://www.w3.org/2001/XMLSchema-instance"
+	policy := &accessControlPolicy{
+		Owner: owner{ID: info.OwnerAccessKey},
+		Grants: []grant{{
+			Grantee:    grantee{XMLNSXSI: xsi, Type: "CanonicalUser", ID: info.OwnerAccessKey},
+			Permission: "FULL_CONTROL",
+		}},
+	}
+	if acl == domain.ACLPublicRead {
+		policy.Grants = append(policy.Grants, grant{
+			Grantee:    grantee{XMLNSXSI: xsi, Type: "Group", URI: "http://acs.amazonaws.com/groups/global/AllUsers"},
+			Permission: "READ",
+		})
+	}
+	body, err := xml.Marshal(policy)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// putBucketACL only supports the canned ACLs of the x-amz-acl header.
+func (s *server) putBucketACL(w http.ResponseWriter, r *http.Request) {
+	acl := r.Header.Get("x-amz-acl")
+	if len(acl) < 1 {
+		writeError(w, domain.NewError(http.StatusNotImplemented, "NotImplemented", "only canned ACLs via the x-amz-acl header are supported"))
+		return
+	}
+	if err := s.storage.SetBucketACL(r.PathValue("name"), acl); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type websiteConfiguration struct {
+	XMLName       xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ WebsiteConfiguration"`
+	IndexDocument string   `xml:"IndexDocument>Suffix"`
+	ErrorDocument string   `xml:"ErrorDocument>Key,omitempty"`
+}
+
+func (s *server) getBucketWebsite(w http.ResponseWriter, r *http.Request) {
+	config, err := s.storage.BucketWebsite(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	body, err := xml.Marshal(&websiteConfiguration{
+		IndexDocument: config.IndexDocument,
+		ErrorDocument: config.ErrorDocument,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+func (s *server) putBucketWebsite(w http.ResponseWriter, r *http.Request) {
+	config := &websiteConfiguration{}
+	if err := xml.NewDecoder(r.Body).Decode(config); err != nil {
+		writeError(w, domain.NewError(http.StatusBadRequest, "MalformedXML", "malformed website configuration"))
+		return
+	}
+	defer r.Body.Close()
+
+	err := s.storage.SetBucketWebsite(r.PathValue("name"), &domain.WebsiteConfiguration{
+		IndexDocument: config.IndexDocument,
+		ErrorDocument: config.ErrorDocument,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) deleteBucketWebsite(w http.ResponseWriter, r *http.Request) {
+	if err := s.storage.DeleteBucketWebsite(r.PathValue("name")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type corsConfiguration struct {
+	XMLName xml.Name      `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CORSConfiguration"`
+	Rules   []corsXMLRule `xml:"CORSRule"`
+}
+
+type corsXMLRule struct {
+	AllowedOrigins []string `xml:"AllowedOrigin"`
+	AllowedMethods []string `xml:"AllowedMethod"`
+	AllowedHeaders []string `xml:"AllowedHeader"`
+	ExposeHeaders  []string `xml:"ExposeHeader"`
+	MaxAgeSeconds  int      `xml:"MaxAgeSeconds,omitempty"`
+}
+
+func (s *server) getBucketCORS(w http.ResponseWriter, r *http.Request) {
+	rules, err := s.storage.BucketCORS(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	config := &corsConfiguration{}
+	for _, rule := range rules {
+		config.Rules = append(config.Rules, corsXMLRule(rule))
+	}
+	body, err := xml.Marshal(config)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+func (s *server) putBucketCORS(w http.ResponseWriter, r *http.Request) {
+	config := &corsConfiguration{}
+	if err := xml.NewDecoder(r.Body).Decode(config); err != nil {
+		writeError(w, domain.NewError(http.StatusBadRequest, "MalformedXML", "malformed CORS configuration"))
+		return
+	}
+	defer r.Body.Close()
+
+	rules := make([]domain.CORSRule, 0, len(config.Rules))
+	for _, rule := range config.Rules {
+		rules = append(rules, domain.CORSRule(rule))
+	}
+	if err := s.storage.SetBucketCORS(r.PathValue("name"), rules); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) deleteBucketCORS(w http.ResponseWriter, r *http.Request) {
+	if err := s.storage.DeleteBucketCORS(r.PathValue("name")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type listVersionsResult struct {
+	XMLName       xml.Name             `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListVersionsResult"`
+	Name          string               `xml:"Name"`
+	IsTruncated   bool                 `xml:"IsTruncated"`
+	Versions      []versionResult      `xml:"Version"`
+	DeleteMarkers []deleteMarkerResult `xml:"DeleteMarker"`
+}
+
+type versionResult struct {
+	Key          string `xml:"Key"`
+	VersionId    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+}
+
+type deleteMarkerResult struct {
+	Key          string `xml:"Key"`
+	VersionId    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+}
+
+func (s *server) listObjectVersions(w http.ResponseWriter, r *http.Request) {
+	versions, err := s.storage.ListObjectVersions(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	result := &listVersionsResult{Name: r.PathValue("name")}
+	for _, v := range versions {
+		lastModified := v.LastModified.Format(time.RFC3339)
+		if v.DeleteMarker {
+			result.DeleteMarkers = append(result.DeleteMarkers, deleteMarkerResult{
+				Key:          v.Key,
+				VersionId:    v.VersionID,
+				IsLatest:     v.IsLatest,
+				LastModified: lastModified,
+			})
+			continue
+		}
+		result.Versions = append(result.Versions, versionResult{
+			Key:          v.Key,
+			VersionId:    v.VersionID,
+			IsLatest:     v.IsLatest,
+			LastModified: lastModified,
+			ETag:         v.ETag,
+			Size:         v.Size,
+		})
+	}
+	body, err := xml.Marshal(result)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// responseOverrides maps the query parameters a GET request may use to
+// override response headers onto those headers.
+var responseOverrides = map[string]string{
+	"response-cache-control":       "Cache-Control",
+	"response-content-disposition": "Content-Disposition",
+	"response-content-encoding":    "Content-Encoding",
+	"response-content-type":        "Content-Type",
+}
+
+// overrideHeaders sets the response headers requested through the query,
+// e.g. by a presigned URL which makes the browser download the object under
+// a specific filename. Like S3 it refuses them on anonymous requests.
+func overrideHeaders(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	for param, header := range responseOverrides {
+		if !query.Has(param) {
+			continue
+		}
+		if len(accessKey(r)) < 1 {
+			return domain.NewError(http.StatusBadRequest, "InvalidRequest", "response headers can not be overridden by anonymous requests")
+		}
+		w.Header().Set(header, query.Get(param))
+	}
+	return nil
 }
 
 func (s *server) getObject(w http.ResponseWriter, r *http.Request) {
-	data, err := s.storage.Get(r.PathValue("name"), r.PathValue("key"))
+	data, info, err := s.storage.GetVersionCtx(
+		r.Context(),
+		r.PathValue("name"),
+		r.PathValue("key"),
+		r.URL.Query().Get("versionId"),
+	)
 	if err != nil {
 		writeError(w, err)
 		return
 	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", info.ETag)
+	storedHeaders(w, info)
+	if err := overrideHeaders(w, r); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	size := int64(len(data))
+	rng, err := parseRange(requestedRange(r, info), size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		writeError(w, err)
+		return
+	}
+	if rng != nil {
+		// the checksum is of the whole object, not of the range
+		w.Header().Del("x-amz-checksum-sha256")
+		w.Header().Set("Content-Length", strconv.FormatInt(rng.length(), 10))
+		w.Header().Set("Content-Range", rng.contentRange(size))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[rng.start : rng.end+1])
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
 	w.WriteHeader(http.StatusOK)
 	w.Write(data)
 }
 
+func (s *server) headObject(w http.ResponseWriter, r *http.Request) {
+	info, err := s.storage.Head(r.PathValue("name"), r.PathValue("key"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", info.ETag)
+	w.Header().Set("Last-Modified", info.LastModified.Format(http.TimeFormat))
+	storedHeaders(w, info)
+
+	// clients probe range support with HEAD, which answers like GET would
+	rng, err := parseRange(requestedRange(r, info), info.Size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+		writeError(w, err)
+		return
+	}
+	if rng != nil {
+		// the checksum is of the whole object, not of the range
+		w.Header().Del("x-amz-checksum-sha256")
+		w.Header().Set("Content-Length", strconv.FormatInt(rng.length(), 10))
+		w.Header().Set("Content-Range", rng.contentRange(info.Size))
+		w.WriteHeader(http.StatusPartialContent)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// storedHeaders sets the headers which were stored along with the object.
+// The Content-Type is the one sent on upload, objects uploaded without one get
+// the type of the extension of their key. The stored sha256 is returned like
+// S3 returns the checksum of full objects.
+func storedHeaders(w http.ResponseWriter, info *domain.ObjectInfo) {
+	if len(info.ContentType) > 0 {
+		w.Header().Set("Content-Type", info.ContentType)
+	} else {
+		w.Header().Set("Content-Type", contentType(info.Key))
+	}
+	for header, value := range map[string]string{
+		"Content-Encoding":    info.ContentEncoding,
+		"Cache-Control":       info.CacheControl,
+		"Expires":             info.Expires,
+		"x-amz-storage-class": info.StorageClass,
+	} {
+		if len(value) > 0 {
+			w.Header().Set(header, value)
+		}
+	}
+	lockHeaders(w, info.Lock)
+	if checksum, err := hex.DecodeString(info.ContentHash); err == nil && len(checksum) > 0 {
+		w.Header().Set("x-amz-checksum-sha256", base64.StdEncoding.EncodeToString(checksum))
+	}
+}
+
+// contentType returns the media type of the extension of the key, or
+// application/octet-stream for keys without a known extension.
+func contentType(key string) string {
+	if contentType := mime.TypeByExtension(path.Ext(key)); len(contentType) > 0 {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// getObjectMetadata returns the stored metadata of an object as JSON, which
+// is not part of the S3 API. It allows to check the integrity fields without
+// downloading the body.
+func (s *server) getObjectMetadata(w http.ResponseWriter, r *http.Request) {
+	body, err := s.storage.Metadata(r.PathValue("name"), r.PathValue("key"), r.URL.Query().Get("versionId"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// contentEncoding returns the encoding an object is stored with. The
+// aws-chunked encoding only describes how the body was transferred and is
+// dropped.
+func contentEncoding(header string) string {
+	encodings := []string{}
+	for _, enc := range strings.Split(header, ",") {
+		if enc = strings.TrimSpace(enc); len(enc) > 0 && enc != "aws-chunked" {
+			encodings = append(encodings, enc)
+		}
+	}
+	return strings.Join(encodings, ",")
+}
+
 func (s *server) putObject(w http.ResponseWriter, r *http.Request) {
+	if len(r.Header.Get("x-amz-copy-source")) > 0 {
+		s.copyObject(w, r)
+		return
+	}
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "could not read request body", http.StatusBadRequest)
+		writeError(w, domain.NewError(http.StatusBadRequest, "IncompleteBody", "could not read request body"))
+		return
 	}
 	defer r.Body.Close()
 
-	if err := s.storage.Put(r.PathValue("name"), r.PathValue("key"), body); err != nil {
+	// a dry run only validates the upload, the body hash has already been
+	// checked by the middleware at this point
+	if r.Header.Get("x-amz-dry-run") == "true" {
+		if err := s.storage.CheckPut(r.PathValue("name"), r.PathValue("key"), len(body)); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("ETag", domain.ETag(body))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	opts := domain.PutOptions{
+		ContentEncoding: contentEncoding(r.Header.Get("Content-Encoding")),
+		ContentType:     r.Header.Get("Content-Type"),
+		CacheControl:    r.Header.Get("Cache-Control"),
+		Expires:         r.Header.Get("Expires"),
+		StorageClass:    r.Header.Get("x-amz-storage-class"),
+		// If-None-Match: * only creates the object if the key is still free
+		IfAbsent:         r.Header.Get("If-None-Match") == "*",
+		BypassGovernance: s.bypassGovernance(r),
+	}
+	if err := s.storage.PutObject(r.Context(), r.PathValue("name"), r.PathValue("key"), body, opts); err != nil {
 		writeError(w, err)
 		return
 	}
+	w.Header().Set("ETag", domain.ETag(body))
 	w.WriteHeader(http.StatusNoContent)
 	w.Write([]byte("no content"))
 }
 
+// renameObject moves the object named by the x-amz-rename-source header
+// ("/{name}/{key}", URL encoded) to the key of the request. The object is
+// overwritten unless the request carries If-None-Match: *.
+func (s *server) renameObject(w http.ResponseWriter, r *http.Request) {
+	source, err := url.PathUnescape(r.Header.Get("x-amz-rename-source"))
+	if err != nil {
+		writeError(w, domain.NewError(http.StatusBadRequest, "InvalidArgument", "header x-amz-rename-source is not URL encoded"))
+		return
+	}
+	bucket, srcKey, ok := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	if !ok {
+		writeError(w, domain.NewError(http.StatusBadRequest, "InvalidArgument", "header x-amz-rename-source must name a bucket and key"))
+		return
+	}
+	if bucket != r.PathValue("name") {
+		writeError(w, domain.NewError(http.StatusBadRequest, "InvalidArgument", "objects can only be renamed within their bucket"))
+		return
+	}
+
+	if r.Header.Get("If-None-Match") == "*" {
+		err = s.storage.RenameIfAbsent(bucket, srcKey, r.PathValue("key"))
+	} else {
+		err = s.storage.Rename(bucket, srcKey, r.PathValue("key"))
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func (s *server) deleteObject(w http.ResponseWriter, r *http.Request) {
-	err := s.storage.Delete(r.PathValue("name"), r.PathValue("key"))
+	// If-Match only deletes the object if it did not change since the client
+	// last read it
+	opts := domain.DeleteOptions{
+		IfMatch:          r.Header.Get("If-Match"),
+		BypassGovernance: s.bypassGovernance(r),
+	}
+	err := s.storage.DeleteObject(r.Context(), r.PathValue("name"), r.PathValue("key"), opts)
 	if err != nil {
 		writeError(w, err)
 		return
@@ -0,0 +1,1591 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+func newTestServer(t *testing.T, opts ...Option) *server {
+	storage, err := domain.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New("8000", domain.NewAuth("test-access-key", "test-secret-key"), storage, opts...)
+}
+
+func TestRouting(t *testing.T) {
+	var tests = []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{"health", "GET", "/healthz", http.StatusOK},
+		// "/a/b/c" addresses the key "b/c" of bucket "a", so it reaches the
+		// authentication instead of being unmatched
+		{"nested key without auth", "GET", "/a/b/c", http.StatusForbidden},
+		{"nested key unknown method", "PATCH", "/a/b/c", http.StatusMethodNotAllowed},
+		{"root without auth", "GET", "/", http.StatusBadRequest},
+		{"bucket lifecycle", "GET", "/bucket?lifecycle", http.StatusNotImplemented},
+		{"bucket replication", "PUT", "/bucket?replication", http.StatusNotImplemented},
+		{"object tagging", "GET", "/bucket/key?tagging", http.StatusNotImplemented},
+		{"list parts", "GET", "/bucket/key?uploadId=1", http.StatusNotImplemented},
+		{"unregistered method of subresource", "HEAD", "/bucket?acl", http.StatusNotImplemented},
+	}
+
+	s := newTestServer(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.router.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, test.status)
+			}
+			if test.path != "/healthz" && w.Body.String() == "healthy" {
+				t.Errorf("got body: 'healthy' for path '%s'", test.path)
+			}
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	storage, err := domain.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	_, errNoSuchKey := storage.Get("bucket", "missing")
+	_, errNoSuchBucket := storage.Get("missing", "missing")
+
+	var tests = []struct {
+		name   string
+		err    error
+		code   string
+		status int
+	}{
+		{"no such key", errNoSuchKey, "NoSuchKey", http.StatusNotFound},
+		{"no such bucket", errNoSuchBucket, "NoSuchBucket", http.StatusNotFound},
+		{"bucket already exists", storage.NewBucket("bucket", "other-access-key"), "BucketAlreadyExists", http.StatusConflict},
+		{"invalid bucket name", storage.NewBucket("Bucket", "test-access-key"), "InvalidBucketName", http.StatusBadRequest},
+		{"internal error", errors.New("disk on fire"), "InternalError", http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeError(w, test.err)
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, test.status)
+			}
+			resp := &errorResponse{}
+			if err := xml.Unmarshal(w.Body.Bytes(), resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != test.code {
+				t.Errorf("got code: '%s', want code: '%s'", resp.Code, test.code)
+			}
+		})
+	}
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	s := newTestServer(t, WithReadHeaderTimeout(100*time.Millisecond))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.httpServer.Serve(listener)
+	defer s.httpServer.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// stall after the first header line
+	if _, err := conn.Write([]byte("GET /healthz HTTP/1.1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("connection was not closed by the server: %v", err)
+	}
+	if strings.Contains(string(b), "healthy") {
+		t.Errorf("got response: '%s', want connection to be closed", b)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	s := newTestServer(t, WithReadTimeout(200*time.Millisecond))
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.httpServer.Serve(listener)
+	defer s.httpServer.Close()
+
+	body := []byte(strings.Repeat("a", 64))
+	r := httptest.NewRequest("PUT", "/bucket/key", nil)
+	r.Host = listener.Addr().String()
+	r.ContentLength = int64(len(body))
+	domain.NewSigner("test-access-key", "test-secret-key", "us-east-1").Sign(r, body)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	head := fmt.Sprintf("PUT /bucket/key HTTP/1.1\r\nHost: %s\r\nContent-Length: %d\r\n", r.Host, len(body))
+	for name := range r.Header {
+		head += name + ": " + r.Header.Get(name) + "\r\n"
+	}
+	// send the headers and half of the body, then stall
+	if _, err := conn.Write([]byte(head + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(body[:len(body)/2]); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("connection was not closed by the server: %v", err)
+	}
+	if strings.HasPrefix(string(b), "HTTP/1.1 200") {
+		t.Errorf("got response: '%s', want upload to be cut off", b)
+	}
+	if _, err := s.storage.Get("bucket", "key"); err == nil {
+		t.Errorf("got object stored, want upload to be cut off")
+	}
+}
+
+func TestResponseHeaders(t *testing.T) {
+	var tests = []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"successful get", "/healthz", http.StatusOK},
+		{"error", "/", http.StatusBadRequest},
+	}
+
+	s := newTestServer(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.httpServer.Handler.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, test.status)
+			}
+			if got := w.Header().Get("Server"); got != "bucket" {
+				t.Errorf("got server: '%s', want server: '%s'", got, "bucket")
+			}
+			if _, err := http.ParseTime(w.Header().Get("Date")); err != nil {
+				t.Errorf("got date: '%s', want RFC1123 date", w.Header().Get("Date"))
+			}
+			if len(w.Header().Get("x-amz-request-id")) != 16 {
+				t.Errorf("got request id: '%s', want 16 characters", w.Header().Get("x-amz-request-id"))
+			}
+		})
+	}
+}
+
+// countingReader records how many bytes were read from it.
+type countingReader struct {
+	r    io.Reader
+	read int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += n
+	return n, err
+}
+
+func TestUnauthorizedBodyNotRead(t *testing.T) {
+	var tests = []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"missing authorization", "", http.StatusUnauthorized},
+		{
+			"unknown access key",
+			"AWS4-HMAC-SHA256 Credential=unknown/20240101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=abc",
+			http.StatusForbidden,
+		},
+		{
+			"malformed authorization",
+			"AWS4-HMAC-SHA256 Credential=test-access-key, SignedHeaders=host, Signature=abc",
+			http.StatusBadRequest,
+		},
+		{
+			"wrong signature",
+			"AWS4-HMAC-SHA256 Credential=test-access-key/20240101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=abc",
+			http.StatusForbidden,
+		},
+	}
+
+	s := newTestServer(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := &countingReader{r: strings.NewReader(strings.Repeat("a", 1<<20))}
+			r := httptest.NewRequest("PUT", "/bucket/key", body)
+			r.Header.Set("Expect", "100-continue")
+			r.Header.Set("X-Amz-Content-Sha256", domain.Sha256Hash([]byte("anything")))
+			if len(test.authorization) > 0 {
+				r.Header.Set("Authorization", test.authorization)
+			}
+
+			w := httptest.NewRecorder()
+			s.router.ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, test.status)
+			}
+			if body.read > 0 {
+				t.Errorf("got read bytes: '%d', want read bytes: '0'", body.read)
+			}
+		})
+	}
+}
+
+func TestCreateBucket(t *testing.T) {
+	var tests = []struct {
+		name   string
+		bucket string
+		body   string
+		status int
+	}{
+		{"empty configuration", "empty", "", http.StatusOK},
+		{
+			"matching region",
+			"matching",
+			`<CreateBucketConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+	<LocationConstraint>eu-central-1</LocationConstraint>
+</CreateBucketConfiguration>`,
+			http.StatusOK,
+		},
+		{
+			"mismatching region",
+			"mismatching",
+			`<CreateBucketConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+	<LocationConstraint>us-west-2</LocationConstraint>
+</CreateBucketConfiguration>`,
+			http.StatusBadRequest,
+		},
+	}
+
+	storage, err := domain.NewStorage(t.TempDir(), domain.WithRegion("eu-central-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New("8000", domain.NewAuth("test-access-key", "test-secret-key"), storage)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/"+test.bucket, strings.NewReader(test.body))
+			r.SetPathValue("name", test.bucket)
+			r = r.WithContext(context.WithValue(r.Context(), accessKeyCtx, "test-access-key"))
+
+			w := httptest.NewRecorder()
+			s.createBucket(w, r)
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, test.status)
+			}
+			if test.status != http.StatusOK {
+				if !strings.Contains(w.Body.String(), "InvalidLocationConstraint") {
+					t.Errorf("got body: '%s', want code: 'InvalidLocationConstraint'", w.Body.String())
+				}
+				if _, err := storage.Bucket(test.bucket); err == nil {
+					t.Errorf("got bucket: '%s', want no bucket", test.bucket)
+				}
+				return
+			}
+			if got := w.Header().Get("Location"); got != "/"+test.bucket {
+				t.Errorf("got location: '%s', want location: '/%s'", got, test.bucket)
+			}
+			if w.Body.Len() > 0 {
+				t.Errorf("got body: '%s', want empty body", w.Body.String())
+			}
+			info, err := storage.Bucket(test.bucket)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Region != "eu-central-1" {
+				t.Errorf("got region: '%s', want region: 'eu-central-1'", info.Region)
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.storage.Put("bucket", "key", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		s.httpServer.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status: '%d', want status: '%d'", w.Code, http.StatusOK)
+		}
+		return w.Body.String()
+	}
+
+	for range 3 {
+		s.httpServer.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	}
+	s.httpServer.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/", nil))
+	body := scrape()
+
+	for _, want := range []string{
+		`bucket_requests_total{method="GET",status="200"} 3`,
+		`bucket_requests_total{method="DELETE",status="405"} 1`,
+		`bucket_request_duration_seconds_count{method="GET"} 3`,
+		`bucket_request_duration_seconds_bucket{method="GET",le="+Inf"} 3`,
+		"bucket_written_bytes_total ",
+		"bucket_buckets 1",
+		"bucket_objects 1",
+		"bucket_used_bytes 5",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("got metrics: '%s', want line: '%s'", body, want)
+		}
+	}
+
+	// the previous scrape is counted as well
+	if body := scrape(); !strings.Contains(body, `bucket_requests_total{method="GET",status="200"} 4`) {
+		t.Errorf("got metrics: '%s', want counter incremented", body)
+	}
+}
+
+func TestMetricsFlush(t *testing.T) {
+	s := newTestServer(t)
+	s.router.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("part"))
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("got error: '%v', want flush", err)
+		}
+	})
+	w := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/stream", nil))
+	if !w.Flushed {
+		t.Errorf("got flushed: '%t', want flushed: 'true'", w.Flushed)
+	}
+}
+
+func TestMetricsPort(t *testing.T) {
+	s := newTestServer(t, WithMetricsPort("9000"))
+	w := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code == http.StatusOK {
+		t.Errorf("got status: '%d' on the api port, want metrics only on the metrics port", w.Code)
+	}
+	w = httptest.NewRecorder()
+	s.metricsServer.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got status: '%d', want status: '%d'", w.Code, http.StatusOK)
+	}
+}
+
+func TestConnectionMetrics(t *testing.T) {
+	s := newTestServer(t)
+	// the request stays in flight until it receives from release
+	release := make(chan struct{})
+	s.router.HandleFunc("/in-flight", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.serve(ln) }()
+	defer func() {
+		// a failed test must not leave the request blocking the shutdown
+		close(release)
+		if err := s.Shutdown(context.Background()); err != nil {
+			t.Error(err)
+		}
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// waitFor scrapes the metrics without a connection of its own until
+	// they contain the line
+	waitFor := func(want string) {
+		t.Helper()
+		var body strings.Builder
+		for range 100 {
+			body.Reset()
+			s.metrics.write(&body)
+			if strings.Contains(body.String(), want) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Errorf("got metrics: '%s', want line: '%s'", body.String(), want)
+	}
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "GET /in-flight HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(`bucket_connections{state="active"} 1`)
+
+	release <- struct{}{}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !resp.Close {
+		t.Errorf("got status: '%d', close: '%t', want status: '200', close: 'true'", resp.StatusCode, resp.Close)
+	}
+	waitFor(`bucket_connections{state="active"} 0`)
+	waitFor(`bucket_connections{state="idle"} 0`)
+}
+
+func TestCORS(t *testing.T) {
+	var tests = []struct {
+		name        string
+		method      string
+		origin      string
+		reqMethod   string
+		reqHeaders  string
+		status      int
+		allowOrigin string
+	}{
+		{"preflight allowed", "OPTIONS", "https://app.example.com", "PUT", "Content-Type, X-Amz-Date", http.StatusOK, "https://app.example.com"},
+		{"preflight disallowed origin", "OPTIONS", "https://evil.com", "PUT", "", http.StatusForbidden, ""},
+		{"preflight disallowed method", "OPTIONS", "https://app.example.com", "POST", "", http.StatusForbidden, ""},
+		{"preflight disallowed header", "OPTIONS", "https://app.example.com", "PUT", "x-custom", http.StatusForbidden, ""},
+		{"actual request allowed", "GET", "https://app.example.com", "", "", http.StatusOK, "https://app.example.com"},
+		{"actual request disallowed", "GET", "https://evil.com", "", "", http.StatusOK, ""},
+	}
+
+	s := newTestServer(t, WithCORS(
+		[]string{"https://*.example.com"},
+		[]string{"GET", "PUT"},
+		[]string{"content-type", "x-amz-*"},
+	))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "/healthz", nil)
+			r.Header.Set("Origin", test.origin)
+			if len(test.reqMethod) > 0 {
+				r.Header.Set("Access-Control-Request-Method", test.reqMethod)
+			}
+			if len(test.reqHeaders) > 0 {
+				r.Header.Set("Access-Control-Request-Headers", test.reqHeaders)
+			}
+
+			w := httptest.NewRecorder()
+			s.httpServer.Handler.ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, test.status)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.allowOrigin {
+				t.Errorf("got allow origin: '%s', want allow origin: '%s'", got, test.allowOrigin)
+			}
+		})
+	}
+}
+
+func TestBucketCORS(t *testing.T) {
+	s := newTestServer(t, WithCORS([]string{"*"}, []string{"GET"}, []string{"*"}))
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("PUT", "/bucket?cors", strings.NewReader(`<CORSConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+	<CORSRule>
+		<AllowedOrigin>https://app.example.com</AllowedOrigin>
+		<AllowedMethod>PUT</AllowedMethod>
+		<AllowedHeader>*</AllowedHeader>
+		<ExposeHeader>ETag</ExposeHeader>
+		<MaxAgeSeconds>600</MaxAgeSeconds>
+	</CORSRule>
+</CORSConfiguration>`))
+	r.SetPathValue("name", "bucket")
+	w := httptest.NewRecorder()
+	s.putBucketCORS(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status: '%d', want status: '%d'", w.Code, http.StatusOK)
+	}
+
+	r = httptest.NewRequest("GET", "/bucket?cors", nil)
+	r.SetPathValue("name", "bucket")
+	w = httptest.NewRecorder()
+	s.getBucketCORS(w, r)
+	if !strings.Contains(w.Body.String(), "<AllowedOrigin>https://app.example.com</AllowedOrigin>") {
+		t.Errorf("got body: '%s', want stored rule", w.Body.String())
+	}
+
+	var tests = []struct {
+		name   string
+		path   string
+		origin string
+		method string
+		status int
+	}{
+		{"bucket rule allows", "/bucket/key", "https://app.example.com", "PUT", http.StatusOK},
+		{"bucket rule replaces server rule", "/bucket/key", "https://other.com", "GET", http.StatusForbidden},
+		{"server rule without bucket rules", "/healthz", "https://other.com", "GET", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("OPTIONS", test.path, nil)
+			r.Header.Set("Origin", test.origin)
+			r.Header.Set("Access-Control-Request-Method", test.method)
+			w := httptest.NewRecorder()
+			s.httpServer.Handler.ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, test.status)
+			}
+		})
+	}
+
+	r = httptest.NewRequest("PUT", "/bucket?cors", strings.NewReader(`<CORSConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+	<CORSRule>
+		<AllowedOrigin>*</AllowedOrigin>
+		<AllowedMethod>PATCH</AllowedMethod>
+	</CORSRule>
+</CORSConfiguration>`))
+	r.SetPathValue("name", "bucket")
+	w = httptest.NewRecorder()
+	s.putBucketCORS(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "MalformedXML") {
+		t.Errorf("got status: '%d', want status: '%d' with code 'MalformedXML'", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDeleteObjectMissing(t *testing.T) {
+	var tests = []struct {
+		name   string
+		bucket string
+		status int
+	}{
+		{"missing key", "bucket", http.StatusNoContent},
+		{"missing bucket", "missing", http.StatusNotFound},
+	}
+
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("DELETE", "/"+test.bucket+"/missing", nil)
+			r.SetPathValue("name", test.bucket)
+			r.SetPathValue("key", "missing")
+			w := httptest.NewRecorder()
+			s.deleteObject(w, r)
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestZeroByteObject(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("PUT", "/bucket/empty", strings.NewReader(""))
+	r.SetPathValue("name", "bucket")
+	r.SetPathValue("key", "empty")
+	w := httptest.NewRecorder()
+	s.putObject(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status: '%d', want status: '%d'", w.Code, http.StatusNoContent)
+	}
+
+	for _, method := range []string{"GET", "HEAD"} {
+		t.Run(method, func(t *testing.T) {
+			r := httptest.NewRequest(method, "/bucket/empty", nil)
+			r.SetPathValue("name", "bucket")
+			r.SetPathValue("key", "empty")
+			w := httptest.NewRecorder()
+			if method == "GET" {
+				s.getObject(w, r)
+			} else {
+				s.headObject(w, r)
+			}
+			if w.Code != http.StatusOK {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, http.StatusOK)
+			}
+			if got := w.Header().Get("Content-Length"); got != "0" {
+				t.Errorf("got content length: '%s', want content length: '0'", got)
+			}
+			if w.Body.Len() != 0 {
+				t.Errorf("got body: '%s', want empty body", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPresignedGet(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.storage.Put("bucket", "key", []byte("hello world!")); err != nil {
+		t.Fatal(err)
+	}
+
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	r := httptest.NewRequest("GET", "http://localhost:8000/bucket/key", nil)
+	signer.Presign(r, time.Minute)
+	// the request as it arrives at the server only carries the URL
+	r = httptest.NewRequest("GET", r.URL.RequestURI(), nil)
+	r.Host = "localhost:8000"
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status: '%d', want status: '%d' (%s)", w.Code, http.StatusOK, w.Body.String())
+	}
+	if w.Body.String() != "hello world!" {
+		t.Errorf("got body: '%s', want body: 'hello world!'", w.Body.String())
+	}
+}
+
+func TestPublicBucket(t *testing.T) {
+	s := newTestServer(t)
+	for _, bucket := range []string{"public", "private"} {
+		if err := s.storage.NewBucket(bucket, "test-access-key"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.storage.Put(bucket, "key", []byte("hello world!")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.storage.SetBucketACL("public", domain.ACLPublicRead); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{"get public object", "GET", "/public/key", http.StatusOK},
+		{"head public object", "HEAD", "/public/key", http.StatusOK},
+		{"list public bucket", "GET", "/public", http.StatusOK},
+		{"get private object", "GET", "/private/key", http.StatusForbidden},
+		{"put public object", "PUT", "/public/key", http.StatusBadRequest},
+		{"delete public object", "DELETE", "/public/key", http.StatusBadRequest},
+		{"get public acl", "GET", "/public?acl", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, httptest.NewRequest(test.method, test.path, strings.NewReader("")))
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, test.status)
+			}
+		})
+	}
+
+	body, err := s.storage.Get("public", "key")
+	if err != nil || string(body) != "hello world!" {
+		t.Errorf("got body: '%s', want public object unchanged (%v)", body, err)
+	}
+}
+
+func TestWebsite(t *testing.T) {
+	s := newTestServer(t)
+	for _, bucket := range []string{"site", "private-site", "no-error-doc"} {
+		if err := s.storage.NewBucket(bucket, "test-access-key"); err != nil {
+			t.Fatal(err)
+		}
+		objects := map[string]string{
+			"index.html":      "root index",
+			"docs/index.html": "docs index",
+			"404.html":        "not found page",
+			"style.css":       "body {}",
+		}
+		for key, body := range objects {
+			if err := s.storage.Put(bucket, key, []byte(body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		config := &domain.WebsiteConfiguration{IndexDocument: "index.html", ErrorDocument: "404.html"}
+		if bucket == "no-error-doc" {
+			config.ErrorDocument = ""
+		}
+		if err := s.storage.SetBucketWebsite(bucket, config); err != nil {
+			t.Fatal(err)
+		}
+		if bucket != "private-site" {
+			if err := s.storage.SetBucketACL(bucket, domain.ACLPublicRead); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var tests = []struct {
+		name   string
+		path   string
+		status int
+		body   string
+	}{
+		{"bucket root", "/site", http.StatusOK, "root index"},
+		{"bucket root with slash", "/site/", http.StatusOK, "root index"},
+		{"directory", "/site/docs/", http.StatusOK, "docs index"},
+		{"object", "/site/style.css", http.StatusOK, "body {}"},
+		{"missing object", "/site/missing.html", http.StatusNotFound, "not found page"},
+		{"missing index", "/site/missing/", http.StatusNotFound, "not found page"},
+		{"without error document", "/no-error-doc/missing.html", http.StatusNotFound, ""},
+		{"private bucket", "/private-site/", http.StatusForbidden, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, test.status)
+			}
+			if len(test.body) > 0 && w.Body.String() != test.body {
+				t.Errorf("got body: '%s', want body: '%s'", w.Body.String(), test.body)
+			}
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	var tests = []struct {
+		name   string
+		header string
+		size   int64
+		want   *byteRange
+		err    bool
+	}{
+		{"no header", "", 10, nil, false},
+		{"closed range", "bytes=2-5", 10, &byteRange{2, 5}, false},
+		{"open range", "bytes=4-", 10, &byteRange{4, 9}, false},
+		{"suffix range", "bytes=-3", 10, &byteRange{7, 9}, false},
+		{"suffix larger than object", "bytes=-30", 10, &byteRange{0, 9}, false},
+		{"end beyond object", "bytes=8-100", 10, &byteRange{8, 9}, false},
+		{"start beyond object", "bytes=10-12", 10, nil, true},
+		{"empty suffix", "bytes=-0", 10, nil, true},
+		{"empty object", "bytes=0-1", 0, nil, true},
+		{"multiple ranges", "bytes=0-1,4-5", 10, nil, false},
+		{"reversed range", "bytes=5-2", 10, nil, false},
+		{"other unit", "items=0-1", 10, nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseRange(test.header, test.size)
+			if (err != nil) != test.err {
+				t.Fatalf("got error: '%v', want error: '%t'", err, test.err)
+			}
+			if (got == nil) != (test.want == nil) || (got != nil && *got != *test.want) {
+				t.Errorf("got range: '%v', want range: '%v'", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAcceptRanges(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.storage.Put("bucket", "key", []byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name   string
+		method string
+		rng    string
+		status int
+		body   string
+	}{
+		{"get", "GET", "", http.StatusOK, "0123456789"},
+		{"head", "HEAD", "", http.StatusOK, ""},
+		{"get range", "GET", "bytes=2-4", http.StatusPartialContent, "234"},
+		{"get invalid range", "GET", "bytes=20-", http.StatusRequestedRangeNotSatisfiable, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "/bucket/key", nil)
+			r.SetPathValue("name", "bucket")
+			r.SetPathValue("key", "key")
+			if len(test.rng) > 0 {
+				r.Header.Set("Range", test.rng)
+			}
+			w := httptest.NewRecorder()
+			if test.method == "GET" {
+				s.getObject(w, r)
+			} else {
+				s.headObject(w, r)
+			}
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, test.status)
+			}
+			if w.Code < 300 && w.Header().Get("Accept-Ranges") != "bytes" {
+				t.Errorf("got accept ranges: '%s', want accept ranges: 'bytes'", w.Header().Get("Accept-Ranges"))
+			}
+			if len(test.body) > 0 && w.Body.String() != test.body {
+				t.Errorf("got body: '%s', want body: '%s'", w.Body.String(), test.body)
+			}
+		})
+	}
+}
+
+func TestHeadRange(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.storage.Put("bucket", "key", []byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name         string
+		rng          string
+		status       int
+		length       string
+		contentRange string
+	}{
+		{"without range", "", http.StatusOK, "10", ""},
+		{"range", "bytes=2-4", http.StatusPartialContent, "3", "bytes 2-4/10"},
+		{"open range", "bytes=7-", http.StatusPartialContent, "3", "bytes 7-9/10"},
+		{"suffix range", "bytes=-4", http.StatusPartialContent, "4", "bytes 6-9/10"},
+		{"invalid range", "bytes=20-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("HEAD", "/bucket/key", nil)
+			r.SetPathValue("name", "bucket")
+			r.SetPathValue("key", "key")
+			if len(test.rng) > 0 {
+				r.Header.Set("Range", test.rng)
+			}
+			w := httptest.NewRecorder()
+			s.headObject(w, r)
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, test.status)
+			}
+			if got := w.Header().Get("Content-Range"); got != test.contentRange {
+				t.Errorf("got content range: '%s', want content range: '%s'", got, test.contentRange)
+			}
+			if got := w.Header().Get("Content-Length"); len(test.length) > 0 && got != test.length {
+				t.Errorf("got content length: '%s', want content length: '%s'", got, test.length)
+			}
+			if w.Code < 300 && w.Body.Len() > 0 {
+				t.Errorf("got body: '%s', want no body", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.storage.Put("bucket", "key", []byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.storage.Head("bucket", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := info.LastModified.UTC().Format(http.TimeFormat)
+	earlier := info.LastModified.Add(-time.Hour).UTC().Format(http.TimeFormat)
+
+	var tests = []struct {
+		name    string
+		ifRange string
+		status  int
+		body    string
+	}{
+		{"matching etag", info.ETag, http.StatusPartialContent, "234"},
+		{"changed etag", `"0123456789abcdef0123456789abcdef"`, http.StatusOK, "0123456789"},
+		{"weak etag", "W/" + info.ETag, http.StatusOK, "0123456789"},
+		{"matching date", modified, http.StatusPartialContent, "234"},
+		{"other date", earlier, http.StatusOK, "0123456789"},
+		{"invalid", "yesterday", http.StatusOK, "0123456789"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, method := range []string{"GET", "HEAD"} {
+				r := httptest.NewRequest(method, "/bucket/key", nil)
+				r.SetPathValue("name", "bucket")
+				r.SetPathValue("key", "key")
+				r.Header.Set("Range", "bytes=2-4")
+				r.Header.Set("If-Range", test.ifRange)
+				w := httptest.NewRecorder()
+				if method == "GET" {
+					s.getObject(w, r)
+				} else {
+					s.headObject(w, r)
+				}
+				if w.Code != test.status {
+					t.Errorf("%s: got status: '%d', want status: '%d'", method, w.Code, test.status)
+				}
+				if method == "GET" && w.Body.String() != test.body {
+					t.Errorf("got body: '%s', want body: '%s'", w.Body.String(), test.body)
+				}
+				if w.Code == http.StatusOK && len(w.Header().Get("Content-Range")) > 0 {
+					t.Errorf("%s: got content range: '%s', want no content range", method, w.Header().Get("Content-Range"))
+				}
+			}
+		})
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	var tests = []struct {
+		name   string
+		method string
+		path   string
+		allow  string
+	}{
+		{"root", "DELETE", "/", "GET"},
+		{"bucket", "PATCH", "/bucket", "DELETE, GET, HEAD, POST, PUT"},
+		{"object", "PATCH", "/bucket/key", "DELETE, GET, HEAD, POST, PUT"},
+	}
+
+	s := newTestServer(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+			if w.Code != http.StatusMethodNotAllowed {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, http.StatusMethodNotAllowed)
+			}
+			if got := w.Header().Get("Allow"); got != test.allow {
+				t.Errorf("got allow: '%s', want allow: '%s'", got, test.allow)
+			}
+		})
+	}
+}
+
+func TestDryRunPut(t *testing.T) {
+	var tests = []struct {
+		name   string
+		bucket string
+		key    string
+		body   string
+		status int
+	}{
+		{"valid upload", "bucket", "key", "hello", http.StatusOK},
+		{"missing bucket", "missing", "key", "hello", http.StatusNotFound},
+		{"invalid key", "bucket", "../key", "hello", http.StatusBadRequest},
+		{"quota exceeded", "bucket", "key", "hello world!", http.StatusConflict},
+	}
+
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.storage.SetBucketQuota("bucket", 10); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/"+test.bucket+"/"+test.key, strings.NewReader(test.body))
+			r.Header.Set("x-amz-dry-run", "true")
+			r.SetPathValue("name", test.bucket)
+			r.SetPathValue("key", test.key)
+			w := httptest.NewRecorder()
+			s.putObject(w, r)
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, test.status)
+			}
+		})
+	}
+
+	result, err := s.storage.List("bucket", domain.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Objects) != 0 {
+		t.Errorf("got objects: '%d', want objects: '0'", len(result.Objects))
+	}
+	count, size, err := s.storage.BucketStats("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 || size != 0 {
+		t.Errorf("got stats: '%d/%d', want stats: '0/0'", count, size)
+	}
+}
+
+func TestBodilessMethods(t *testing.T) {
+	var tests = []struct {
+		name    string
+		method  string
+		body    string
+		chunked bool
+		hash    string
+		status  int
+	}{
+		{"get", "GET", "", false, "", http.StatusOK},
+		{"get with body", "GET", "hello", false, "", http.StatusBadRequest},
+		{"get with chunked body", "GET", "hello", true, "", http.StatusBadRequest},
+		{"get with unsigned payload", "GET", "", false, "UNSIGNED-PAYLOAD", http.StatusBadRequest},
+		{"head with body", "HEAD", "hello", false, "", http.StatusBadRequest},
+		{"delete with body", "DELETE", "hello", false, "", http.StatusBadRequest},
+	}
+
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.storage.Put("bucket", "key", []byte("hello world!")); err != nil {
+		t.Fatal(err)
+	}
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "/bucket/key", strings.NewReader(test.body))
+			if len(test.body) < 1 {
+				r = httptest.NewRequest(test.method, "/bucket/key", nil)
+			}
+			if test.chunked {
+				r.ContentLength = -1
+				r.TransferEncoding = []string{"chunked"}
+			}
+			if len(test.hash) > 0 {
+				signer.SignPayload(r, test.hash)
+			} else {
+				signer.Sign(r, []byte(test.body))
+			}
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d' (%s)", w.Code, test.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestTrustedProxy(t *testing.T) {
+	var tests = []struct {
+		name      string
+		trust     bool
+		host      string
+		forwarded string
+		proto     string
+		status    int
+	}{
+		{"direct", true, "s3.example.com", "", "", http.StatusOK},
+		{"forwarded host", true, "backend:8000", "s3.example.com", "", http.StatusOK},
+		{"forwarded default port", true, "backend:8000", "s3.example.com:443", "https", http.StatusOK},
+		{"forwarded other port", true, "backend:8000", "s3.example.com:8443", "https", http.StatusForbidden},
+		{"forwarded by proxy chain", true, "backend:8000", "s3.example.com, proxy.internal", "http", http.StatusOK},
+		{"untrusted forwarded host", false, "backend:8000", "s3.example.com", "", http.StatusForbidden},
+	}
+
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := []Option{}
+			if test.trust {
+				opts = append(opts, WithTrustedProxy())
+			}
+			s := newTestServer(t, opts...)
+			if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+
+			// the client signs the host it addressed, the proxy rewrites it
+			r := httptest.NewRequest("HEAD", "/bucket", nil)
+			r.Host = "s3.example.com"
+			signer.Sign(r, nil)
+			r.Host = test.host
+			if len(test.forwarded) > 0 {
+				r.Header.Set("X-Forwarded-Host", test.forwarded)
+			}
+			if len(test.proto) > 0 {
+				r.Header.Set("X-Forwarded-Proto", test.proto)
+			}
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d' (%s)", w.Code, test.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPutIfNoneMatch(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []int{http.StatusNoContent, http.StatusPreconditionFailed} {
+		r := httptest.NewRequest("PUT", "/bucket/key", strings.NewReader("hello"))
+		r.Header.Set("If-None-Match", "*")
+		r.SetPathValue("name", "bucket")
+		r.SetPathValue("key", "key")
+		w := httptest.NewRecorder()
+		s.putObject(w, r)
+		if w.Code != want {
+			t.Errorf("got status: '%d', want status: '%d'", w.Code, want)
+		}
+	}
+}
+
+func TestListBucketsOwner(t *testing.T) {
+	var tests = []struct {
+		name    string
+		key     string
+		buckets []string
+	}{
+		{"first owner", "first-key", []string{"first-bucket"}},
+		{"second owner", "second-key", []string{"second-bucket"}},
+		{"admin", "admin-key", []string{"first-bucket", "second-bucket"}},
+		{"no buckets", "other-key", []string{}},
+	}
+
+	s := newTestServer(t, WithAdminKey("admin-key"))
+	if err := s.storage.NewBucket("first-bucket", "first-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.storage.NewBucket("second-bucket", "second-key"); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r = r.WithContext(context.WithValue(r.Context(), accessKeyCtx, test.key))
+			w := httptest.NewRecorder()
+			s.listBuckets(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status: '%d', want status: '%d'", w.Code, http.StatusOK)
+			}
+
+			result := &listAllMyBucketsResult{}
+			if err := xml.Unmarshal(w.Body.Bytes(), result); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, b := range result.Buckets {
+				got = append(got, b.Name)
+			}
+			if strings.Join(got, ",") != strings.Join(test.buckets, ",") {
+				t.Errorf("got buckets: '%v', want buckets: '%v'", got, test.buckets)
+			}
+			if result.Owner.ID != test.key {
+				t.Errorf("got owner: '%s', want owner: '%s'", result.Owner.ID, test.key)
+			}
+		})
+	}
+}
+
+func TestListObjectsFetchOwner(t *testing.T) {
+	var tests = []struct {
+		name  string
+		query string
+		owner string
+	}{
+		{"without owner", "", ""},
+		{"fetch owner", "&fetch-owner=true", "test-access-key"},
+		{"fetch owner disabled", "&fetch-owner=false", ""},
+	}
+
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.storage.Put("bucket", "key", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/bucket?list-type=2"+test.query, nil)
+			r.SetPathValue("name", "bucket")
+			w := httptest.NewRecorder()
+			s.listObjects(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status: '%d', want status: '%d'", w.Code, http.StatusOK)
+			}
+
+			result := &listBucketResult{}
+			if err := xml.Unmarshal(w.Body.Bytes(), result); err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Contents) != 1 {
+				t.Fatalf("got objects: '%d', want objects: '1'", len(result.Contents))
+			}
+			got := ""
+			if result.Contents[0].Owner != nil {
+				got = result.Contents[0].Owner.ID
+			}
+			if got != test.owner {
+				t.Errorf("got owner: '%s', want owner: '%s'", got, test.owner)
+			}
+			if hasOwner := strings.Contains(w.Body.String(), "<Owner>"); hasOwner != (len(test.owner) > 0) {
+				t.Errorf("got owner element: '%t', want owner element: '%t'", hasOwner, len(test.owner) > 0)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	var ctxID string
+	handler := headers(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxID = requestID(r)
+		writeError(w, errors.New("disk on fire"))
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/bucket/key", nil))
+
+	id := w.Header().Get("x-amz-request-id")
+	if len(id) < 1 {
+		t.Fatal("response is missing the x-amz-request-id header")
+	}
+	if ctxID != id {
+		t.Errorf("got context id: '%s', want context id: '%s'", ctxID, id)
+	}
+	resp := &errorResponse{}
+	if err := xml.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.RequestID != id {
+		t.Errorf("got request id: '%s', want request id: '%s'", resp.RequestID, id)
+	}
+	if !strings.Contains(logs.String(), id) {
+		t.Errorf("got log: '%s', want log containing: '%s'", logs.String(), id)
+	}
+}
+
+func TestContentLengthRequired(t *testing.T) {
+	var tests = []struct {
+		name     string
+		path     string
+		body     string
+		length   int64
+		header   string
+		encoding []string
+		status   int
+	}{
+		{"missing length", "/bucket/key", "hello", -1, "", nil, http.StatusLengthRequired},
+		{"present length", "/bucket/key", "hello", -1, "5", nil, http.StatusNoContent},
+		{"parsed length", "/bucket/key", "hello", 5, "", nil, http.StatusNoContent},
+		{"chunked", "/bucket/key", "hello", -1, "", []string{"chunked"}, http.StatusNoContent},
+		{"create bucket", "/other", "", -1, "", nil, http.StatusOK},
+		{"bucket acl", "/bucket?acl", "", -1, "", nil, http.StatusOK},
+	}
+
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", test.path, strings.NewReader(test.body))
+			r.Header.Set("x-amz-acl", domain.ACLPrivate)
+			signer.Sign(r, []byte(test.body))
+			r.ContentLength = test.length
+			if len(test.header) > 0 {
+				r.Header.Set("Content-Length", test.header)
+			}
+			r.TransferEncoding = test.encoding
+
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d' (%s)", w.Code, test.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestResponseOverrides(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.storage.Put("bucket", "report.csv", []byte("a,b,c")); err != nil {
+		t.Fatal(err)
+	}
+
+	query := "?response-content-type=text%2Fcsv" +
+		"&response-content-disposition=attachment%3B%20filename%3D%22report.csv%22" +
+		"&response-cache-control=no-cache" +
+		"&response-content-encoding=identity"
+	want := map[string]string{
+		"Content-Type":        "text/csv",
+		"Content-Disposition": `attachment; filename="report.csv"`,
+		"Cache-Control":       "no-cache",
+		"Content-Encoding":    "identity",
+	}
+
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	r := httptest.NewRequest("GET", "http://localhost:8000/bucket/report.csv"+query, nil)
+	signer.Presign(r, time.Minute)
+	r = httptest.NewRequest("GET", r.URL.RequestURI(), nil)
+	r.Host = "localhost:8000"
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status: '%d', want status: '%d' (%s)", w.Code, http.StatusOK, w.Body.String())
+	}
+	for header, value := range want {
+		if got := w.Header().Get(header); got != value {
+			t.Errorf("got %s: '%s', want %s: '%s'", header, got, header, value)
+		}
+	}
+
+	// anonymous requests must not override headers
+	if err := s.storage.SetBucketACL("bucket", domain.ACLPublicRead); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/bucket/report.csv"+query, nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status: '%d', want status: '%d'", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	var tests = []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		want   string
+	}{
+		{"list bucket with trailing slash", "GET", "/bucket/", "", http.StatusOK, "<Name>bucket</Name>"},
+		{"head bucket with trailing slash", "HEAD", "/bucket/", "", http.StatusOK, ""},
+		{"put doubled slashes", "PUT", "/bucket/dir//key", "hello", http.StatusNoContent, ""},
+		{"get collapsed key", "GET", "/bucket/dir/key", "", http.StatusOK, "hello"},
+		{"get doubled slashes", "GET", "/bucket//dir///key", "", http.StatusOK, "hello"},
+	}
+
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			signer.Sign(r, []byte(test.body))
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d' (%s)", w.Code, test.status, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), test.want) {
+				t.Errorf("got body: '%s', want body containing: '%s'", w.Body.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestSignatureDebug(t *testing.T) {
+	var tests = []struct {
+		name  string
+		opts  []Option
+		debug bool
+	}{
+		{"default", nil, false},
+		{"debug mode", []Option{WithSignatureDebug()}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t, test.opts...)
+			r := httptest.NewRequest("GET", "/bucket/key", nil)
+			domain.NewSigner("test-access-key", "wrong-secret-key", "us-east-1").Sign(r, nil)
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, http.StatusForbidden)
+			}
+
+			resp := &errorResponse{}
+			if err := xml.Unmarshal(w.Body.Bytes(), resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != "SignatureDoesNotMatch" {
+				t.Errorf("got code: '%s', want code: 'SignatureDoesNotMatch'", resp.Code)
+			}
+			if got := strings.HasPrefix(resp.CanonicalRequest, "GET\n/bucket/key\n"); got != test.debug {
+				t.Errorf("got canonical request: '%s', want it included: '%t'", resp.CanonicalRequest, test.debug)
+			}
+			if got := strings.HasPrefix(resp.StringToSign, "AWS4-HMAC-SHA256\n"); got != test.debug {
+				t.Errorf("got string to sign: '%s', want it included: '%t'", resp.StringToSign, test.debug)
+			}
+		})
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	body := []byte(strings.Repeat("a", 1<<20))
+	var tests = []struct {
+		name      string
+		path      string
+		secretKey string
+		status    int
+	}{
+		{"quota exceeded", "/bucket/key", "test-secret-key", http.StatusConflict},
+		{"missing bucket", "/missing/key", "test-secret-key", http.StatusNotFound},
+		{"wrong signature", "/bucket/key", "wrong-secret-key", http.StatusForbidden},
+		{"accepted", "/bucket/small", "test-secret-key", http.StatusContinue},
+	}
+
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.storage.SetBucketQuota("bucket", 1024); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			size := len(body)
+			if test.status == http.StatusContinue {
+				size = 16
+			}
+			r := httptest.NewRequest("PUT", test.path, nil)
+			r.Host = ts.Listener.Addr().String()
+			domain.NewSigner("test-access-key", test.secretKey, "us-east-1").Sign(r, body[:size])
+
+			// only the headers are sent, the client waits for 100 Continue
+			conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			fmt.Fprintf(conn, "PUT %s HTTP/1.1\r\nHost: %s\r\nContent-Length: %d\r\nExpect: 100-continue\r\n", test.path, r.Host, size)
+			for _, name := range []string{"Authorization", "X-Amz-Date", "X-Amz-Content-Sha256"} {
+				fmt.Fprintf(conn, "%s: %s\r\n", name, r.Header.Get(name))
+			}
+			fmt.Fprint(conn, "\r\n")
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.status {
+				t.Errorf("got status: '%d', want status: '%d'", resp.StatusCode, test.status)
+			}
+		})
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	var tests = []struct {
+		name   string
+		ready  bool
+		method string
+		path   string
+		status int
+	}{
+		{"not ready", false, "GET", "/readyz", http.StatusServiceUnavailable},
+		{"alive while not ready", false, "GET", "/healthz", http.StatusOK},
+		{"object while not ready", false, "DELETE", "/bucket/key", http.StatusServiceUnavailable},
+		{"ready", true, "GET", "/readyz", http.StatusOK},
+		{"object while ready", true, "DELETE", "/bucket/key", http.StatusNoContent},
+	}
+
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s.SetReady(test.ready)
+			r := httptest.NewRequest(test.method, test.path, nil)
+			signer.Sign(r, nil)
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Errorf("got status: '%d', want status: '%d' (%s)", w.Code, test.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestListObjectsDelimiter(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"readme.md", "docs/cv.pdf", "photos/cat.jpg", "photos/2024/a.jpg"} {
+		if err := s.storage.Put("bucket", key, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/bucket?list-type=2&delimiter=/", nil)
+	r.SetPathValue("name", "bucket")
+	w := httptest.NewRecorder()
+	s.listObjects(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status: '%d', want status: '%d'", w.Code, http.StatusOK)
+	}
+
+	result := &listBucketResult{}
+	if err := xml.Unmarshal(w.Body.Bytes(), result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Contents) != 1 || result.Contents[0].Key != "readme.md" {
+		t.Errorf("got contents: '%v', want contents: 'readme.md'", result.Contents)
+	}
+	want := []commonPrefix{{Prefix: "docs/"}, {Prefix: "photos/"}}
+	if !reflect.DeepEqual(result.CommonPrefixes, want) {
+		t.Errorf("got prefixes: '%v', want prefixes: '%v'", result.CommonPrefixes, want)
+	}
+	if result.Delimiter != "/" || result.KeyCount != 3 {
+		t.Errorf("got delimiter and key count: '%s, %d', want delimiter and key count: '/, 3'", result.Delimiter, result.KeyCount)
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+// Storage is the backend the server keeps buckets and objects in.
+// *domain.Storage stores them on the local filesystem and *domain.MemStorage
+// in memory. Errors of type *domain.Error are passed on to the client, every
+// other error becomes a 500.
+type Storage interface {
+	Region() string
+	Ready() error
+
+	NewBucket(name, owner string) error
+	Bucket(name string) (*domain.BucketInfo, error)
+	ListBuckets() ([]*domain.BucketInfo, error)
+	BucketStats(name string) (int, int64, error)
+	BucketUsage(name string) (int64, int64, error)
+	ScrubMismatches(bucket string) int
+	RepairBucket(name string) (*domain.RepairResult, error)
+	SetBucketQuota(name string, bytes int64) error
+	SetBucketMaxObjectSize(name string, bytes int64) error
+	SetBucketMaxObjects(name string, count int64) error
+	SetBucketVersioning(name, status string) error
+	BucketVersioning(name string) (string, error)
+	SetBucketACL(name, acl string) error
+	BucketACL(name string) (string, error)
+	SetBucketCORS(name string, rules []domain.CORSRule) error
+	BucketCORS(name string) ([]domain.CORSRule, error)
+	DeleteBucketCORS(name string) error
+	SetBucketWebsite(name string, config *domain.WebsiteConfiguration) error
+	BucketWebsite(name string) (*domain.WebsiteConfiguration, error)
+	DeleteBucketWebsite(name string) error
+
+	Put(bucket, key string, body []byte) error
+	PutCtx(ctx context.Context, bucket, key string, body []byte) error
+	PutObject(ctx context.Context, bucket, key string, body []byte, opts domain.PutOptions) error
+	CheckPut(bucket, key string, size int) error
+	Get(bucket, key string) ([]byte, error)
+	GetVersionCtx(ctx context.Context, bucket, key, versionID string) ([]byte, *domain.ObjectInfo, error)
+	Head(bucket, key string) (*domain.ObjectInfo, error)
+	Metadata(bucket, key, versionID string) ([]byte, error)
+	Delete(bucket, key string) error
+	DeleteCtx(ctx context.Context, bucket, key string) error
+	DeleteObject(ctx context.Context, bucket, key string, opts domain.DeleteOptions) error
+	DeletePrefix(bucket, prefix string) (int, error)
+	Rename(bucket, srcKey, dstKey string) error
+	RenameIfAbsent(bucket, srcKey, dstKey string) error
+	SetObjectRetention(bucket, key, versionID string, retention domain.ObjectLock, bypassGovernance bool) error
+	SetObjectLegalHold(bucket, key, versionID string, on bool) error
+	ObjectLock(bucket, key, versionID string) (*domain.ObjectLock, error)
+	List(bucket string, opts domain.ListOptions) (*domain.ListResult, error)
+	ListObjectVersions(bucket string) ([]*domain.ObjectVersion, error)
+
+	CreateMultipartUpload(bucket, key string, opts domain.PutOptions) (string, error)
+	UploadPart(ctx context.Context, bucket, key, uploadID string, part int, body []byte) (string, error)
+	CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []domain.CompletedPart) (string, error)
+	AbortMultipartUpload(bucket, key, uploadID string) error
+	ListMultipartUploads(bucket string) ([]*domain.MultipartUpload, error)
+}
+
+var (
+	_ Storage = (*domain.Storage)(nil)
+	_ Storage = (*domain.MemStorage)(nil)
+)
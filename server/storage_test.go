@@ -0,0 +1,889 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+// backends returns a fresh instance of every storage implementation.
+func backends(t *testing.T) map[string]Storage {
+	storage, err := domain.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Storage{
+		"filesystem": storage,
+		"memory":     domain.NewMemStorage(),
+	}
+}
+
+func TestBackends(t *testing.T) {
+	var steps = []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		header map[string]string
+		status int
+		want   string
+	}{
+		{"create bucket", "PUT", "/bucket", "", nil, http.StatusOK, ""},
+		{"create bucket again", "PUT", "/bucket", "", nil, http.StatusConflict, "BucketAlreadyOwnedByYou"},
+		{"invalid bucket name", "PUT", "/Bucket", "", nil, http.StatusBadRequest, "InvalidBucketName"},
+		{"list buckets", "GET", "/", "", nil, http.StatusOK, "<Name>bucket</Name>"},
+		{"put object", "PUT", "/bucket/dir/key", "hello world!", nil, http.StatusNoContent, ""},
+		{"get object", "GET", "/bucket/dir/key", "", nil, http.StatusOK, "hello world!"},
+		{"get range", "GET", "/bucket/dir/key", "", map[string]string{"Range": "bytes=0-4"}, http.StatusPartialContent, "hello"},
+		{"head object", "HEAD", "/bucket/dir/key", "", nil, http.StatusOK, ""},
+		{"put if absent", "PUT", "/bucket/dir/key", "again", map[string]string{"If-None-Match": "*"}, http.StatusPreconditionFailed, "PreconditionFailed"},
+		{"list objects", "GET", "/bucket?list-type=2&prefix=dir/", "", nil, http.StatusOK, "<Key>dir/key</Key>"},
+		{"bucket stats", "GET", "/bucket?stats", "", nil, http.StatusOK, "<ObjectCount>1</ObjectCount>"},
+		{"missing cors", "GET", "/bucket?cors", "", nil, http.StatusNotFound, "NoSuchCORSConfiguration"},
+		{"delete if not matching", "DELETE", "/bucket/dir/key", "", map[string]string{"If-Match": `"0123"`}, http.StatusPreconditionFailed, "PreconditionFailed"},
+		{"delete if matching", "DELETE", "/bucket/dir/key", "", map[string]string{"If-Match": domain.ETag([]byte("hello world!"))}, http.StatusNoContent, ""},
+		{"delete object", "DELETE", "/bucket/dir/key", "", nil, http.StatusNoContent, ""},
+		{"get deleted object", "GET", "/bucket/dir/key", "", nil, http.StatusNotFound, "NoSuchKey"},
+		{"delete missing object", "DELETE", "/bucket/dir/key", "", nil, http.StatusNoContent, ""},
+		{"put logs", "PUT", "/bucket/logs/a", "a", nil, http.StatusNoContent, ""},
+		{"put nested logs", "PUT", "/bucket/logs/2024/b", "b", nil, http.StatusNoContent, ""},
+		{"put other", "PUT", "/bucket/other", "c", nil, http.StatusNoContent, ""},
+		{"delete prefix", "DELETE", "/bucket?prefix=logs/", "", nil, http.StatusOK, "<Deleted>2</Deleted>"},
+		{"get deleted by prefix", "GET", "/bucket/logs/2024/b", "", nil, http.StatusNotFound, "NoSuchKey"},
+		{"get outside prefix", "GET", "/bucket/other", "", nil, http.StatusOK, "c"},
+		{"rename object", "PUT", "/bucket/renamed?renameObject", "", map[string]string{"x-amz-rename-source": "/bucket/other"}, http.StatusOK, ""},
+		{"get renamed object", "GET", "/bucket/renamed", "", nil, http.StatusOK, "c"},
+		{"rename missing object", "PUT", "/bucket/again?renameObject", "", map[string]string{"x-amz-rename-source": "/bucket/other"}, http.StatusNotFound, "NoSuchKey"},
+		{"rename across buckets", "PUT", "/bucket/again?renameObject", "", map[string]string{"x-amz-rename-source": "/other/renamed"}, http.StatusBadRequest, "InvalidArgument"},
+		{"delete empty prefix", "DELETE", "/bucket?prefix=", "", nil, http.StatusBadRequest, "InvalidArgument"},
+		{"set max object size", "PUT", "/bucket?maxObjectSize", "<MaxObjectSize><Bytes>5</Bytes></MaxObjectSize>", nil, http.StatusOK, ""},
+		{"put within max object size", "PUT", "/bucket/small", "hello", nil, http.StatusNoContent, ""},
+		{"put over max object size", "PUT", "/bucket/large", "hello world!", nil, http.StatusBadRequest, "EntityTooLarge"},
+		{"set max objects", "PUT", "/bucket?maxObjects", "<MaxObjects><Count>2</Count></MaxObjects>", nil, http.StatusOK, ""},
+		{"put over max objects", "PUT", "/bucket/more", "hello", nil, http.StatusConflict, "TooManyObjects"},
+		{"overwrite at max objects", "PUT", "/bucket/small", "hi", nil, http.StatusNoContent, ""},
+		{"remove max objects", "PUT", "/bucket?maxObjects", "<MaxObjects><Count>0</Count></MaxObjects>", nil, http.StatusOK, ""},
+		{"missing bucket", "GET", "/missing/key", "", nil, http.StatusNotFound, "NoSuchBucket"},
+		{"get from mixed case bucket", "GET", "/Bucket/dir/key", "", nil, http.StatusBadRequest, "InvalidBucketName"},
+		{"put into mixed case bucket", "PUT", "/MyBucket/key", "hello", nil, http.StatusBadRequest, "InvalidBucketName"},
+		{"delete from mixed case bucket", "DELETE", "/MyBucket/key", "", nil, http.StatusBadRequest, "InvalidBucketName"},
+	}
+
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	for name, storage := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := New("8000", domain.NewAuth("test-access-key", "test-secret-key"), storage, WithAdminKey("test-access-key"))
+			for _, step := range steps {
+				r := httptest.NewRequest(step.method, step.path, strings.NewReader(step.body))
+				r.Header.Set("Content-Length", strconv.Itoa(len(step.body)))
+				for k, v := range step.header {
+					r.Header.Set(k, v)
+				}
+				signer.Sign(r, []byte(step.body))
+
+				w := httptest.NewRecorder()
+				s.Handler().ServeHTTP(w, r)
+				body, _ := io.ReadAll(w.Body)
+				if w.Code != step.status {
+					t.Errorf("%s: got status: '%d', want status: '%d' (%s)", step.name, w.Code, step.status, body)
+				}
+				if !strings.Contains(string(body), step.want) {
+					t.Errorf("%s: got body: '%s', want body containing: '%s'", step.name, body, step.want)
+				}
+				if w.Code >= 300 && step.method != "HEAD" {
+					if err := xml.Unmarshal(body, &errorResponse{}); err != nil {
+						t.Errorf("%s: got invalid error body: '%s'", step.name, body)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestContentEncoding(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("hello world!"))
+	zw.Close()
+	body := buf.Bytes()
+
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	for name, storage := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			s := New("8000", domain.NewAuth("test-access-key", "test-secret-key"), storage)
+
+			r := httptest.NewRequest("PUT", "/bucket/hello.txt", bytes.NewReader(body))
+			r.Header.Set("Content-Encoding", "gzip")
+			signer.Sign(r, body)
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("got status: '%d', want status: '%d' (%s)", w.Code, http.StatusNoContent, w.Body.String())
+			}
+
+			for _, method := range []string{"GET", "HEAD"} {
+				r := httptest.NewRequest(method, "/bucket/hello.txt", nil)
+				signer.Sign(r, nil)
+				w := httptest.NewRecorder()
+				s.Handler().ServeHTTP(w, r)
+				if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+					t.Errorf("%s: got content encoding: '%s', want content encoding: 'gzip'", method, got)
+				}
+				if method == "GET" && !bytes.Equal(w.Body.Bytes(), body) {
+					t.Errorf("got body: '%x', want body: '%x'", w.Body.Bytes(), body)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheHeaders(t *testing.T) {
+	body := []byte("hello world!")
+	expires := "Thu, 01 Dec 2033 16:00:00 GMT"
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	for name, storage := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			s := New("8000", domain.NewAuth("test-access-key", "test-secret-key"), storage)
+
+			r := httptest.NewRequest("PUT", "/bucket/hello.txt", bytes.NewReader(body))
+			r.Header.Set("Cache-Control", "max-age=3600")
+			r.Header.Set("Expires", expires)
+			signer.Sign(r, body)
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("got status: '%d', want status: '%d' (%s)", w.Code, http.StatusNoContent, w.Body.String())
+			}
+
+			for _, method := range []string{"GET", "HEAD"} {
+				r := httptest.NewRequest(method, "/bucket/hello.txt", nil)
+				signer.Sign(r, nil)
+				w := httptest.NewRecorder()
+				s.Handler().ServeHTTP(w, r)
+				if got := w.Header().Get("Cache-Control"); got != "max-age=3600" {
+					t.Errorf("%s: got cache control: '%s', want cache control: 'max-age=3600'", method, got)
+				}
+				if got := w.Header().Get("Expires"); got != expires {
+					t.Errorf("%s: got expires: '%s', want expires: '%s'", method, got, expires)
+				}
+			}
+
+			// response-cache-control still takes precedence over the stored value
+			r = httptest.NewRequest("GET", "/bucket/hello.txt?response-cache-control=no-cache", nil)
+			signer.Sign(r, nil)
+			w = httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if got := w.Header().Get("Cache-Control"); got != "no-cache" {
+				t.Errorf("got cache control: '%s', want cache control: 'no-cache'", got)
+			}
+		})
+	}
+}
+
+func TestContentType(t *testing.T) {
+	var tests = []struct {
+		key  string
+		want string
+	}{
+		{"data.json", "application/json"},
+		{"images/cat.png", "image/png"},
+		{"index.html", "text/html; charset=utf-8"},
+		{"Photo.JPG", "image/jpeg"},
+		{"archive.unknown-extension", "application/octet-stream"},
+		{"README", "application/octet-stream"},
+	}
+
+	// the body would be sniffed as text without the extension
+	body := []byte("hello world!")
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	for name, storage := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			s := New("8000", domain.NewAuth("test-access-key", "test-secret-key"), storage)
+
+			for _, test := range tests {
+				if err := storage.Put("bucket", test.key, body); err != nil {
+					t.Fatal(err)
+				}
+				for _, method := range []string{"GET", "HEAD"} {
+					r := httptest.NewRequest(method, "/bucket/"+test.key, nil)
+					signer.Sign(r, nil)
+					w := httptest.NewRecorder()
+					s.Handler().ServeHTTP(w, r)
+					if got := w.Header().Get("Content-Type"); got != test.want {
+						t.Errorf("%s %s: got content type: '%s', want content type: '%s'", method, test.key, got, test.want)
+					}
+				}
+			}
+
+			// response-content-type still takes precedence over the extension
+			r := httptest.NewRequest("GET", "/bucket/data.json?response-content-type=text/plain", nil)
+			signer.Sign(r, nil)
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if got := w.Header().Get("Content-Type"); got != "text/plain" {
+				t.Errorf("got content type: '%s', want content type: 'text/plain'", got)
+			}
+
+			// the type sent on upload takes precedence over the extension
+			r = httptest.NewRequest("PUT", "/bucket/upload.json", bytes.NewReader(body))
+			r.Header.Set("Content-Type", "text/csv")
+			signer.Sign(r, body)
+			s.Handler().ServeHTTP(httptest.NewRecorder(), r)
+			r = httptest.NewRequest("GET", "/bucket/upload.json", nil)
+			signer.Sign(r, nil)
+			w = httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if got := w.Header().Get("Content-Type"); got != "text/csv" {
+				t.Errorf("got content type: '%s', want content type: 'text/csv'", got)
+			}
+		})
+	}
+}
+
+// TestContentLength checks that downloads announce their size instead of
+// falling back to chunked transfer encoding, which the response writer does
+// for bodies larger than its buffer without Content-Length.
+func TestContentLength(t *testing.T) {
+	var tests = []struct {
+		name   string
+		method string
+		header string
+		want   int64
+	}{
+		{"get", "GET", "", 64 << 10},
+		{"head", "HEAD", "", 64 << 10},
+		{"get range", "GET", "bytes=1024-2047", 1024},
+	}
+
+	body := bytes.Repeat([]byte("hello world!"), (64<<10)/12+1)[:64<<10]
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	compressed, err := domain.NewStorage(t.TempDir(), domain.WithCompression())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storages := backends(t)
+	storages["compressed"] = compressed
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.Put("bucket", "key", body); err != nil {
+				t.Fatal(err)
+			}
+			ts := httptest.NewServer(New("8000", domain.NewAuth("test-access-key", "test-secret-key"), storage).Handler())
+			defer ts.Close()
+
+			for _, test := range tests {
+				r, err := http.NewRequest(test.method, ts.URL+"/bucket/key", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(test.header) > 0 {
+					r.Header.Set("Range", test.header)
+				}
+				signer.Sign(r, nil)
+				resp, err := http.DefaultClient.Do(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.ContentLength != test.want {
+					t.Errorf("%s: got content length: '%d', want content length: '%d'", test.name, resp.ContentLength, test.want)
+				}
+				if len(resp.TransferEncoding) > 0 {
+					t.Errorf("%s: got transfer encoding: '%v', want transfer encoding: '[]'", test.name, resp.TransferEncoding)
+				}
+				if test.method == "GET" && int64(len(got)) != test.want {
+					t.Errorf("%s: got body length: '%d', want body length: '%d'", test.name, len(got), test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStorageClass(t *testing.T) {
+	var tests = []struct {
+		name   string
+		key    string
+		header string
+		status int
+		want   string
+	}{
+		{"default", "standard", "", http.StatusNoContent, "STANDARD"},
+		{"requested", "reduced", "REDUCED_REDUNDANCY", http.StatusNoContent, "REDUCED_REDUNDANCY"},
+		{"unknown", "unknown", "FAST", http.StatusBadRequest, ""},
+	}
+
+	body := []byte("hello world!")
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	for name, storage := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			s := New("8000", domain.NewAuth("test-access-key", "test-secret-key"), storage)
+
+			for _, test := range tests {
+				r := httptest.NewRequest("PUT", "/bucket/"+test.key, bytes.NewReader(body))
+				if len(test.header) > 0 {
+					r.Header.Set("x-amz-storage-class", test.header)
+				}
+				signer.Sign(r, body)
+				w := httptest.NewRecorder()
+				s.Handler().ServeHTTP(w, r)
+				if w.Code != test.status {
+					t.Fatalf("%s: got status: '%d', want status: '%d' (%s)", test.name, w.Code, test.status, w.Body.String())
+				}
+				if test.status != http.StatusNoContent {
+					if !strings.Contains(w.Body.String(), "<Code>InvalidStorageClass</Code>") {
+						t.Errorf("%s: got body: '%s', want code: 'InvalidStorageClass'", test.name, w.Body.String())
+					}
+					continue
+				}
+
+				for _, method := range []string{"GET", "HEAD"} {
+					r := httptest.NewRequest(method, "/bucket/"+test.key, nil)
+					signer.Sign(r, nil)
+					w := httptest.NewRecorder()
+					s.Handler().ServeHTTP(w, r)
+					if got := w.Header().Get("x-amz-storage-class"); got != test.want {
+						t.Errorf("%s %s: got storage class: '%s', want storage class: '%s'", test.name, method, got, test.want)
+					}
+				}
+			}
+
+			r := httptest.NewRequest("GET", "/bucket?list-type=2", nil)
+			signer.Sign(r, nil)
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			result := &listBucketResult{}
+			if err := xml.Unmarshal(w.Body.Bytes(), result); err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for _, o := range result.Contents {
+				got[o.Key] = o.StorageClass
+			}
+			want := map[string]string{"standard": "STANDARD", "reduced": "REDUCED_REDUNDANCY"}
+			if !maps.Equal(got, want) {
+				t.Errorf("got storage classes: '%v', want storage classes: '%v'", got, want)
+			}
+		})
+	}
+}
+
+func TestContentEncodingHeader(t *testing.T) {
+	var tests = []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"aws-chunked", ""},
+		{"aws-chunked,gzip", "gzip"},
+		{"gzip, br", "gzip,br"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			if got := contentEncoding(test.header); got != test.want {
+				t.Errorf("got encoding: '%s', want encoding: '%s'", got, test.want)
+			}
+		})
+	}
+}
+
+func TestObjectMetadata(t *testing.T) {
+	body := []byte("hello world!")
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	for name, storage := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			s := New("8000", domain.NewAuth("test-access-key", "test-secret-key"), storage)
+
+			r := httptest.NewRequest("PUT", "/bucket/dir/hello.txt", bytes.NewReader(body))
+			signer.Sign(r, body)
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("got status: '%d', want status: '%d' (%s)", w.Code, http.StatusNoContent, w.Body.String())
+			}
+
+			r = httptest.NewRequest("GET", "/bucket/dir/hello.txt?metadata", nil)
+			signer.Sign(r, nil)
+			w = httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status: '%d', want status: '%d' (%s)", w.Code, http.StatusOK, w.Body.String())
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("got content type: '%s', want content type: 'application/json'", got)
+			}
+
+			meta := map[string]any{}
+			if err := json.Unmarshal(w.Body.Bytes(), &meta); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]any{
+				"content_sha256": domain.Sha256Hash(body),
+				"content_size":   float64(len(body)),
+				"etag":           domain.ETag(body),
+				"original_key":   "dir/hello.txt",
+			}
+			for field, value := range want {
+				if meta[field] != value {
+					t.Errorf("got %s: '%v', want %s: '%v'", field, meta[field], field, value)
+				}
+			}
+			if modified, _ := meta["last_modified"].(float64); modified < 1 {
+				t.Errorf("got last_modified: '%v', want a unix timestamp", meta["last_modified"])
+			}
+
+			// the metadata is not readable without a signature
+			r = httptest.NewRequest("GET", "/bucket/dir/hello.txt?metadata", nil)
+			w = httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code == http.StatusOK {
+				t.Errorf("got status: '%d', want unsigned request to be rejected", w.Code)
+			}
+		})
+	}
+}
+
+func TestObjectLock(t *testing.T) {
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second).Format(time.RFC3339)
+	retention := `<Retention xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Mode>GOVERNANCE</Mode><RetainUntilDate>` + until + `</RetainUntilDate></Retention>`
+	var tests = []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		admin  bool
+		bypass bool
+		status int
+	}{
+		{"no retention", "GET", "/bucket/key?retention", "", false, false, http.StatusNotFound},
+		{"invalid mode", "PUT", "/bucket/key?retention", `<Retention xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Mode>FOREVER</Mode><RetainUntilDate>` + until + `</RetainUntilDate></Retention>`, false, false, http.StatusBadRequest},
+		{"invalid date", "PUT", "/bucket/key?retention", `<Retention xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Mode>GOVERNANCE</Mode><RetainUntilDate>tomorrow</RetainUntilDate></Retention>`, false, false, http.StatusBadRequest},
+		{"governance", "PUT", "/bucket/key?retention", retention, false, false, http.StatusOK},
+		{"retention", "GET", "/bucket/key?retention", "", false, false, http.StatusOK},
+		{"delete", "DELETE", "/bucket/key", "", false, false, http.StatusForbidden},
+		{"delete with bypass", "DELETE", "/bucket/key", "", false, true, http.StatusForbidden},
+		{"legal hold", "PUT", "/bucket/key?legal-hold", `<LegalHold xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Status>ON</Status></LegalHold>`, false, false, http.StatusOK},
+		{"invalid legal hold", "PUT", "/bucket/key?legal-hold", `<LegalHold xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Status>MAYBE</Status></LegalHold>`, false, false, http.StatusBadRequest},
+		{"admin delete held", "DELETE", "/bucket/key", "", true, true, http.StatusForbidden},
+		{"release", "PUT", "/bucket/key?legal-hold", `<LegalHold xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Status>OFF</Status></LegalHold>`, false, false, http.StatusOK},
+		{"admin delete without bypass", "DELETE", "/bucket/key", "", true, false, http.StatusForbidden},
+		{"admin delete with bypass", "DELETE", "/bucket/key", "", true, true, http.StatusNoContent},
+	}
+
+	for name, storage := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.Put("bucket", "key", []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			auth := domain.NewAuth("test-access-key", "test-secret-key")
+			auth.AddKey("admin-key", "admin-secret-key")
+			s := New("8000", auth, storage, WithAdminKey("admin-key"))
+
+			for _, test := range tests {
+				r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+				if test.bypass {
+					r.Header.Set("x-amz-bypass-governance-retention", "true")
+				}
+				signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+				if test.admin {
+					signer = domain.NewSigner("admin-key", "admin-secret-key", "us-east-1")
+				}
+				signer.Sign(r, []byte(test.body))
+				w := httptest.NewRecorder()
+				s.Handler().ServeHTTP(w, r)
+				if w.Code != test.status {
+					t.Errorf("%s: got status: '%d', want status: '%d' (%s)", test.name, w.Code, test.status, w.Body.String())
+				}
+				if test.name == "retention" && !strings.Contains(w.Body.String(), "<RetainUntilDate>"+until+"</RetainUntilDate>") {
+					t.Errorf("got body: '%s', want retain until date: '%s'", w.Body.String(), until)
+				}
+			}
+		})
+	}
+}
+
+func TestRepairBucket(t *testing.T) {
+	path := t.TempDir()
+	storage, err := domain.NewStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("bucket", "key", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := filepath.Glob(filepath.Join(path, "bucket", "*", "metadata.json"))
+	if err != nil || len(metadata) != 1 {
+		t.Fatalf("got metadata files: '%v', error: '%v', want one file", metadata, err)
+	}
+	if err := os.Remove(metadata[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name   string
+		admin  bool
+		status int
+		want   string
+	}{
+		{"owner", false, http.StatusForbidden, "AccessDenied"},
+		{"admin", true, http.StatusOK, "<Size>5</Size>"},
+		{"nothing left", true, http.StatusOK, "<RepairResult></RepairResult>"},
+	}
+	auth := domain.NewAuth("test-access-key", "test-secret-key")
+	auth.AddKey("admin-key", "admin-secret-key")
+	s := New("8000", auth, storage, WithAdminKey("admin-key"))
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/bucket?repair", nil)
+		signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+		if test.admin {
+			signer = domain.NewSigner("admin-key", "admin-secret-key", "us-east-1")
+		}
+		signer.Sign(r, nil)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: got status: '%d', want status: '%d'", test.name, w.Code, test.status)
+		}
+		if !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("%s: got body: '%s', want body containing: '%s'", test.name, w.Body.String(), test.want)
+		}
+	}
+	if _, err := storage.Get("bucket", "key"); err != nil {
+		t.Errorf("got error: '%v', want repaired object", err)
+	}
+}
+
+func TestBucketLimitsAdminOnly(t *testing.T) {
+	var tests = []struct {
+		name   string
+		path   string
+		body   string
+		admin  bool
+		status int
+	}{
+		{"quota by owner", "/bucket?quota", "<BucketQuota><Bytes>0</Bytes></BucketQuota>", false, http.StatusForbidden},
+		{"max object size by owner", "/bucket?maxObjectSize", "<MaxObjectSize><Bytes>0</Bytes></MaxObjectSize>", false, http.StatusForbidden},
+		{"max objects by owner", "/bucket?maxObjects", "<MaxObjects><Count>0</Count></MaxObjects>", false, http.StatusForbidden},
+		{"quota by admin", "/bucket?quota", "<BucketQuota><Bytes>5</Bytes></BucketQuota>", true, http.StatusOK},
+		{"max object size by admin", "/bucket?maxObjectSize", "<MaxObjectSize><Bytes>5</Bytes></MaxObjectSize>", true, http.StatusOK},
+		{"max objects by admin", "/bucket?maxObjects", "<MaxObjects><Count>5</Count></MaxObjects>", true, http.StatusOK},
+	}
+
+	for name, storage := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			auth := domain.NewAuth("test-access-key", "test-secret-key")
+			auth.AddKey("admin-key", "admin-secret-key")
+			s := New("8000", auth, storage, WithAdminKey("admin-key"))
+			for _, test := range tests {
+				r := httptest.NewRequest("PUT", test.path, strings.NewReader(test.body))
+				signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+				if test.admin {
+					signer = domain.NewSigner("admin-key", "admin-secret-key", "us-east-1")
+				}
+				signer.Sign(r, []byte(test.body))
+				w := httptest.NewRecorder()
+				s.Handler().ServeHTTP(w, r)
+				if w.Code != test.status {
+					t.Errorf("%s: got status: '%d', want status: '%d'", test.name, w.Code, test.status)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyObject(t *testing.T) {
+	expires := "Thu, 01 Dec 2033 16:00:00 GMT"
+	var tests = []struct {
+		name         string
+		key          string
+		source       string
+		directive    string
+		cacheControl string
+		status       int
+		wantCache    string
+		wantExpires  string
+	}{
+		{"default directive", "default", "/bucket/source.txt", "", "no-cache", http.StatusOK, "max-age=3600", expires},
+		{"copy", "copy", "bucket/source.txt", "COPY", "no-cache", http.StatusOK, "max-age=3600", expires},
+		{"replace", "replace", "/bucket/source.txt", "REPLACE", "no-cache", http.StatusOK, "no-cache", ""},
+		{"encoded source", "encoded", "/bucket/source%2Etxt", "", "", http.StatusOK, "max-age=3600", expires},
+		{"invalid directive", "invalid", "/bucket/source.txt", "MERGE", "", http.StatusBadRequest, "", ""},
+		{"missing source", "missing", "/bucket/missing.txt", "", "", http.StatusNotFound, "", ""},
+		{"copy onto itself", "source.txt", "/bucket/source.txt", "COPY", "", http.StatusBadRequest, "", ""},
+		{"replace onto itself", "source.txt", "/bucket/source.txt", "REPLACE", "max-age=60", http.StatusOK, "max-age=60", ""},
+	}
+
+	body := []byte("hello world!")
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	for name, storage := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			opts := domain.PutOptions{CacheControl: "max-age=3600", Expires: expires}
+			if err := storage.PutObject(context.Background(), "bucket", "source.txt", body, opts); err != nil {
+				t.Fatal(err)
+			}
+			s := New("8000", domain.NewAuth("test-access-key", "test-secret-key"), storage)
+
+			for _, test := range tests {
+				r := httptest.NewRequest("PUT", "/bucket/"+test.key, nil)
+				r.Header.Set("Content-Length", "0")
+				r.Header.Set("x-amz-copy-source", test.source)
+				if len(test.directive) > 0 {
+					r.Header.Set("x-amz-metadata-directive", test.directive)
+				}
+				if len(test.cacheControl) > 0 {
+					r.Header.Set("Cache-Control", test.cacheControl)
+				}
+				signer.Sign(r, nil)
+				w := httptest.NewRecorder()
+				s.Handler().ServeHTTP(w, r)
+				if w.Code != test.status {
+					t.Errorf("%s: got status: '%d', want status: '%d' (%s)", test.name, w.Code, test.status, w.Body.String())
+					continue
+				}
+				if test.status != http.StatusOK {
+					continue
+				}
+				result := &copyObjectResult{}
+				if err := xml.Unmarshal(w.Body.Bytes(), result); err != nil {
+					t.Fatal(err)
+				}
+				if result.ETag != domain.ETag(body) {
+					t.Errorf("%s: got etag: '%s', want etag: '%s'", test.name, result.ETag, domain.ETag(body))
+				}
+
+				data, info, err := storage.GetVersionCtx(context.Background(), "bucket", test.key, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(data, body) {
+					t.Errorf("%s: got body: '%s', want body: '%s'", test.name, data, body)
+				}
+				if info.CacheControl != test.wantCache {
+					t.Errorf("%s: got cache control: '%s', want cache control: '%s'", test.name, info.CacheControl, test.wantCache)
+				}
+				if info.Expires != test.wantExpires {
+					t.Errorf("%s: got expires: '%s', want expires: '%s'", test.name, info.Expires, test.wantExpires)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyObjectWriteOnly(t *testing.T) {
+	storage := domain.NewMemStorage()
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put("bucket", "source.txt", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	auth := domain.NewAuth("test-access-key", "test-secret-key")
+	auth.AddKeyWithPermissions("write-access-key", "write-secret-key", domain.PermWrite)
+	s := New("8000", auth, storage)
+
+	r := httptest.NewRequest("PUT", "/bucket/copy.txt", nil)
+	r.Header.Set("Content-Length", "0")
+	r.Header.Set("x-amz-copy-source", "/bucket/source.txt")
+	domain.NewSigner("write-access-key", "write-secret-key", "us-east-1").Sign(r, nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "AccessDenied") {
+		t.Errorf("got status: '%d', body: '%s', want status: '%d'", w.Code, w.Body.String(), http.StatusForbidden)
+	}
+	if _, err := storage.Get("bucket", "copy.txt"); err == nil {
+		t.Error("got error: '<nil>', want no copy written")
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	for name, storage := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			s := New("8000", domain.NewAuth("test-access-key", "test-secret-key"), storage)
+			send := func(method, target string, body []byte) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(method, target, bytes.NewReader(body))
+				r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+				signer.Sign(r, body)
+				w := httptest.NewRecorder()
+				s.Handler().ServeHTTP(w, r)
+				return w
+			}
+
+			w := send("POST", "/bucket/key?uploads", nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status: '%d', want status: '%d' (%s)", w.Code, http.StatusOK, w.Body.String())
+			}
+			initiated := &initiateMultipartUploadResult{}
+			if err := xml.Unmarshal(w.Body.Bytes(), initiated); err != nil {
+				t.Fatal(err)
+			}
+
+			w = send("GET", "/bucket?uploads", nil)
+			if !strings.Contains(w.Body.String(), "<UploadId>"+initiated.UploadId+"</UploadId>") {
+				t.Errorf("got body: '%s', want upload: '%s'", w.Body.String(), initiated.UploadId)
+			}
+
+			etags := []string{}
+			for i, part := range []string{"hello ", "world!"} {
+				w := send("PUT", fmt.Sprintf("/bucket/key?partNumber=%d&uploadId=%s", i+1, initiated.UploadId), []byte(part))
+				if w.Code != http.StatusOK {
+					t.Fatalf("got status: '%d', want status: '%d' (%s)", w.Code, http.StatusOK, w.Body.String())
+				}
+				etags = append(etags, w.Header().Get("ETag"))
+			}
+			complete := fmt.Sprintf(`<CompleteMultipartUpload xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`+
+				`<Part><PartNumber>1</PartNumber><ETag>%s</ETag></Part>`+
+				`<Part><PartNumber>2</PartNumber><ETag>%s</ETag></Part>`+
+				`</CompleteMultipartUpload>`, etags[0], etags[1])
+			w = send("POST", "/bucket/key?uploadId="+initiated.UploadId, []byte(complete))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status: '%d', want status: '%d' (%s)", w.Code, http.StatusOK, w.Body.String())
+			}
+			if body, err := storage.Get("bucket", "key"); err != nil || string(body) != "hello world!" {
+				t.Errorf("got body: '%s', error: '%v', want body: 'hello world!'", body, err)
+			}
+
+			// the entity tag is the MD5 of the MD5s of the parts and their count
+			hash := md5.New()
+			for _, part := range []string{"hello ", "world!"} {
+				sum := md5.Sum([]byte(part))
+				hash.Write(sum[:])
+			}
+			etag := fmt.Sprintf(`"%s-2"`, hex.EncodeToString(hash.Sum(nil)))
+			completed := &completeMultipartUploadResult{}
+			if err := xml.Unmarshal(w.Body.Bytes(), completed); err != nil {
+				t.Fatal(err)
+			}
+			if completed.ETag != etag {
+				t.Errorf("got etag: '%s', want etag: '%s'", completed.ETag, etag)
+			}
+			for _, method := range []string{"HEAD", "GET"} {
+				if got := send(method, "/bucket/key", nil).Header().Get("ETag"); got != etag {
+					t.Errorf("%s: got etag: '%s', want etag: '%s'", method, got, etag)
+				}
+			}
+
+			// an aborted upload is gone
+			w = send("POST", "/bucket/other?uploads", nil)
+			if err := xml.Unmarshal(w.Body.Bytes(), initiated); err != nil {
+				t.Fatal(err)
+			}
+			if w := send("DELETE", "/bucket/other?uploadId="+initiated.UploadId, nil); w.Code != http.StatusNoContent {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, http.StatusNoContent)
+			}
+			w = send("PUT", "/bucket/other?partNumber=1&uploadId="+initiated.UploadId, []byte("a"))
+			if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchUpload") {
+				t.Errorf("got status: '%d', want status: '%d'", w.Code, http.StatusNotFound)
+			}
+			if w := send("GET", "/bucket?uploads", nil); strings.Contains(w.Body.String(), "<Upload>") {
+				t.Errorf("got body: '%s', want no uploads", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestChecksumHeader(t *testing.T) {
+	var tests = []struct {
+		name   string
+		method string
+		header string
+		want   bool
+	}{
+		{"get", "GET", "", true},
+		{"head", "HEAD", "", true},
+		{"get range", "GET", "bytes=0-4", false},
+		{"head range", "HEAD", "bytes=0-4", false},
+	}
+
+	body := []byte("hello world!")
+	signer := domain.NewSigner("test-access-key", "test-secret-key", "us-east-1")
+	for name, storage := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.Put("bucket", "key", body); err != nil {
+				t.Fatal(err)
+			}
+			info, err := storage.Head("bucket", "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			hash, err := hex.DecodeString(info.ContentHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := base64.StdEncoding.EncodeToString(hash)
+			s := New("8000", domain.NewAuth("test-access-key", "test-secret-key"), storage)
+
+			for _, test := range tests {
+				r := httptest.NewRequest(test.method, "/bucket/key", nil)
+				if len(test.header) > 0 {
+					r.Header.Set("Range", test.header)
+				}
+				signer.Sign(r, nil)
+				w := httptest.NewRecorder()
+				s.Handler().ServeHTTP(w, r)
+				got := w.Header().Get("x-amz-checksum-sha256")
+				if test.want && got != want {
+					t.Errorf("%s: got checksum: '%s', want checksum: '%s'", test.name, got, want)
+				} else if !test.want && len(got) > 0 {
+					t.Errorf("%s: got checksum: '%s', want no checksum", test.name, got)
+				}
+			}
+		})
+	}
+}
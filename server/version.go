@@ -0,0 +1,33 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// The build information is injected at build time, e.g.
+// go build -ldflags "-X github.com/kfc-manager/bucket/server.version=v1.2.0".
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+}
+
+// serveVersion reports which build is running, so a deployment can be
+// confirmed without credentials.
+func (s *server) serveVersion(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(&buildInfo{Version: version, Commit: commit, BuildDate: buildDate})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
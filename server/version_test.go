@@ -0,0 +1,29 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	defer func(v, c, d string) { version, commit, buildDate = v, c, d }(version, commit, buildDate)
+	version, commit, buildDate = "v1.2.0", "9ae9057", "2026-10-15T12:00:00Z"
+
+	s := newTestServer(t)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/version", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status: '%d', want status: '%d' (%s)", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	got := &buildInfo{}
+	if err := json.Unmarshal(w.Body.Bytes(), got); err != nil {
+		t.Fatal(err)
+	}
+	want := buildInfo{Version: "v1.2.0", Commit: "9ae9057", BuildDate: "2026-10-15T12:00:00Z"}
+	if *got != want {
+		t.Errorf("got build info: '%+v', want build info: '%+v'", *got, want)
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+// serveWebsite answers anonymous GET requests of a public bucket with a
+// website configuration. The bucket root and keys ending in "/" resolve to the
+// index document, missing objects are answered with the error document.
+func (s *server) serveWebsite(w http.ResponseWriter, r *http.Request, config *domain.WebsiteConfiguration) {
+	bucket := r.PathValue("name")
+	key := r.PathValue("key")
+	if len(key) < 1 || strings.HasSuffix(key, "/") {
+		key += config.IndexDocument
+	}
+
+	status := http.StatusOK
+	data, info, err := s.storage.GetVersionCtx(r.Context(), bucket, key, "")
+	if domErr, ok := err.(*domain.Error); ok && domErr.Code == "NoSuchKey" && len(config.ErrorDocument) > 0 {
+		status = http.StatusNotFound
+		key = config.ErrorDocument
+		data, info, err = s.storage.GetVersionCtx(r.Context(), bucket, key, "")
+		if err != nil {
+			// a missing error document is reported as the original error
+			err = domErr
+		}
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("ETag", info.ETag)
+	storedHeaders(w, info)
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -1,5 +1,6 @@
 import os
 import boto3
+from botocore import UNSIGNED
 from botocore.client import Config
 
 
@@ -41,3 +42,122 @@ def test_delete_object():
         assert False, "Expected an exception when accessing a deleted object"
     except Exception:
         pass
+
+
+def test_list_buckets():
+    bucket_name = "test-list-buckets"
+
+    s3.create_bucket(Bucket=bucket_name)
+    response = s3.list_buckets()
+
+    assert bucket_name in [b["Name"] for b in response["Buckets"]]
+
+
+def test_get_missing_object():
+    bucket_name = "test-get-missing-object"
+
+    s3.create_bucket(Bucket=bucket_name)
+    try:
+        s3.get_object(Bucket=bucket_name, Key="missing.txt")
+        assert False, "Expected an exception when accessing a missing object"
+    except s3.exceptions.NoSuchKey:
+        pass
+
+
+def test_versioning():
+    bucket_name = "test-versioning"
+    object_key = "test.txt"
+
+    s3.create_bucket(Bucket=bucket_name)
+    s3.put_bucket_versioning(
+        Bucket=bucket_name, VersioningConfiguration={"Status": "Enabled"}
+    )
+    s3.put_object(Bucket=bucket_name, Key=object_key, Body=b"first")
+    s3.put_object(Bucket=bucket_name, Key=object_key, Body=b"second")
+
+    versions = s3.list_object_versions(Bucket=bucket_name)["Versions"]
+    assert len(versions) == 2
+
+    bodies = [
+        s3.get_object(Bucket=bucket_name, Key=object_key, VersionId=v["VersionId"])[
+            "Body"
+        ].read()
+        for v in versions
+    ]
+    assert bodies == [b"second", b"first"]
+
+
+def test_create_bucket_owned_by_you():
+    bucket_name = "test-create-bucket-owned-by-you"
+
+    s3.create_bucket(Bucket=bucket_name)
+    try:
+        s3.create_bucket(Bucket=bucket_name)
+        assert False, "Expected an exception when recreating a bucket"
+    except s3.exceptions.BucketAlreadyOwnedByYou:
+        pass
+
+
+def test_delete_missing_object():
+    bucket_name = "test-delete-missing-object"
+
+    s3.create_bucket(Bucket=bucket_name)
+    response = s3.delete_object(Bucket=bucket_name, Key="missing.txt")
+    assert response["ResponseMetadata"]["HTTPStatusCode"] == 204
+
+
+def test_list_objects_v2_pagination():
+    bucket_name = "test-list-objects-v2-pagination"
+
+    s3.create_bucket(Bucket=bucket_name)
+    for key in ["a", "b", "c"]:
+        s3.put_object(Bucket=bucket_name, Key=key, Body=key.encode())
+
+    first = s3.list_objects_v2(Bucket=bucket_name, MaxKeys=2)
+    assert [o["Key"] for o in first["Contents"]] == ["a", "b"]
+    assert first["IsTruncated"]
+
+    second = s3.list_objects_v2(
+        Bucket=bucket_name, MaxKeys=2, ContinuationToken=first["NextContinuationToken"]
+    )
+    assert [o["Key"] for o in second["Contents"]] == ["c"]
+    assert not second["IsTruncated"]
+
+
+def test_public_read_bucket():
+    bucket_name = "test-public-read-bucket"
+    object_key = "test.txt"
+
+    s3.create_bucket(Bucket=bucket_name)
+    s3.put_object(Bucket=bucket_name, Key=object_key, Body=b"public")
+    anonymous = boto3.client(
+        "s3",
+        endpoint_url=f"http://{os.getenv('HOST')}",
+        config=Config(signature_version=UNSIGNED),
+        region_name="us-east-1",
+    )
+    try:
+        anonymous.get_object(Bucket=bucket_name, Key=object_key)
+        assert False, "Expected an exception when reading a private bucket"
+    except anonymous.exceptions.ClientError:
+        pass
+
+    s3.put_bucket_acl(Bucket=bucket_name, ACL="public-read")
+    response = anonymous.get_object(Bucket=bucket_name, Key=object_key)
+    assert response["Body"].read() == b"public"
+
+
+def test_put_if_none_match():
+    bucket_name = "test-put-if-none-match"
+    object_key = "once.txt"
+
+    s3.create_bucket(Bucket=bucket_name)
+    s3.put_object(Bucket=bucket_name, Key=object_key, Body=b"first", IfNoneMatch="*")
+    try:
+        s3.put_object(Bucket=bucket_name, Key=object_key, Body=b"second", IfNoneMatch="*")
+        assert False, "Expected an exception when overwriting an existing object"
+    except s3.exceptions.ClientError as e:
+        assert e.response["Error"]["Code"] == "PreconditionFailed"
+
+    response = s3.get_object(Bucket=bucket_name, Key=object_key)
+    assert response["Body"].read() == b"first"
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+// verify checks the integrity of every object of a data directory without
+// serving it, e.g. "bucket verify -data ./backup". The storage options are
+// read from the same environment variables as for serving, ENCRYPTION_KEY is
+// needed to read encrypted objects. It returns the exit code: 0 if all objects
+// are intact, 1 if any is corrupt or could not be checked and 2 for invalid
+// arguments.
+func verify(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.SetOutput(out)
+	path := flags.String("data", "./data", "data directory of the storage")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	storage, err := domain.NewStorage(*path, storageOptions()...)
+	if err != nil {
+		fmt.Fprintf(out, "could not open storage: %s\n", err)
+		return 1
+	}
+	defer storage.Close()
+
+	checked, corrupt, err := storage.Verify(context.Background())
+	for _, c := range corrupt {
+		fmt.Fprintf(out, "CORRUPT bucket '%s' key '%s' at '%s': %s\n", c.Bucket, c.Key, c.Dir, c.Reason)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "could not verify storage: %s\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "checked %d object versions, %d corrupt\n", checked, len(corrupt))
+	if len(corrupt) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kfc-manager/bucket/domain"
+)
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := domain.NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.NewBucket("bucket", "test-access-key"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err := storage.Put("bucket", key, []byte("hello world!")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if code := verify([]string{"-data", dir}, &out); code != 0 {
+		t.Fatalf("got exit code: '%d', want exit code: '0' (%s)", code, out.String())
+	}
+
+	// plant a corrupt object by overwriting one of the bodies
+	bodies, err := filepath.Glob(filepath.Join(dir, "bucket", "*", "body"))
+	if err != nil || len(bodies) != 2 {
+		t.Fatalf("got bodies: '%v', want bodies: '2' (%v)", bodies, err)
+	}
+	if err := os.WriteFile(bodies[0], []byte("hello w0rld!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	if code := verify([]string{"-data", dir}, &out); code != 1 {
+		t.Errorf("got exit code: '%d', want exit code: '1'", code)
+	}
+	if !strings.Contains(out.String(), "CORRUPT bucket 'bucket'") {
+		t.Errorf("got report: '%s', want report of the corrupt object", out.String())
+	}
+
+	if code := verify([]string{"-unknown"}, &out); code != 2 {
+		t.Errorf("got exit code: '%d', want exit code: '2'", code)
+	}
+}